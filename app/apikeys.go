@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/db"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/storage"
+	"text/tabwriter"
+	"time"
+)
+
+const apiKeyUsage = "usage: apikey create NAME SCOPES | list | revoke ID (SCOPES — comma-separated read, write, admin)"
+
+// MustManageAPIKeys выполняет команду управления ключами доступа: create NAME SCOPES, list или revoke ID
+func MustManageAPIKeys(args []string) error {
+	cfg := config.NewAppConfig()
+	if err := cfg.DBCfg.Load(); err != nil {
+		return err
+	}
+	if cfg.DBCfg.Type == config.DBTypeMemory {
+		return fmt.Errorf("API keys cannot be managed from the command line with DB_TYPE=%s, use AUTH_ENABLED=false or a persistent database", config.DBTypeMemory)
+	}
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	dbManager := db.NewDBManager()
+	if err := dbManager.InitDB(cfg.DBCfg); err != nil {
+		return err
+	}
+	defer dbManager.Close()
+
+	migrator, err := db.NewMigrator(dbManager.DB, cfg.DBCfg.Type)
+	if err != nil {
+		return err
+	}
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	if version < migrator.Latest() {
+		return fmt.Errorf("database schema version %d is behind %d, run migrate up first", version, migrator.Latest())
+	}
+
+	var keyStorage manager.SubscriptionStorage = storage.NewSQL(dbManager.DB)
+	if cfg.DBCfg.Type == config.DBTypeSQLite {
+		keyStorage = storage.NewSQLite(dbManager.DB)
+	}
+	keyManager := manager.New(keyStorage, cfg.DBCfg.QueryTimeout)
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "create" && len(args) == 3:
+		key, secret, err := keyManager.CreateAPIKey(ctx, args[1], strings.Split(args[2], ","))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %d %q with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println("Store the key now, it will not be shown again:")
+		fmt.Println(secret)
+	case args[0] == "list" && len(args) == 1:
+		keys, err := keyManager.GetAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tLAST USED\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","),
+				key.CreatedAt.Format(time.RFC3339), formatOptionalTime(key.LastUsedAt), formatOptionalTime(key.RevokedAt))
+		}
+		return w.Flush()
+	case args[0] == "revoke" && len(args) == 2:
+		key, err := keyManager.RevokeAPIKey(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %d %q\n", key.ID, key.Name)
+	default:
+		return errors.New(apiKeyUsage)
+	}
+
+	return nil
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -2,6 +2,10 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
 	"subscription-aggregator-api/config"
 	"subscription-aggregator-api/db"
 	"subscription-aggregator-api/manager"
@@ -18,16 +22,125 @@ func MustStart() error {
 		return err
 	}
 
+	var subscriptionStorage manager.SubscriptionStorage
+	var rateLimits server.RateLimitStore
+	switch cfg.DBCfg.Type {
+	case config.DBTypeMemory:
+		if cfg.SrvCfg.RateLimitStore == config.RateLimitStoreDatabase {
+			return fmt.Errorf("SERVER_RATE_LIMIT_STORE=%s requires a database, got DB_TYPE=%s",
+				config.RateLimitStoreDatabase, config.DBTypeMemory)
+		}
+		// ключи доступа выпускаются из командной строки в базу, поэтому в памяти остаются только токены
+		if cfg.AuthCfg.Enabled && !cfg.AuthCfg.JWTConfigured() {
+			return fmt.Errorf("AUTH_ENABLED=true with DB_TYPE=%s requires AUTH_JWT_SECRET or AUTH_JWT_JWKS_FILE, "+
+				"API keys cannot be issued for in-memory storage; set AUTH_ENABLED=false for local runs", config.DBTypeMemory)
+		}
+		subscriptionStorage = storage.NewMemory()
+		slog.Info("Using in-memory storage, data will not be persisted")
+	default:
+		dbManager := db.NewDBManager()
+		if err := dbManager.InitDB(cfg.DBCfg); err != nil {
+			return err
+		}
+		defer dbManager.Close()
+
+		if err := prepareSchema(dbManager, cfg.DBCfg); err != nil {
+			return err
+		}
+
+		var sqlStorage *storage.SQLStorage
+		if cfg.DBCfg.Type == config.DBTypeSQLite {
+			sqlStorage = storage.NewSQLite(dbManager.DB)
+		} else {
+			sqlStorage = storage.NewSQL(dbManager.DB)
+		}
+		subscriptionStorage = sqlStorage
+		if cfg.SrvCfg.RateLimitStore == config.RateLimitStoreDatabase {
+			rateLimits = sqlStorage
+		}
+	}
+
+	subscriptionManager := manager.New(subscriptionStorage, cfg.DBCfg.QueryTimeout)
+	subscriptionManager.SetUniquenessPolicy(cfg.DBCfg.UniquenessPolicy)
+	subscriptionManager.SetRequireVersion(cfg.SrvCfg.RequireIfMatch)
+	go subscriptionManager.RunPurgeJob(ctx, cfg.DBCfg.DeletedRetention, cfg.DBCfg.PurgeInterval)
+
+	server := server.Init(ctx, subscriptionManager, cfg.SrvCfg, cfg.AuthCfg, rateLimits)
+
+	return server.MustRun()
+}
+
+func prepareSchema(dbManager *db.DBManager, dbCfg config.DBConfig) error {
+	migrator, err := db.NewMigrator(dbManager.DB, dbCfg.Type)
+	if err != nil {
+		return err
+	}
+
+	if dbCfg.AutoMigrate {
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		slog.Info("Database migrations completed", "applied", applied, "version", migrator.Latest())
+	}
+
+	return migrator.Check()
+}
+
+// MustMigrate выполняет команду миграции: up, down [N] или version
+func MustMigrate(args []string) error {
+	cfg := config.NewAppConfig()
+	if err := cfg.DBCfg.Load(); err != nil {
+		return err
+	}
+	if cfg.DBCfg.Type == config.DBTypeMemory {
+		return fmt.Errorf("migrations are not applicable to DB_TYPE=%s", config.DBTypeMemory)
+	}
+
 	dbManager := db.NewDBManager()
 	if err := dbManager.InitDB(cfg.DBCfg); err != nil {
 		return err
 	}
 	defer dbManager.Close()
-	sqlStorage := storage.NewSQL(dbManager.DB)
 
-	subscriptionManager := manager.New(sqlStorage)
+	migrator, err := db.NewMigrator(dbManager.DB, cfg.DBCfg.Type)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [N] | version")
+	}
 
-	server := server.Init(ctx, subscriptionManager)
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrations applied", "count", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("down steps must be a positive integer, got: %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrations reverted", "count", reverted)
+	case "version":
+		version, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		slog.Info("Database schema version", "version", version, "latest", migrator.Latest())
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or version", args[0])
+	}
 
-	return server.MustRun(cfg.SrvCfg)
+	return nil
 }
@@ -2,10 +2,24 @@ package main
 
 import (
 	"log"
+	"os"
 	"subscription-aggregator-api/app"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := app.MustMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := app.MustManageAPIKeys(os.Args[2:]); err != nil {
+			log.Fatalf("API key command failed: %v", err)
+		}
+		return
+	}
+
 	if err := app.MustStart(); err != nil {
 		log.Fatalf("App not started: %v", err)
 		return
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 )
 
 type Config interface {
@@ -12,37 +13,89 @@ type Config interface {
 }
 
 type ServerConfig struct {
-	Host string `env:"SERVER_HOST"`
-	Port int    `env:"SERVER_PORT"`
+	Host           string `env:"SERVER_HOST"`
+	Port           int    `env:"SERVER_PORT"`
+	RequireIfMatch bool   `env:"SERVER_REQUIRE_IF_MATCH"`
+	// RateLimitRead, RateLimitWrite и RateLimitAdmin ограничивают запросы одного клиента к группам маршрутов
+	// с правами read, write и admin
+	RateLimitRead  RateLimit `env:"SERVER_RATE_LIMIT_READ" envDefault:"600/1m"`
+	RateLimitWrite RateLimit `env:"SERVER_RATE_LIMIT_WRITE" envDefault:"120/1m"`
+	RateLimitAdmin RateLimit `env:"SERVER_RATE_LIMIT_ADMIN" envDefault:"60/1m"`
+	// RateLimitAuth ограничивает неудачные попытки аутентификации с одного IP-адреса
+	RateLimitAuth RateLimit `env:"SERVER_RATE_LIMIT_AUTH" envDefault:"10/1m"`
+	// RateLimitStore — где хранятся вёдра: в памяти процесса или в базе, общей для нескольких экземпляров
+	RateLimitStore string `env:"SERVER_RATE_LIMIT_STORE" envDefault:"memory"`
+	// TrustForwardedFor берёт адрес клиента из X-Forwarded-For; включайте только за доверенным прокси
+	TrustForwardedFor bool `env:"SERVER_TRUST_FORWARDED_FOR"`
+	// TrustedProxies — число доверенных прокси перед сервисом: адресом клиента считается запись X-Forwarded-For,
+	// которую добавил самый дальний из них, то есть TrustedProxies-я справа. Записи левее неё подделываются клиентом
+	TrustedProxies int `env:"SERVER_TRUSTED_PROXIES" envDefault:"1"`
+	// IdempotencyTTL — сколько хранится ключ Idempotency-Key и ответ на запрос с ним
+	IdempotencyTTL time.Duration `env:"SERVER_IDEMPOTENCY_TTL" envDefault:"24h"`
 }
 
+const (
+	DBTypePostgres = "postgres"
+	DBTypeSQLite   = "sqlite"
+	DBTypeMemory   = "memory"
+
+	SQLiteInMemoryPath = ":memory:"
+)
+
 type DBConfig struct {
-	Type     string `env:"DB_TYPE"`
-	Host     string `env:"DB_HOST"`
-	Port     int    `env:"DB_PORT"`
-	User     string `env:"DB_USER"`
-	Password string `env:"DB_PASSWORD"`
-	Name     string `env:"DB_NAME"`
-	SSLMode  string `env:"DB_SSLMODE"`
+	Type         string        `env:"DB_TYPE"`
+	Host         string        `env:"DB_HOST"`
+	Port         int           `env:"DB_PORT"`
+	User         string        `env:"DB_USER"`
+	Password     string        `env:"DB_PASSWORD"`
+	Name         string        `env:"DB_NAME"`
+	SSLMode      string        `env:"DB_SSLMODE"`
+	Path         string        `env:"DB_PATH"`
+	AutoMigrate  bool          `env:"DB_AUTO_MIGRATE"`
+	QueryTimeout time.Duration `env:"DB_QUERY_TIMEOUT" envDefault:"5s"`
+	// DeletedRetention — срок хранения мягко удалённых подписок до окончательной очистки
+	DeletedRetention time.Duration `env:"DB_DELETED_RETENTION" envDefault:"720h"`
+	PurgeInterval    time.Duration `env:"DB_PURGE_INTERVAL" envDefault:"1h"`
+	// UniquenessPolicy — правило уникальности действующих подписок пользователя на один сервис
+	UniquenessPolicy string `env:"DB_UNIQUENESS_POLICY" envDefault:"same_start"`
+}
+
+// AuthConfig описывает проверку клиентов API
+type AuthConfig struct {
+	// Enabled включает проверку ключей доступа и токенов; без неё API открыт всем
+	Enabled bool `env:"AUTH_ENABLED" envDefault:"true"`
+	// JWTSecret — общий секрет для токенов HS256, JWKSFile — файл с открытыми ключами для токенов RS256
+	JWTSecret    string `env:"AUTH_JWT_SECRET"`
+	JWKSFile     string `env:"AUTH_JWT_JWKS_FILE"`
+	JWTIssuer    string `env:"AUTH_JWT_ISSUER"`
+	JWTAudience  string `env:"AUTH_JWT_AUDIENCE"`
+	JWTAdminRole string `env:"AUTH_JWT_ADMIN_ROLE" envDefault:"admin"`
+}
+
+// JWTConfigured сообщает, заданы ли настройки проверки JWT
+func (authCfg AuthConfig) JWTConfigured() bool {
+	return authCfg.JWTSecret != "" || authCfg.JWKSFile != ""
 }
 
 type AppConfig struct {
 	once    sync.Once
 	SrvCfg  ServerConfig
 	DBCfg   DBConfig
+	AuthCfg AuthConfig
 	loadErr error
 }
 
 func NewAppConfig() *AppConfig {
 	return &AppConfig{
-		SrvCfg: ServerConfig{},
-		DBCfg:  DBConfig{},
+		SrvCfg:  ServerConfig{},
+		DBCfg:   DBConfig{},
+		AuthCfg: AuthConfig{},
 	}
 }
 
 func (appcfg *AppConfig) MustLoad() error {
 	appcfg.once.Do(func() {
-		configs := []Config{&appcfg.SrvCfg, &appcfg.DBCfg}
+		configs := []Config{&appcfg.SrvCfg, &appcfg.DBCfg, &appcfg.AuthCfg}
 		for _, c := range configs {
 			if err := c.Load(); err != nil {
 				slog.Error("Error loading configuration", "error", err)
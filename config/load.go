@@ -32,3 +32,13 @@ func (srvCfg *ServerConfig) Load() error {
 	}
 	return nil
 }
+
+func (authCfg *AuthConfig) Load() error {
+	if err := env.Parse(authCfg); err != nil {
+		return fmt.Errorf("error loading AuthConfig from env: %w", err)
+	}
+	if err := authCfg.Validate(); err != nil {
+		return fmt.Errorf("error validating AuthConfig: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStoreDatabase = "database"
+)
+
+// RateLimit задаёт ведро маркеров: Requests запросов за Period, ведро восполняется равномерно.
+// В переменных окружения записывается как "N/duration" (например, "600/1m"); "off" отключает ограничение
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l *RateLimit) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" || value == "off" {
+		*l = RateLimit{}
+		return nil
+	}
+
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("rate limit must be in format N/duration or off, got: %q", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("rate limit requests must be a positive integer, got: %q", requests)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("rate limit period must be a positive duration, got: %q", period)
+	}
+
+	*l = RateLimit{Requests: n, Period: d}
+	return nil
+}
+
+// Enabled сообщает, задано ли ограничение
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0
+}
+
+// Rate возвращает скорость восполнения ведра в маркерах в секунду
+func (l RateLimit) Rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l RateLimit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
@@ -5,9 +5,40 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"subscription-aggregator-api/models"
 )
 
 func (dbCfg *DBConfig) Validate() error {
+	validDBTypes := []string{DBTypePostgres, DBTypeSQLite, DBTypeMemory}
+	isAllowed := slices.Contains(validDBTypes, dbCfg.Type)
+	if !isAllowed {
+		return fmt.Errorf("DB_TYPE must be one of: %s", strings.Join(validDBTypes, ", "))
+	}
+
+	if dbCfg.QueryTimeout <= 0 {
+		return fmt.Errorf("DB_QUERY_TIMEOUT must be positive, got: %s", dbCfg.QueryTimeout)
+	}
+
+	if dbCfg.DeletedRetention <= 0 {
+		return fmt.Errorf("DB_DELETED_RETENTION must be positive, got: %s", dbCfg.DeletedRetention)
+	}
+	if dbCfg.PurgeInterval <= 0 {
+		return fmt.Errorf("DB_PURGE_INTERVAL must be positive, got: %s", dbCfg.PurgeInterval)
+	}
+	if !slices.Contains(models.UniquenessPolicies, dbCfg.UniquenessPolicy) {
+		return fmt.Errorf("DB_UNIQUENESS_POLICY must be one of: %s", strings.Join(models.UniquenessPolicies, ", "))
+	}
+
+	switch dbCfg.Type {
+	case DBTypeMemory:
+		return nil
+	case DBTypeSQLite:
+		if strings.TrimSpace(dbCfg.Path) == "" {
+			return fmt.Errorf("DB_PATH is required for DB_TYPE=%s (file path or %s)", DBTypeSQLite, SQLiteInMemoryPath)
+		}
+		return nil
+	}
+
 	if dbCfg.Port <= 0 || dbCfg.Port > 65535 {
 		return fmt.Errorf("DB_PORT must be in the range 1-65535, got: %d", dbCfg.Port)
 	}
@@ -33,12 +64,6 @@ func (dbCfg *DBConfig) Validate() error {
 		return fmt.Errorf("DB_SSLMODE must be one of: %s", strings.Join(validSSLModes, ", "))
 	}
 
-	validDBTypes := []string{"postgres", "mysql", "mssql", "sqlite"}
-	isAllowed := slices.Contains(validDBTypes, dbCfg.Type)
-	if !isAllowed {
-		return fmt.Errorf("DB_TYPE must be one of: %s", strings.Join(validDBTypes, ", "))
-	}
-
 	return nil
 }
 
@@ -51,5 +76,32 @@ func (srvCfg *ServerConfig) Validate() error {
 		return errors.New("SERVER_HOST is required")
 	}
 
+	validStores := []string{RateLimitStoreMemory, RateLimitStoreDatabase}
+	if !slices.Contains(validStores, srvCfg.RateLimitStore) {
+		return fmt.Errorf("SERVER_RATE_LIMIT_STORE must be one of: %s", strings.Join(validStores, ", "))
+	}
+
+	if srvCfg.TrustForwardedFor && srvCfg.TrustedProxies <= 0 {
+		return fmt.Errorf("SERVER_TRUSTED_PROXIES must be positive, got: %d", srvCfg.TrustedProxies)
+	}
+
+	if srvCfg.IdempotencyTTL <= 0 {
+		return fmt.Errorf("SERVER_IDEMPOTENCY_TTL must be positive, got: %s", srvCfg.IdempotencyTTL)
+	}
+
+	return nil
+}
+
+// minJWTSecretLength — наименьшая длина секрета HS256 (RFC 7518 требует ключ не короче хеша)
+const minJWTSecretLength = 32
+
+func (authCfg *AuthConfig) Validate() error {
+	if authCfg.JWTSecret != "" && len(authCfg.JWTSecret) < minJWTSecretLength {
+		return fmt.Errorf("AUTH_JWT_SECRET must be at least %d bytes long", minJWTSecretLength)
+	}
+	if strings.TrimSpace(authCfg.JWTAdminRole) == "" {
+		return errors.New("AUTH_JWT_ADMIN_ROLE cannot be empty")
+	}
+
 	return nil
 }
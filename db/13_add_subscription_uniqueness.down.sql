@@ -0,0 +1,7 @@
+ALTER TABLE subscriptions
+DROP CONSTRAINT IF EXISTS subscriptions_no_overlap;
+
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS no_overlap;
+
+DROP INDEX IF EXISTS subscriptions_active_start_key;
@@ -0,0 +1,32 @@
+DO $$
+DECLARE
+    duplicates INT;
+BEGIN
+    SELECT COUNT(*) INTO duplicates
+    FROM (
+        SELECT 1
+        FROM subscriptions
+        WHERE deleted_at IS NULL
+        GROUP BY user_id, service_name, start_date
+        HAVING COUNT(*) > 1
+    ) AS groups;
+
+    IF duplicates > 0 THEN
+        RAISE EXCEPTION 'found % groups of active subscriptions with the same user_id, service_name and start_date; delete or change the duplicates and run the migration again', duplicates;
+    END IF;
+END;
+$$;
+
+CREATE UNIQUE INDEX subscriptions_active_start_key
+ON subscriptions (user_id, service_name, start_date)
+WHERE deleted_at IS NULL;
+
+ALTER TABLE subscriptions
+ADD COLUMN no_overlap BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE subscriptions
+ADD CONSTRAINT subscriptions_no_overlap
+EXCLUDE USING gist (user_id WITH =, service_name WITH =, daterange(start_date, end_date, '[]') WITH &&)
+WHERE (deleted_at IS NULL AND no_overlap);
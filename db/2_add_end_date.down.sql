@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+DROP CONSTRAINT IF EXISTS chk_subscription_end_date;
+
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS end_date;
@@ -0,0 +1,6 @@
+ALTER TABLE subscriptions
+ADD COLUMN end_date DATE;
+
+ALTER TABLE subscriptions
+ADD CONSTRAINT chk_subscription_end_date
+CHECK (end_date IS NULL OR end_date >= start_date);
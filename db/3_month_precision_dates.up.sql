@@ -0,0 +1,3 @@
+UPDATE subscriptions
+SET start_date = DATE_TRUNC('month', start_date)::date,
+    end_date = DATE_TRUNC('month', end_date)::date;
@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS updated_at,
+DROP COLUMN IF EXISTS created_at,
+DROP COLUMN IF EXISTS version;
@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions
+ADD COLUMN version INT NOT NULL DEFAULT 1,
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
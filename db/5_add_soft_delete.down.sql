@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_subscription_deleted_at;
+
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS deleted_at;
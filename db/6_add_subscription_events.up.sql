@@ -0,0 +1,26 @@
+CREATE TABLE subscription_events (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    before_state JSONB,
+    after_state JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_subscription_events_subscription_id
+ON subscription_events(subscription_id, id);
+
+CREATE INDEX idx_subscription_events_created_at
+ON subscription_events(created_at);
+
+CREATE FUNCTION subscription_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'subscription_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscription_events_append_only
+BEFORE UPDATE OR DELETE ON subscription_events
+FOR EACH ROW EXECUTE FUNCTION subscription_events_append_only();
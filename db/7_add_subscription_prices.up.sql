@@ -0,0 +1,13 @@
+CREATE TABLE subscription_prices (
+    id SERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    price INT NOT NULL,
+    effective_from DATE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (subscription_id, effective_from)
+);
+
+INSERT INTO subscription_prices (subscription_id, price, effective_from)
+SELECT id, price, DATE_TRUNC('month', start_date)::date
+FROM subscriptions
+WHERE start_date IS NOT NULL;
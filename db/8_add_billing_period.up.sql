@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+ADD COLUMN billing_period VARCHAR(10) NOT NULL DEFAULT 'month'
+    CHECK (billing_period IN ('week', 'month', 'quarter', 'year')),
+ADD COLUMN billing_interval INT NOT NULL DEFAULT 1
+    CHECK (billing_interval > 0);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,11 @@
+ALTER TABLE subscriptions
+ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB';
+
+CREATE TABLE exchange_rates (
+    id SERIAL PRIMARY KEY,
+    currency CHAR(3) NOT NULL,
+    rate NUMERIC(18, 6) NOT NULL CHECK (rate > 0),
+    effective_from DATE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (currency, effective_from)
+);
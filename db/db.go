@@ -22,6 +22,11 @@ func NewDBManager() *DBManager {
 
 func (dm *DBManager) InitDB(dbCfg config.DBConfig) error {
 	dm.once.Do(func() {
+		if dbCfg.Type == config.DBTypeSQLite {
+			dm.err = dm.initSQLite(dbCfg)
+			return
+		}
+
 		conn := fmt.Sprintf(
 			"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 			dbCfg.Host, dbCfg.Port, dbCfg.User, dbCfg.Password, dbCfg.Name, dbCfg.SSLMode)
@@ -0,0 +1,372 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/config"
+)
+
+//go:embed *.sql sqlite/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrSchemaAhead = errors.New("database schema is newer than this binary supports")
+	// ErrIrreversibleMigration — миграция не может быть отменена, например потому что теряет данные
+	ErrIrreversibleMigration = errors.New("migration is irreversible")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dbType     string
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, dbType string) (*Migrator, error) {
+	dir := "."
+	if dbType == config.DBTypeSQLite {
+		dir = "sqlite"
+	}
+
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, dbType: dbType, migrations: migrations}, nil
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", base)
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", base, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+
+	return migrations, nil
+}
+
+// Latest возвращает версию последней встроенной миграции
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// ensureSchemaTable создаёт таблицу schema_versions и в той же транзакции переносит в неё версию legacy-миграций.
+// Перенос выполняется только при создании таблицы: после отката всех миграций таблица остаётся пустой,
+// и устаревшая legacy-версия не должна снова считаться применённой. Экземпляры, запущенные одновременно,
+// создают таблицу по очереди, и перенос выполняет только первый из них
+func (m *Migrator) ensureSchemaTable() error {
+	exists, err := m.tableExists(m.db, "schema_versions")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// в SQLite транзакцию и так упорядочивает блокировка записи, которую берёт CREATE TABLE
+	if m.dbType != config.DBTypeSQLite {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('schema_versions'));`); err != nil {
+			return fmt.Errorf("failed to lock schema_versions: %w", err)
+		}
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_versions (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+	`
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed to create schema_versions table: %w", err)
+	}
+
+	var adopted bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_versions);`).Scan(&adopted); err != nil {
+		return fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	if !adopted {
+		if err := m.adoptLegacyVersion(tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) appliedVersions() ([]int, error) {
+	if err := m.ensureSchemaTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version FROM schema_versions ORDER BY version;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (m *Migrator) tableExists(q queryRower, table string) (bool, error) {
+	query := `SELECT to_regclass($1) IS NOT NULL;`
+	if m.dbType == config.DBTypeSQLite {
+		query = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1);`
+	}
+
+	var exists bool
+	if err := q.QueryRow(query, table).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", table, err)
+	}
+	return exists, nil
+}
+
+// adoptLegacyVersion переносит версию схемы, применённую до встроенных миграций:
+// таблицу schema_migrations контейнера migrate/migrate для PostgreSQL и PRAGMA user_version для SQLite
+func (m *Migrator) adoptLegacyVersion(tx *sql.Tx) error {
+	legacy, err := m.legacyVersion(tx)
+	if err != nil || legacy == 0 {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > legacy {
+			break
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_versions (version) VALUES ($1);`, migration.Version); err != nil {
+			return fmt.Errorf("failed to record legacy migration %d: %w", migration.Version, err)
+		}
+	}
+
+	slog.Info("Adopted schema version from legacy migrations", "version", legacy)
+	return nil
+}
+
+func (m *Migrator) legacyVersion(tx *sql.Tx) (int, error) {
+	var version int
+
+	if m.dbType == config.DBTypeSQLite {
+		if err := tx.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+			return 0, fmt.Errorf("failed to read legacy schema version: %w", err)
+		}
+		return version, nil
+	}
+
+	exists, err := m.tableExists(tx, "schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var dirty bool
+	err = tx.QueryRow(`SELECT version, dirty FROM schema_migrations LIMIT 1;`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read legacy schema version: %w", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("legacy schema_migrations is dirty at version %d, fix it manually", version)
+	}
+
+	return version, nil
+}
+
+// Version возвращает версию последней применённой миграции
+func (m *Migrator) Version() (int, error) {
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Check возвращает ErrSchemaAhead, если в базе применены миграции, неизвестные бинарнику
+func (m *Migrator) Check() error {
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: schema version %d, latest known %d", ErrSchemaAhead, current, m.Latest())
+	}
+	if current < m.Latest() {
+		slog.Warn("Database schema is behind, run migrations", "version", current, "latest", m.Latest())
+	}
+	return nil
+}
+
+// Up применяет недостающие миграции. Каждая миграция сначала записывается в schema_versions в своей транзакции,
+// поэтому при одновременном запуске нескольких экземпляров миграцию выполняет один, а остальные дожидаются
+// его транзакции и пропускают её
+func (m *Migrator) Up() (int, error) {
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) > 0 && versions[len(versions)-1] > m.Latest() {
+		return 0, fmt.Errorf("%w: schema version %d, latest known %d", ErrSchemaAhead, versions[len(versions)-1], m.Latest())
+	}
+
+	applied := 0
+	for _, migration := range m.migrations {
+		if slices.Contains(versions, migration.Version) {
+			continue
+		}
+		claimed, err := m.apply(migration.Up, `INSERT INTO schema_versions (version) VALUES ($1) ON CONFLICT (version) DO NOTHING;`, migration.Version)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %s: %w", migration.Name, err)
+		}
+		if !claimed {
+			slog.Info("Migration already applied by another instance", "version", migration.Version, "name", migration.Name)
+			continue
+		}
+		slog.Info("Migration applied", "version", migration.Version, "name", migration.Name)
+		applied++
+	}
+
+	return applied, nil
+}
+
+// Down откатывает steps последних миграций. Если среди них есть необратимая миграция без down-скрипта,
+// ни одна миграция не откатывается
+func (m *Migrator) Down(steps int) (int, error) {
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+
+	var revert []Migration
+	for i := len(versions) - 1; i >= 0 && len(revert) < steps; i-- {
+		idx := slices.IndexFunc(m.migrations, func(migration Migration) bool { return migration.Version == versions[i] })
+		if idx < 0 {
+			return 0, fmt.Errorf("%w: cannot revert unknown migration %d", ErrSchemaAhead, versions[i])
+		}
+		migration := m.migrations[idx]
+		if migration.Down == "" {
+			return 0, fmt.Errorf("%w: migration %s has no down script, the schema cannot be reverted below version %d",
+				ErrIrreversibleMigration, migration.Name, migration.Version)
+		}
+		revert = append(revert, migration)
+	}
+
+	reverted := 0
+	for _, migration := range revert {
+		claimed, err := m.apply(migration.Down, `DELETE FROM schema_versions WHERE version = $1;`, migration.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("failed to revert migration %s: %w", migration.Name, err)
+		}
+		if !claimed {
+			slog.Info("Migration already reverted by another instance", "version", migration.Version, "name", migration.Name)
+			continue
+		}
+		slog.Info("Migration reverted", "version", migration.Version, "name", migration.Name)
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// apply выполняет bookkeeping и script в одной транзакции. Запись в schema_versions идёт первой и блокирует строку
+// версии до конца транзакции; если она ничего не изменила, миграцию уже выполнил другой экземпляр,
+// и apply возвращает false, не выполняя script
+func (m *Migrator) apply(script, bookkeeping string, version int) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(bookkeeping, version)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/db"
+	"sync"
+	"testing"
+)
+
+func newSQLiteMigrator(t *testing.T, path string) *db.Migrator {
+	t.Helper()
+
+	dm := db.NewDBManager()
+	if err := dm.InitDB(config.DBConfig{Type: config.DBTypeSQLite, Path: path}); err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+
+	migrator, err := db.NewMigrator(dm.DB, config.DBTypeSQLite)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	return migrator
+}
+
+func TestMigratorConcurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.db")
+	migrators := []*db.Migrator{newSQLiteMigrator(t, path), newSQLiteMigrator(t, path), newSQLiteMigrator(t, path)}
+
+	applied := make([]int, len(migrators))
+	errs := make([]error, len(migrators))
+	var wg sync.WaitGroup
+	for i, migrator := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = migrator.Up()
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("migrator %d: %v", i, err)
+		}
+		total += applied[i]
+	}
+	latest := migrators[0].Latest()
+	if total != latest {
+		t.Errorf("migrations applied %d times in total, want %d", total, latest)
+	}
+	version, err := migrators[0].Version()
+	if err != nil {
+		t.Fatalf("version: %v", err)
+	}
+	if version != latest {
+		t.Errorf("version = %d, want %d", version, latest)
+	}
+}
+
+func TestMigratorDownRefusesIrreversible(t *testing.T) {
+	migrator := newSQLiteMigrator(t, filepath.Join(t.TempDir(), "migrate.db"))
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	latest := migrator.Latest()
+	_, err := migrator.Down(latest)
+	if !errors.Is(err, db.ErrIrreversibleMigration) {
+		t.Fatalf("down past version 3: err = %v, want ErrIrreversibleMigration", err)
+	}
+	version, err := migrator.Version()
+	if err != nil {
+		t.Fatalf("version: %v", err)
+	}
+	if version != latest {
+		t.Errorf("refused down reverted migrations: version = %d, want %d", version, latest)
+	}
+
+	reverted, err := migrator.Down(latest - 3)
+	if err != nil {
+		t.Fatalf("down to version 3: %v", err)
+	}
+	if reverted != latest-3 {
+		t.Errorf("reverted = %d, want %d", reverted, latest-3)
+	}
+	if version, _ := migrator.Version(); version != 3 {
+		t.Errorf("version = %d, want 3", version)
+	}
+}
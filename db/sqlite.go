@@ -0,0 +1,38 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"subscription-aggregator-api/config"
+
+	_ "modernc.org/sqlite"
+)
+
+func (dm *DBManager) initSQLite(dbCfg config.DBConfig) error {
+	conn := dbCfg.Path
+	if conn != config.SQLiteInMemoryPath {
+		conn = "file:" + conn
+	}
+	conn += "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+
+	db, err := sql.Open("sqlite", conn)
+	if err != nil {
+		slog.Error("Failed to open database", "err", err)
+		return fmt.Errorf("could not open database connection: %w", err)
+	}
+	// SQLite допускает одного писателя, а база ":memory:" существует только в рамках соединения
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		slog.Error("Database ping error", "err", err)
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	dm.DB = db
+	slog.Info("Connected to database", "type", dbCfg.Type, "path", dbCfg.Path)
+	return nil
+}
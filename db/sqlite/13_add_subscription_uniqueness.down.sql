@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS subscriptions_no_overlap_update;
+
+DROP TRIGGER IF EXISTS subscriptions_no_overlap_insert;
+
+ALTER TABLE subscriptions
+DROP COLUMN no_overlap;
+
+DROP INDEX IF EXISTS subscriptions_active_start_key;
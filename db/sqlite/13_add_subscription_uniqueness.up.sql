@@ -0,0 +1,57 @@
+CREATE TEMP TABLE subscription_duplicates (groups INTEGER NOT NULL);
+
+CREATE TEMP TRIGGER subscription_duplicates_check
+BEFORE INSERT ON subscription_duplicates
+WHEN NEW.groups > 0
+BEGIN
+    SELECT RAISE(ABORT, 'found active subscriptions with the same user_id, service_name and start_date; delete or change the duplicates and run the migration again');
+END;
+
+INSERT INTO subscription_duplicates (groups)
+SELECT COUNT(*)
+FROM (
+    SELECT 1
+    FROM subscriptions
+    WHERE deleted_at IS NULL
+    GROUP BY user_id, service_name, start_date
+    HAVING COUNT(*) > 1
+);
+
+DROP TABLE subscription_duplicates;
+
+CREATE UNIQUE INDEX subscriptions_active_start_key
+ON subscriptions (user_id, service_name, start_date)
+WHERE deleted_at IS NULL;
+
+ALTER TABLE subscriptions
+ADD COLUMN no_overlap BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TRIGGER subscriptions_no_overlap_insert
+BEFORE INSERT ON subscriptions
+WHEN NEW.no_overlap AND NEW.deleted_at IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'subscriptions_no_overlap')
+    WHERE EXISTS (
+        SELECT 1
+        FROM subscriptions
+        WHERE no_overlap AND deleted_at IS NULL
+            AND user_id = NEW.user_id AND service_name = NEW.service_name
+            AND start_date <= COALESCE(NEW.end_date, '9999-12-01')
+            AND COALESCE(end_date, '9999-12-01') >= NEW.start_date
+    );
+END;
+
+CREATE TRIGGER subscriptions_no_overlap_update
+BEFORE UPDATE OF user_id, service_name, start_date, end_date, deleted_at, no_overlap ON subscriptions
+WHEN NEW.no_overlap AND NEW.deleted_at IS NULL
+BEGIN
+    SELECT RAISE(ABORT, 'subscriptions_no_overlap')
+    WHERE EXISTS (
+        SELECT 1
+        FROM subscriptions
+        WHERE id <> NEW.id AND no_overlap AND deleted_at IS NULL
+            AND user_id = NEW.user_id AND service_name = NEW.service_name
+            AND start_date <= COALESCE(NEW.end_date, '9999-12-01')
+            AND COALESCE(end_date, '9999-12-01') >= NEW.start_date
+    );
+END;
@@ -0,0 +1,10 @@
+CREATE TABLE subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT,
+    service_name VARCHAR(50) NOT NULL,
+    price INTEGER NOT NULL,
+    start_date DATE
+);
+
+CREATE INDEX idx_subscription_price
+ON subscriptions(price);
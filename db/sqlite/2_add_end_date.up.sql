@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions
+ADD COLUMN end_date DATE CHECK (end_date IS NULL OR end_date >= start_date);
@@ -0,0 +1,3 @@
+UPDATE subscriptions
+SET start_date = strftime('%Y-%m-01', start_date),
+    end_date = strftime('%Y-%m-01', end_date);
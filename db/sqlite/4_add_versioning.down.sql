@@ -0,0 +1,8 @@
+ALTER TABLE subscriptions
+DROP COLUMN updated_at;
+
+ALTER TABLE subscriptions
+DROP COLUMN created_at;
+
+ALTER TABLE subscriptions
+DROP COLUMN version;
@@ -0,0 +1,12 @@
+ALTER TABLE subscriptions
+ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE subscriptions
+ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+ALTER TABLE subscriptions
+ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+UPDATE subscriptions
+SET created_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,6 @@
+ALTER TABLE subscriptions
+ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX idx_subscription_deleted_at
+ON subscriptions(deleted_at)
+WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS subscription_events_no_delete;
+
+DROP TRIGGER IF EXISTS subscription_events_no_update;
+
+DROP TABLE IF EXISTS subscription_events;
@@ -0,0 +1,28 @@
+CREATE TABLE subscription_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    before_state TEXT,
+    after_state TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_subscription_events_subscription_id
+ON subscription_events(subscription_id, id);
+
+CREATE INDEX idx_subscription_events_created_at
+ON subscription_events(created_at);
+
+CREATE TRIGGER subscription_events_no_update
+BEFORE UPDATE ON subscription_events
+BEGIN
+    SELECT RAISE(ABORT, 'subscription_events is append-only');
+END;
+
+CREATE TRIGGER subscription_events_no_delete
+BEFORE DELETE ON subscription_events
+BEGIN
+    SELECT RAISE(ABORT, 'subscription_events is append-only');
+END;
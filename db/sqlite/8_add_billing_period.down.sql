@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+DROP COLUMN billing_interval;
+
+ALTER TABLE subscriptions
+DROP COLUMN billing_period;
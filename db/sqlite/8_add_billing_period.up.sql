@@ -0,0 +1,7 @@
+ALTER TABLE subscriptions
+ADD COLUMN billing_period TEXT NOT NULL DEFAULT 'month'
+    CHECK (billing_period IN ('week', 'month', 'quarter', 'year'));
+
+ALTER TABLE subscriptions
+ADD COLUMN billing_interval INTEGER NOT NULL DEFAULT 1
+    CHECK (billing_interval > 0);
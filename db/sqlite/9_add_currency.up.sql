@@ -0,0 +1,11 @@
+ALTER TABLE subscriptions
+ADD COLUMN currency TEXT NOT NULL DEFAULT 'RUB';
+
+CREATE TABLE exchange_rates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    currency TEXT NOT NULL,
+    rate REAL NOT NULL CHECK (rate > 0),
+    effective_from DATE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (currency, effective_from)
+);
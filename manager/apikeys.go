@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+const (
+	// APIKeyPrefix начинает каждый ключ доступа, чтобы его было легко узнать в конфигурации и логах
+	APIKeyPrefix = "sa_"
+
+	apiKeyBytes = 32
+	// apiKeyPrefixLength — длина начала ключа, которое хранится открыто
+	apiKeyPrefixLength = len(APIKeyPrefix) + 8
+	// apiKeyTouchInterval ограничивает запись времени использования ключа, чтобы не писать в базу на каждый запрос
+	apiKeyTouchInterval = time.Minute
+)
+
+// CreateAPIKey выпускает ключ доступа с правами scopes; сам ключ возвращается только здесь, в базе хранится его хеш
+func (m *Manager) CreateAPIKey(ctx context.Context, name string, scopes []string) (models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.APIKey{}, "", &BadRequestError{msg: ErrAPIKeyNameEmpty.Error()}
+	}
+	parsedScopes, err := parseScopes(scopes)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return models.APIKey{}, "", err
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	key, err := m.storage.CreateAPIKey(ctx, models.APIKey{
+		Name:   name,
+		Prefix: secret[:apiKeyPrefixLength],
+		Scopes: parsedScopes,
+	}, hashAPIKey(secret))
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return key, secret, nil
+}
+
+func (m *Manager) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.GetAPIKeys(ctx)
+}
+
+// RevokeAPIKey отзывает ключ доступа; запросы с ним перестают проходить сразу
+func (m *Manager) RevokeAPIKey(ctx context.Context, id string) (models.APIKey, error) {
+	parsedID, err := strconv.Atoi(id)
+	if err != nil || parsedID <= 0 {
+		return models.APIKey{}, &BadRequestError{msg: ErrInvalidAPIKeyID.Error()}
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.RevokeAPIKey(ctx, parsedID)
+}
+
+// AuthenticateAPIKey проверяет ключ доступа и возвращает его владельца; неизвестный и отозванный ключи
+// неотличимы для клиента и дают ErrUnauthenticated
+func (m *Manager) AuthenticateAPIKey(ctx context.Context, secret string) (models.Principal, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return models.Principal{}, ErrUnauthenticated
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	key, ok, err := m.storage.FindAPIKey(ctx, hashAPIKey(secret))
+	if err != nil {
+		return models.Principal{}, err
+	}
+	if !ok || key.RevokedAt != nil {
+		return models.Principal{}, ErrUnauthenticated
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := m.storage.TouchAPIKey(ctx, key.ID, now); err != nil {
+			slog.Warn("Failed to record API key usage", "id", key.ID, "error", err)
+		}
+	}
+
+	return models.Principal{ID: "api_key:" + strconv.Itoa(key.ID), Name: key.Name, Scopes: key.Scopes}, nil
+}
+
+// parseScopes приводит права к нижнему регистру, убирает повторы и упорядочивает как models.Scopes
+func parseScopes(scopes []string) ([]string, error) {
+	var parsed []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(models.Scopes, scope) {
+			return nil, &BadRequestError{msg: ErrInvalidScope.Error()}
+		}
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, &BadRequestError{msg: ErrAPIKeyScopesEmpty.Error()}
+	}
+
+	slices.SortFunc(parsed, func(a, b string) int {
+		return slices.Index(models.Scopes, a) - slices.Index(models.Scopes, b)
+	})
+	return parsed, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"context"
+	"slices"
+	"subscription-aggregator-api/models"
+)
+
+// MaxBatchSize — наибольшее число операций в одном пакетном запросе
+const MaxBatchSize = 100
+
+// SetRequireVersion требует от операций update пакета ожидаемую версию подписки, как SERVER_REQUIRE_IF_MATCH от PUT
+func (m *Manager) SetRequireVersion(required bool) {
+	m.requireVersion = required
+}
+
+// ExecuteBatch проверяет операции пакета и выполняет их в хранилище. В режиме atomic (по умолчанию)
+// ошибка проверки любой операции отменяет весь пакет; в режиме best_effort выполняются только корректные операции.
+// Ошибки отдельных операций возвращаются в результатах
+func (m *Manager) ExecuteBatch(ctx context.Context, mode string, ops []models.BatchOperation) ([]models.BatchResult, error) {
+	if mode == "" {
+		mode = models.BatchModeAtomic
+	}
+	if !slices.Contains(models.BatchModes, mode) {
+		return nil, &BadRequestError{msg: ErrInvalidBatchMode.Error()}
+	}
+	if len(ops) == 0 {
+		return nil, &BadRequestError{msg: ErrBatchEmpty.Error()}
+	}
+	if len(ops) > MaxBatchSize {
+		return nil, &BadRequestError{msg: ErrBatchTooLarge.Error()}
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	results := make([]models.BatchResult, len(ops))
+	prepared := make([]models.BatchOperation, len(ops))
+	for i, op := range ops {
+		var err error
+		prepared[i], err = prepareBatchOp(ctx, op, m.requireVersion)
+		if err == nil && op.Op != models.BatchOpCreate {
+			err = m.authorizeSubscription(ctx, op.ID)
+		}
+		if err != nil {
+			results[i] = models.BatchResult{Op: op.Op, ID: op.ID, Err: err}
+			continue
+		}
+		if subscription := prepared[i].Subscription; subscription != nil {
+			subscription.ID = 0
+			if op.Op == models.BatchOpUpdate {
+				subscription.ID = op.ID
+			}
+			m.markUniqueness(subscription)
+		}
+	}
+	if err := m.checkBatchUniqueness(ctx, prepared, results); err != nil {
+		return nil, err
+	}
+
+	valid := make([]models.BatchOperation, 0, len(ops))
+	// positions[i] — номер valid[i] в исходном пакете
+	positions := make([]int, 0, len(ops))
+	for i, op := range prepared {
+		if results[i].Err == nil {
+			valid = append(valid, op)
+			positions = append(positions, i)
+		}
+	}
+
+	atomic := mode == models.BatchModeAtomic
+	if atomic && len(valid) < len(ops) {
+		for _, i := range positions {
+			results[i] = models.BatchResult{Op: ops[i].Op, ID: ops[i].ID, Err: models.ErrBatchRolledBack}
+		}
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	applied, err := m.storage.Batch(ctx, valid, atomic)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range applied {
+		result.Err = m.conflictError(ctx, result.Err)
+		results[positions[i]] = result
+	}
+
+	return results, nil
+}
+
+// checkBatchUniqueness отмечает в results операции create и update, подписки которых конфликтуют с действующими
+// подписками вне пакета. Конфликты между операциями пакета зависят от их порядка, их отклоняют ограничения базы
+func (m *Manager) checkBatchUniqueness(ctx context.Context, ops []models.BatchOperation, results []models.BatchResult) error {
+	touched := make(map[int]bool)
+	var keys []models.ServiceKey
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+		if op.Op != models.BatchOpCreate {
+			touched[op.ID] = true
+		}
+		if op.Subscription != nil {
+			keys = append(keys, op.Subscription.Key())
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	active, err := m.storage.FindActive(ctx, keys)
+	if err != nil {
+		return err
+	}
+	active = slices.DeleteFunc(active, func(subscription models.Subscription) bool { return touched[subscription.ID] })
+
+	for i, op := range ops {
+		if results[i].Err != nil || op.Subscription == nil {
+			continue
+		}
+		if existing, ok := m.findConflict(*op.Subscription, active); ok {
+			results[i] = models.BatchResult{Op: op.Op, ID: op.ID, Err: &models.ConflictError{ID: existing.ID, Policy: m.uniquenessPolicy()}}
+		}
+	}
+	return nil
+}
+
+// prepareBatchOp проверяет операцию и подставляет значения по умолчанию в подписку, как одиночные запросы
+func prepareBatchOp(ctx context.Context, op models.BatchOperation, requireVersion bool) (models.BatchOperation, error) {
+	if !slices.Contains(models.BatchOps, op.Op) {
+		return models.BatchOperation{}, &BadRequestError{msg: ErrInvalidBatchOp.Error()}
+	}
+	if op.Op != models.BatchOpCreate && op.ID <= 0 {
+		return models.BatchOperation{}, &BadRequestError{msg: ErrIDEmpty.Error()}
+	}
+	if op.Op == models.BatchOpDelete {
+		return op, nil
+	}
+	if op.Op == models.BatchOpUpdate {
+		if op.Version < 0 {
+			return models.BatchOperation{}, &BadRequestError{msg: ErrInvalidBatchVersion.Error()}
+		}
+		if op.Version == 0 && requireVersion {
+			return models.BatchOperation{}, ErrBatchVersionRequired
+		}
+	}
+
+	if op.Subscription == nil {
+		return models.BatchOperation{}, &BadRequestError{msg: ErrBatchSubscriptionMissing.Error()}
+	}
+	subscription := *op.Subscription
+	if err := scopeSubscription(ctx, &subscription); err != nil {
+		return models.BatchOperation{}, err
+	}
+	applyDefaults(&subscription)
+	if err := validateSubscription(subscription); err != nil {
+		return models.BatchOperation{}, err
+	}
+	op.Subscription = &subscription
+
+	return op, nil
+}
@@ -0,0 +1,66 @@
+package manager_test
+
+import (
+	"errors"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestExecuteBatchVersion(t *testing.T) {
+	subscription := func() *models.Subscription {
+		return &models.Subscription{UserID: uuid.MustParse("6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b"), ServiceName: "Yandex Plus", Price: 400, StartDate: models.NewMonth(2025, time.July)}
+	}
+
+	tests := []struct {
+		name           string
+		requireVersion bool
+		version        int
+		wantErr        error
+	}{
+		{"without version", false, 0, nil},
+		{"matching version", false, 1, nil},
+		{"stale version", false, 2, storage.ErrVersionMismatch},
+		{"required version missing", true, 0, manager.ErrBatchVersionRequired},
+		{"required version present", true, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := manager.New(storage.NewMemory(), time.Second)
+			m.SetRequireVersion(tt.requireVersion)
+			created, err := m.CreateSubscription(t.Context(), *subscription())
+			if err != nil {
+				t.Fatalf("CreateSubscription() error = %v", err)
+			}
+
+			results, err := m.ExecuteBatch(t.Context(), models.BatchModeAtomic, []models.BatchOperation{
+				{Op: models.BatchOpUpdate, ID: created.ID, Version: tt.version, Subscription: subscription()},
+			})
+			if err != nil {
+				t.Fatalf("ExecuteBatch() error = %v", err)
+			}
+			if !errors.Is(results[0].Err, tt.wantErr) {
+				t.Fatalf("ExecuteBatch() result error = %v, want %v", results[0].Err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteBatchNegativeVersion(t *testing.T) {
+	m := manager.New(storage.NewMemory(), time.Second)
+
+	results, err := m.ExecuteBatch(t.Context(), models.BatchModeBestEffort, []models.BatchOperation{
+		{Op: models.BatchOpUpdate, ID: 1, Version: -1, Subscription: &models.Subscription{}},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteBatch() error = %v", err)
+	}
+	var badRequest *manager.BadRequestError
+	if !errors.As(results[0].Err, &badRequest) {
+		t.Fatalf("ExecuteBatch() result error = %v, want BadRequestError", results[0].Err)
+	}
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+const weeksPerYear = 52
+
+// monthsPerPeriod — длительность периода оплаты в месяцах; недельная оплата считается по дням
+var monthsPerPeriod = map[string]int{
+	models.BillingPeriodMonth:   1,
+	models.BillingPeriodQuarter: 3,
+	models.BillingPeriodYear:    12,
+}
+
+// applyDefaults приводит код валюты к верхнему регистру и подставляет базовую валюту
+// и ежемесячную оплату, если они не указаны
+func applyDefaults(subscription *models.Subscription) {
+	if subscription.Currency == "" {
+		subscription.Currency = models.BaseCurrency
+	} else if currency, err := models.ParseCurrency(subscription.Currency); err == nil {
+		subscription.Currency = currency
+	}
+	if subscription.BillingPeriod == "" {
+		subscription.BillingPeriod = models.BillingPeriodMonth
+	}
+	if subscription.BillingInterval == 0 {
+		subscription.BillingInterval = 1
+	}
+}
+
+// applyCosts рассчитывает стоимость каждого отрезка по выбранному методу в валюте конвертера и общую сумму
+func applyCosts(items []models.SumItem, basis string, c converter) (models.SumReport, error) {
+	report := models.SumReport{Basis: basis, Currency: c.target, Items: items}
+
+	for i := range report.Items {
+		item := &report.Items[i]
+		cost, charges, err := c.convertCost(*item, basis)
+		if err != nil {
+			return models.SumReport{}, err
+		}
+		item.Cost = cost
+		item.Charges = charges
+		report.TotalSum += item.Cost
+	}
+
+	return report, nil
+}
+
+// accrualAmount распределяет цену цикла оплаты равномерно по месяцам отрезка
+func accrualAmount(item models.SumItem) float64 {
+	numerator := float64(item.Price * item.Months)
+	denominator := float64(item.BillingInterval)
+	if item.BillingPeriod == models.BillingPeriodWeek {
+		numerator *= weeksPerYear
+		denominator *= 12
+	} else {
+		denominator *= float64(monthsPerPeriod[item.BillingPeriod])
+	}
+
+	return numerator / denominator
+}
+
+// cashFlowCharges считает списания, приходящиеся на месяцы отрезка; первое списание — в месяц начала подписки
+func cashFlowCharges(item models.SumItem) int {
+	if item.BillingPeriod == models.BillingPeriodWeek {
+		return weeklyCharges(item)
+	}
+
+	cycle := monthsPerPeriod[item.BillingPeriod] * item.BillingInterval
+	// смещения в месяцах от начала подписки до границ отрезка
+	first := item.StartDate.MonthsUntil(item.From) - 1
+	last := item.StartDate.MonthsUntil(item.To) - 1
+
+	return countMultiples(first, last, cycle)
+}
+
+// weeklyCharges считает недельные списания, отсчитываемые от первого дня месяца начала подписки
+func weeklyCharges(item models.SumItem) int {
+	const day = 24 * time.Hour
+
+	start := item.StartDate.Time()
+	first := int(item.From.Time().Sub(start) / day)
+	last := int(item.To.AddMonths(1).Time().Sub(start)/day) - 1
+
+	return countMultiples(first, last, 7*item.BillingInterval)
+}
+
+// countMultiples возвращает число кратных step на отрезке [from, to] при from >= 0
+func countMultiples(from, to, step int) int {
+	if to < from {
+		return 0
+	}
+	return to/step - (from+step-1)/step + 1
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"subscription-aggregator-api/models"
+)
+
+// converter пересчитывает суммы в валюту отчёта по курсам, действующим в каждом месяце
+type converter struct {
+	target string
+	// rates — курсы каждой валюты по возрастанию месяца начала действия
+	rates map[string][]models.ExchangeRate
+}
+
+func newConverter(target string, rates []models.ExchangeRate) converter {
+	c := converter{target: target, rates: map[string][]models.ExchangeRate{}}
+	for _, rate := range rates {
+		c.rates[rate.Currency] = append(c.rates[rate.Currency], rate)
+	}
+	return c
+}
+
+// rate возвращает курс валюты к базовой, действующий в месяце month
+func (c converter) rate(currency string, month models.Month) (float64, error) {
+	if currency == models.BaseCurrency {
+		return 1, nil
+	}
+
+	rates := c.rates[currency]
+	for i := len(rates) - 1; i >= 0; i-- {
+		if !rates[i].EffectiveFrom.After(month) {
+			return rates[i].Rate, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s for %s", ErrExchangeRateMissing, currency, month)
+}
+
+// factor возвращает множитель пересчёта из валюты currency в валюту отчёта в месяце month
+func (c converter) factor(currency string, month models.Month) (float64, error) {
+	if currency == c.target {
+		return 1, nil
+	}
+
+	source, err := c.rate(currency, month)
+	if err != nil {
+		return 0, err
+	}
+	target, err := c.rate(c.target, month)
+	if err != nil {
+		return 0, err
+	}
+
+	return source / target, nil
+}
+
+// split делит отрезок по месяцам смены курсов его валюты и валюты отчёта, чтобы внутри частей курс не менялся
+func (c converter) split(item models.SumItem) []models.SumItem {
+	if item.Currency == c.target {
+		return []models.SumItem{item}
+	}
+
+	var starts []models.Month
+	for _, currency := range []string{item.Currency, c.target} {
+		for _, rate := range c.rates[currency] {
+			if rate.EffectiveFrom.After(item.From) && !rate.EffectiveFrom.After(item.To) {
+				starts = append(starts, rate.EffectiveFrom)
+			}
+		}
+	}
+	slices.SortFunc(starts, func(a, b models.Month) int {
+		return a.Time().Compare(b.Time())
+	})
+	starts = slices.Compact(starts)
+
+	parts := make([]models.SumItem, 0, len(starts)+1)
+	part := item
+	for _, start := range starts {
+		part.To = start.AddMonths(-1)
+		parts = append(parts, part)
+		part.From = start
+	}
+	part.To = item.To
+
+	return append(parts, part)
+}
+
+// convertCost рассчитывает стоимость отрезка в валюте отчёта и число списаний при расчёте по движению денег
+func (c converter) convertCost(item models.SumItem, basis string) (cost, charges int, err error) {
+	var total float64
+	for _, part := range c.split(item) {
+		factor, err := c.factor(item.Currency, part.From)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		part.Months = part.From.MonthsUntil(part.To)
+		var amount float64
+		if basis == models.SumBasisCashFlow {
+			partCharges := cashFlowCharges(part)
+			charges += partCharges
+			amount = float64(item.Price * partCharges)
+		} else {
+			amount = accrualAmount(part)
+		}
+		total += amount * factor
+	}
+
+	return int(math.Round(total)), charges, nil
+}
@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"subscription-aggregator-api/models"
+)
+
+// ExportSubscriptions проверяет формат и фильтры списка и возвращает подписки для выгрузки.
+// Размер страницы и курсор не учитываются. Ограничение времени запроса к хранилищу тоже не применяется:
+// выгрузка длится, пока клиент читает ответ, и прерывается отменой ctx
+func (m *Manager) ExportSubscriptions(ctx context.Context, format string, params ListParams) (iter.Seq2[models.Subscription, error], error) {
+	if format == "" {
+		format = models.ExportFormatCSV
+	}
+	if !slices.Contains(models.ExportFormats, format) {
+		return nil, &BadRequestError{msg: ErrInvalidExportFormat.Error()}
+	}
+
+	params.Limit, params.Cursor = "", ""
+	filter, err := parseListParams(params)
+	if err != nil {
+		return nil, &BadRequestError{msg: err.Error()}
+	}
+	if err := scopeFilter(ctx, &filter.UserID); err != nil {
+		return nil, err
+	}
+
+	return m.storage.Iterate(ctx, filter), nil
+}
@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+// MaxIdempotencyKeyLength — наибольшая длина ключа идемпотентности
+const MaxIdempotencyKeyLength = 255
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be 1-" + strconv.Itoa(MaxIdempotencyKeyLength) +
+		" printable ASCII characters")
+	// ErrIdempotencyKeyReused — ключ уже использован для запроса с другим методом, путём или телом
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+	// ErrIdempotencyKeyInProgress — запрос с тем же ключом ещё выполняется
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+// IdempotentRequest описывает запрос с заголовком Idempotency-Key
+type IdempotentRequest struct {
+	Key    string
+	Method string
+	// Path — путь запроса вместе со строкой запроса
+	Path string
+	// IfMatch — заголовок If-Match: тот же запрос к другой версии подписки считается другим запросом
+	IfMatch string
+	Body    []byte
+	// TTL — сколько ключ хранится после первого запроса
+	TTL time.Duration
+}
+
+// BeginIdempotentRequest занимает ключ запроса для текущего клиента. Если запрос с этим ключом уже выполнен,
+// возвращает сохранённый ответ; иначе возвращает nil, и после выполнения запроса нужно вызвать
+// CompleteIdempotentRequest или ReleaseIdempotentRequest
+func (m *Manager) BeginIdempotentRequest(ctx context.Context, request IdempotentRequest) (*models.StoredResponse, error) {
+	if !validIdempotencyKey(request.Key) {
+		return nil, &BadRequestError{msg: ErrInvalidIdempotencyKey.Error()}
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	record, reserved, err := m.storage.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{
+		Owner:       idempotencyOwner(ctx),
+		Key:         request.Key,
+		RequestHash: hashIdempotentRequest(request),
+		ExpiresAt:   now.Add(request.TTL),
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case reserved:
+		return nil, nil
+	case record.RequestHash != hashIdempotentRequest(request):
+		return nil, ErrIdempotencyKeyReused
+	case record.Response == nil:
+		return nil, ErrIdempotencyKeyInProgress
+	default:
+		return record.Response, nil
+	}
+}
+
+// CompleteIdempotentRequest сохраняет ответ, который получат повторы запроса с ключом key
+func (m *Manager) CompleteIdempotentRequest(ctx context.Context, key string, response models.StoredResponse) error {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.CompleteIdempotencyKey(ctx, idempotencyOwner(ctx), key, response)
+}
+
+// ReleaseIdempotentRequest освобождает ключ запроса, который завершился без ответа или с ошибкой сервера,
+// чтобы клиент мог его повторить
+func (m *Manager) ReleaseIdempotentRequest(ctx context.Context, key string) error {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.ReleaseIdempotencyKey(ctx, idempotencyOwner(ctx), key)
+}
+
+func (m *Manager) purgeIdempotencyKeys(ctx context.Context) (int, error) {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.PurgeIdempotencyKeys(ctx, time.Now().UTC())
+}
+
+// idempotencyOwner возвращает клиента, которому принадлежат ключи запроса; без аутентификации ключи общие
+func idempotencyOwner(ctx context.Context) string {
+	principal, _ := models.PrincipalFrom(ctx)
+	return principal.ID
+}
+
+// hashIdempotentRequest возвращает отпечаток запроса; If-Match входит в него, только если задан,
+// поэтому отпечатки запросов без If-Match совпадают с сохранёнными раньше
+func hashIdempotentRequest(request IdempotentRequest) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.Path + "\n"))
+	if request.IfMatch != "" {
+		hash.Write([]byte("If-Match: " + request.IfMatch + "\n"))
+	}
+	hash.Write(request.Body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return false
+	}
+	for i := range len(key) {
+		if key[i] < ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
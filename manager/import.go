@@ -0,0 +1,356 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ImportBatchSize — число подписок, которые сохраняются в хранилище за одну транзакцию
+	ImportBatchSize = 500
+	// MaxImportErrors — сколько ошибок строк попадает в отчёт; остальные только подсчитываются
+	MaxImportErrors = 100
+)
+
+// importColumns — столбцы CSV-файла импорта; названия совпадают с полями JSON подписки
+var importColumns = []string{"user_id", "service_name", "price", "currency", "start_date", "end_date", "billing_period", "billing_interval"}
+
+var requiredImportColumns = []string{"user_id", "service_name", "price", "start_date"}
+
+// ImportParams содержит необработанные параметры запроса импорта
+type ImportParams struct {
+	Format string
+	DryRun string
+}
+
+// ImportSubscriptions читает подписки из body построчно, проверяет каждую так же, как при создании,
+// и сохраняет корректные пакетами по ImportBatchSize. Строки с ошибками, в том числе конфликтующие с действующими
+// подписками или с предыдущими строками файла, пропускаются и попадают в отчёт. В режиме dry-run подписки только
+// проверяются. Если импорт прерван, вместе с ошибкой возвращается отчёт о строках, обработанных до неё
+func (m *Manager) ImportSubscriptions(ctx context.Context, params ImportParams, body io.Reader) (models.ImportReport, error) {
+	if !slices.Contains(models.ImportFormats, params.Format) {
+		return models.ImportReport{}, &BadRequestError{msg: ErrInvalidImportFormat.Error()}
+	}
+
+	var report models.ImportReport
+	if params.DryRun != "" {
+		var err error
+		if report.DryRun, err = strconv.ParseBool(params.DryRun); err != nil {
+			return models.ImportReport{}, &BadRequestError{msg: ErrInvalidDryRunFlag.Error()}
+		}
+	}
+
+	rows, err := newImportReader(params.Format, body)
+	if err != nil {
+		return models.ImportReport{}, err
+	}
+
+	// pending — прочитанные строки, которые ещё не сверены с правилом уникальности;
+	// accepted — прошедшие все проверки строки файла по пользователю и сервису
+	pending := make([]importRow, 0, ImportBatchSize)
+	accepted := make(map[models.ServiceKey][]importRow)
+
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Rows++
+		if row.err == nil {
+			row.err = scopeSubscription(ctx, &row.subscription)
+		}
+		if row.err == nil {
+			applyDefaults(&row.subscription)
+			row.err = validateSubscription(row.subscription)
+		}
+		if row.err == nil {
+			m.markUniqueness(&row.subscription)
+		}
+
+		if pending = append(pending, row); len(pending) == ImportBatchSize {
+			if err := m.importRows(ctx, &report, pending, accepted); err != nil {
+				return report, err
+			}
+			pending = pending[:0]
+		}
+	}
+
+	if err := m.importRows(ctx, &report, pending, accepted); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// importRows сверяет корректные строки с правилом уникальности и сохраняет прошедшие проверку одной транзакцией.
+// Подписка строки сравнивается с действующими подписками хранилища и с принятыми ранее строками файла из accepted,
+// поэтому в режиме dry-run конфликты находятся так же, как при сохранении
+func (m *Manager) importRows(ctx context.Context, report *models.ImportReport, rows []importRow, accepted map[models.ServiceKey][]importRow) error {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	var keys []models.ServiceKey
+	for _, row := range rows {
+		if row.err == nil {
+			keys = append(keys, row.subscription.Key())
+		}
+	}
+	var active []models.Subscription
+	if len(keys) > 0 {
+		var err error
+		if active, err = m.storage.FindActive(ctx, keys); err != nil {
+			return err
+		}
+	}
+
+	batch := make([]models.Subscription, 0, len(keys))
+	for _, row := range rows {
+		if row.err == nil {
+			row.err = m.importConflict(row.subscription, accepted, active)
+		}
+		if row.err != nil {
+			report.Failed++
+			if len(report.Errors) < MaxImportErrors {
+				report.Errors = append(report.Errors, newImportRowError(row))
+			}
+			continue
+		}
+
+		report.Valid++
+		key := row.subscription.Key()
+		accepted[key] = append(accepted[key], row)
+		batch = append(batch, row.subscription)
+	}
+	if report.DryRun || len(batch) == 0 {
+		return nil
+	}
+
+	imported, err := m.storage.Import(ctx, batch)
+	report.Imported += imported
+	return m.conflictError(ctx, err)
+}
+
+// importConflict возвращает *models.ConflictError, если подписка конфликтует с принятой ранее строкой файла
+// или с действующей подпиской из active
+func (m *Manager) importConflict(subscription models.Subscription, accepted map[models.ServiceKey][]importRow, active []models.Subscription) error {
+	for _, row := range accepted[subscription.Key()] {
+		if m.conflicts(subscription, row.subscription) {
+			return &models.ConflictError{Line: row.line, Policy: m.uniquenessPolicy()}
+		}
+	}
+	if existing, ok := m.findConflict(subscription, active); ok {
+		return &models.ConflictError{ID: existing.ID, Policy: m.uniquenessPolicy()}
+	}
+	return nil
+}
+
+func newImportRowError(row importRow) models.ImportRowError {
+	rowErr := models.ImportRowError{Line: row.line, Error: row.err.Error()}
+	var conflictErr *models.ConflictError
+	if errors.As(row.err, &conflictErr) {
+		rowErr.ConflictingID, rowErr.ConflictingLine = conflictErr.ID, conflictErr.Line
+	}
+	var validationErr *ValidationError
+	if errors.As(row.err, &validationErr) {
+		rowErr.Fields = make([]models.ImportFieldError, len(validationErr.Fields))
+		for i, field := range validationErr.Fields {
+			rowErr.Fields[i] = models.ImportFieldError{Field: field.Field, Message: field.Err.Error()}
+		}
+	}
+	return rowErr
+}
+
+// importRow — строка файла импорта; err — ошибка разбора строки, которая не прерывает импорт
+type importRow struct {
+	line         int
+	subscription models.Subscription
+	err          error
+}
+
+// importReader читает строки файла импорта; io.EOF означает конец файла, другие ошибки прерывают импорт
+type importReader interface {
+	next() (importRow, error)
+}
+
+func newImportReader(format string, body io.Reader) (importReader, error) {
+	if format == models.ImportFormatJSONL {
+		return &jsonlImportReader{reader: bufio.NewReader(body)}, nil
+	}
+	return newCSVImportReader(body)
+}
+
+type jsonlImportReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func (r *jsonlImportReader) next() (importRow, error) {
+	for {
+		data, err := r.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return importRow{}, err
+		}
+		if len(data) == 0 && err != nil {
+			return importRow{}, io.EOF
+		}
+
+		r.line++
+		if data = bytes.TrimSpace(data); len(data) == 0 {
+			continue
+		}
+
+		row := importRow{line: r.line}
+		row.subscription, row.err = decodeImportLine(data)
+		return row, nil
+	}
+}
+
+// importReadOnlyFields — поля подписки, которые задаёт сервис; строка файла импорта не может их содержать
+var importReadOnlyFields = []string{"id", "version", "created_at", "updated_at", "deleted_at"}
+
+// decodeImportLine разбирает строку JSONL так же строго, как тело запроса: неизвестные поля, поля только для чтения
+// и данные после объекта отклоняются, а ошибки разбора переводятся DecodeError
+func decodeImportLine(data []byte) (models.Subscription, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var subscription models.Subscription
+	if err := decoder.Decode(&subscription); err != nil {
+		return models.Subscription{}, DecodeError(err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return models.Subscription{}, &BadRequestError{msg: ErrImportLineTrailing.Error()}
+	}
+
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return models.Subscription{}, DecodeError(err)
+	}
+	var fields []FieldError
+	for _, field := range importReadOnlyFields {
+		if _, ok := present[field]; ok {
+			fields = append(fields, FieldError{Field: field, Err: fmt.Errorf("%s is read-only", field)})
+		}
+	}
+	if len(fields) > 0 {
+		return models.Subscription{}, &ValidationError{Fields: fields}
+	}
+
+	return subscription, nil
+}
+
+type csvImportReader struct {
+	reader *csv.Reader
+	// columns[i] — название i-го столбца файла
+	columns []string
+}
+
+// newCSVImportReader читает строку заголовка и проверяет, что в файле есть все обязательные столбцы
+func newCSVImportReader(body io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &BadRequestError{msg: ErrImportHeaderMissing.Error()}
+	}
+	if err != nil {
+		return nil, &BadRequestError{msg: err.Error()}
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !slices.Contains(importColumns, name) {
+			return nil, &BadRequestError{msg: fmt.Sprintf("%s: %q", ErrUnknownImportColumn, name)}
+		}
+		columns[i] = name
+	}
+	for _, required := range requiredImportColumns {
+		if !slices.Contains(columns, required) {
+			return nil, &BadRequestError{msg: fmt.Sprintf("%s: %q", ErrImportColumnMissing, required)}
+		}
+	}
+
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvImportReader) next() (importRow, error) {
+	record, err := r.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return importRow{}, io.EOF
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importRow{line: parseErr.StartLine, err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	row := importRow{line: line}
+	for i, value := range record {
+		if value = strings.TrimSpace(value); value != "" {
+			if err := setImportField(&row.subscription, r.columns[i], value); err != nil {
+				row.err = &ValidationError{Fields: []FieldError{{Field: r.columns[i], Err: err}}}
+				break
+			}
+		}
+	}
+
+	return row, nil
+}
+
+// setImportField заполняет поле подписки значением столбца CSV
+func setImportField(subscription *models.Subscription, column, value string) error {
+	var err error
+	switch column {
+	case "user_id":
+		if subscription.UserID, err = uuid.Parse(value); err != nil {
+			return ErrInvalidUserID
+		}
+	case "service_name":
+		subscription.ServiceName = value
+	case "price":
+		if subscription.Price, err = strconv.Atoi(value); err != nil {
+			return ErrInvalidImportPrice
+		}
+	case "currency":
+		subscription.Currency = value
+	case "start_date":
+		if subscription.StartDate, err = models.ParseMonth(value); err != nil {
+			return fmt.Errorf("start_date: %w", err)
+		}
+	case "end_date":
+		endDate, err := models.ParseMonth(value)
+		if err != nil {
+			return fmt.Errorf("end_date: %w", err)
+		}
+		subscription.EndDate = &endDate
+	case "billing_period":
+		subscription.BillingPeriod = value
+	case "billing_interval":
+		if subscription.BillingInterval, err = strconv.Atoi(value); err != nil {
+			return ErrInvalidImportInterval
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package manager_test
+
+import (
+	"slices"
+	"strings"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+	"testing"
+	"time"
+)
+
+func TestImportJSONLStrictDecoding(t *testing.T) {
+	const valid = `{"service_name":"Yandex Plus","price":400,"user_id":"6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b","start_date":"07-2025"`
+
+	tests := []struct {
+		name       string
+		line       string
+		wantFields []string
+	}{
+		{"unknown field", valid + `,"colour":"red"}`, []string{"colour"}},
+		{"read-only id", valid + `,"id":7}`, []string{"id"}},
+		{"read-only fields", valid + `,"version":3,"created_at":"2025-07-01T00:00:00Z"}`, []string{"version", "created_at"}},
+		{"wrong type", `{"service_name":"Yandex Plus","price":"400","user_id":"6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b","start_date":"07-2025"}`, []string{"price"}},
+		{"invalid field value", `{"service_name":"Yandex Plus","price":0,"user_id":"6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b","start_date":"07-2025"}`, []string{"price"}},
+		{"trailing data", valid + `} {}`, nil},
+		{"malformed", `{"service_name":`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := manager.New(storage.NewMemory(), time.Second)
+			body := valid + "}\n" + tt.line + "\n"
+
+			report, err := m.ImportSubscriptions(t.Context(), manager.ImportParams{Format: models.ImportFormatJSONL}, strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("ImportSubscriptions() error = %v", err)
+			}
+			if report.Rows != 2 || report.Imported != 1 || report.Failed != 1 || len(report.Errors) != 1 {
+				t.Fatalf("ImportSubscriptions() report = %+v, want 1 imported and 1 failed row", report)
+			}
+
+			rowErr := report.Errors[0]
+			if rowErr.Line != 2 {
+				t.Fatalf("row error line = %d, want 2", rowErr.Line)
+			}
+			var fields []string
+			for _, field := range rowErr.Fields {
+				fields = append(fields, field.Field)
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Fatalf("row error fields = %v (%s), want %v", fields, rowErr.Error, tt.wantFields)
+			}
+		})
+	}
+}
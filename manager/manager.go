@@ -1,8 +1,13 @@
 package manager
 
 import (
+	"context"
 	"errors"
+	"iter"
+	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
 	"subscription-aggregator-api/models"
 	"time"
 
@@ -10,11 +15,66 @@ import (
 )
 
 var (
-	ErrUserIDEmpty         = errors.New("user ID cannot be empty")
-	ErrServiceNameEmpty    = errors.New("service name cannot be empty")
-	ErrInvalidStartDate    = errors.New("start date must be in format YYYY-MM-DD")
-	ErrPriceMustBePositive = errors.New("price must be greater than 0")
-	ErrIDEmpty             = errors.New("ID must be greater than 0")
+	ErrUserIDEmpty              = errors.New("user ID cannot be empty")
+	ErrServiceNameEmpty         = errors.New("service name cannot be empty")
+	ErrStartDateEmpty           = errors.New("start date cannot be empty")
+	ErrEndDateBeforeStart       = errors.New("end date must not precede start date")
+	ErrInvalidActiveMonth       = errors.New("active month must be in format MM-YYYY")
+	ErrInvalidPriceRange        = errors.New("price range bounds must be positive integers with min not greater than max")
+	ErrInvalidSort              = errors.New("sort must be one of: " + strings.Join(models.ListSortFields, ", "))
+	ErrInvalidOrder             = errors.New("order must be one of: " + strings.Join(models.ListOrders, ", "))
+	ErrInvalidLimit             = errors.New("limit must be in the range 1-" + strconv.Itoa(MaxListLimit))
+	ErrCursorMismatch           = errors.New("cursor does not match requested sort and order")
+	ErrPriceMustBePositive      = errors.New("price must be greater than 0")
+	ErrIDEmpty                  = errors.New("ID must be greater than 0")
+	ErrInvalidPeriodFrom        = errors.New("period start must be in format MM-YYYY")
+	ErrInvalidPeriodTo          = errors.New("period end must be in format MM-YYYY")
+	ErrInvalidPeriod            = errors.New("period end must not precede period start")
+	ErrInvalidUserID            = errors.New("user ID must be a valid UUID")
+	ErrInvalidDeletedFlag       = errors.New("include_deleted must be a boolean")
+	ErrInvalidAction            = errors.New("action must be one of: " + strings.Join(models.EventActions, ", "))
+	ErrInvalidSince             = errors.New("since must be an RFC 3339 timestamp")
+	ErrInvalidUntil             = errors.New("until must be an RFC 3339 timestamp")
+	ErrInvalidEventCursor       = errors.New("cursor is invalid")
+	ErrInvalidEffectiveFrom     = errors.New("price effective month must be in format MM-YYYY")
+	ErrEffectiveFromOutside     = errors.New("price effective month must be within the subscription period")
+	ErrInvalidResetFlag         = errors.New("reset_price_history must be a boolean")
+	ErrResetWithEffectiveFrom   = errors.New("reset_price_history cannot be combined with price_effective_from")
+	ErrInvalidBillingPeriod     = errors.New("billing period must be one of: " + strings.Join(models.BillingPeriods, ", "))
+	ErrInvalidBillingInterval   = errors.New("billing interval must be greater than 0")
+	ErrInvalidSumBasis          = errors.New("basis must be one of: " + strings.Join(models.SumBases, ", "))
+	ErrRateMustBePositive       = errors.New("exchange rate must be greater than 0")
+	ErrInvalidRateMonth         = errors.New("exchange rate effective month must be in format MM-YYYY")
+	ErrBaseCurrencyRate         = errors.New("exchange rate of the base currency " + models.BaseCurrency + " is always 1")
+	ErrInvalidBatchMode         = errors.New("mode must be one of: " + strings.Join(models.BatchModes, ", "))
+	ErrInvalidBatchOp           = errors.New("op must be one of: " + strings.Join(models.BatchOps, ", "))
+	ErrBatchEmpty               = errors.New("batch must contain at least one operation")
+	ErrBatchTooLarge            = errors.New("batch must contain at most " + strconv.Itoa(MaxBatchSize) + " operations")
+	ErrBatchSubscriptionMissing = errors.New("subscription is required for create and update operations")
+	ErrInvalidBatchVersion      = errors.New("version must not be negative")
+	ErrBatchVersionRequired     = errors.New("version is required for update operations")
+	ErrInvalidImportFormat      = errors.New("import format must be one of: " + strings.Join(models.ImportFormats, ", "))
+	ErrInvalidDryRunFlag        = errors.New("dry_run must be a boolean")
+	ErrImportHeaderMissing      = errors.New("CSV header row is missing")
+	ErrUnknownImportColumn      = errors.New("unknown CSV column")
+	ErrImportColumnMissing      = errors.New("required CSV column is missing")
+	ErrImportLineTrailing       = errors.New("JSONL line must contain a single JSON object")
+	ErrInvalidImportPrice       = errors.New("price must be an integer")
+	ErrInvalidImportInterval    = errors.New("billing interval must be an integer")
+	ErrInvalidExportFormat      = errors.New("export format must be one of: " + strings.Join(models.ExportFormats, ", "))
+	ErrAPIKeyNameEmpty          = errors.New("API key name cannot be empty")
+	ErrAPIKeyScopesEmpty        = errors.New("API key must have at least one scope")
+	ErrInvalidScope             = errors.New("scope must be one of: " + strings.Join(models.Scopes, ", "))
+	ErrInvalidAPIKeyID          = errors.New("API key ID must be a positive integer")
+	// ErrUnauthenticated — ключ доступа не передан, неизвестен или отозван
+	ErrUnauthenticated = errors.New("API key is missing, invalid or revoked")
+	// ErrExchangeRateMissing — для пересчёта нет курса, действующего в нужном месяце
+	ErrExchangeRateMissing = errors.New("exchange rate is missing")
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
 )
 
 type BadRequestError struct {
@@ -26,88 +86,733 @@ func (e *BadRequestError) Error() string {
 }
 
 type SubscriptionStorage interface {
-	Create(subscription models.Subscription) error
-	GetByID(id int) (models.Subscription, error)
-	GetList() ([]models.Subscription, error)
-	Update(id int, updated models.Subscription) error
-	Delete(id int) error
-	GetTotalSum() (totalSum int, err error)
+	Create(ctx context.Context, subscription models.Subscription) (models.Subscription, error)
+	GetByID(ctx context.Context, id int) (models.Subscription, error)
+	FindByID(ctx context.Context, id int) (models.Subscription, error)
+	GetOwner(ctx context.Context, id int) (uuid.UUID, error)
+	GetList(ctx context.Context, filter models.ListFilter) (models.SubscriptionPage, error)
+	Update(ctx context.Context, id int, updated models.Subscription, expectedVersion int) (models.Subscription, error)
+	Patch(ctx context.Context, id int, expectedVersion int, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error)
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int, noOverlap bool) (models.Subscription, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+	GetSumItems(ctx context.Context, filter models.SumFilter) ([]models.SumItem, error)
+	ChangePrice(ctx context.Context, id int, expectedVersion int, effectiveFrom models.Month, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error)
+	GetPrices(ctx context.Context, id int) ([]models.PriceChange, error)
+	GetHistory(ctx context.Context, subscriptionID int) ([]models.SubscriptionEvent, error)
+	GetEvents(ctx context.Context, filter models.EventFilter) (models.EventPage, error)
+	SaveExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error)
+	GetExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error)
+	Batch(ctx context.Context, ops []models.BatchOperation, atomic bool) ([]models.BatchResult, error)
+	Import(ctx context.Context, subscriptions []models.Subscription) (int, error)
+	Iterate(ctx context.Context, filter models.ListFilter) iter.Seq2[models.Subscription, error]
+	FindActive(ctx context.Context, keys []models.ServiceKey) ([]models.Subscription, error)
+	CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error)
+	FindAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+	ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, now time.Time) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, owner, key string, response models.StoredResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, owner, key string) error
+	PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error)
+}
+
+// ListParams содержит необработанные параметры запроса списка подписок
+type ListParams struct {
+	UserID            string
+	ServiceName       string
+	ServiceNamePrefix string
+	PriceMin          string
+	PriceMax          string
+	ActiveIn          string
+	IncludeDeleted    string
+	Sort              string
+	Order             string
+	Limit             string
+	Cursor            string
+}
+
+// UpdateParams содержит необработанные параметры запроса перезаписи подписки
+type UpdateParams struct {
+	PriceEffectiveFrom string
+	ResetPriceHistory  string
+}
+
+// SumParams содержит необработанные параметры запроса суммы подписок
+type SumParams struct {
+	From        string
+	To          string
+	UserID      string
+	ServiceName string
+	Basis       string
+	Currency    string
+}
+
+// AuditParams содержит необработанные параметры запроса журнала изменений
+type AuditParams struct {
+	SubscriptionID string
+	Actor          string
+	Action         string
+	Since          string
+	Until          string
+	Limit          string
+	Cursor         string
 }
 
 type Manager struct {
-	storage SubscriptionStorage
+	storage      SubscriptionStorage
+	queryTimeout time.Duration
+	// uniqueness — правило уникальности подписок, см. SetUniquenessPolicy
+	uniqueness string
+	// requireVersion — операции update пакета без version отклоняются, см. SetRequireVersion
+	requireVersion bool
 }
 
-func New(storage SubscriptionStorage) *Manager {
-	return &Manager{storage: storage}
+func New(storage SubscriptionStorage, queryTimeout time.Duration) *Manager {
+	return &Manager{storage: storage, queryTimeout: queryTimeout}
 }
 
-func (m *Manager) CreateSubscription(subscription models.Subscription) error {
+// withQueryTimeout ограничивает время обращения к хранилищу в рамках одного запроса
+func (m *Manager) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.queryTimeout)
+}
+
+func (m *Manager) CreateSubscription(ctx context.Context, subscription models.Subscription) (models.Subscription, error) {
+	if err := scopeSubscription(ctx, &subscription); err != nil {
+		return models.Subscription{}, err
+	}
+	applyDefaults(&subscription)
 	if err := validateSubscription(subscription); err != nil {
-		return &BadRequestError{msg: err.Error()}
+		return models.Subscription{}, err
+	}
+	m.markUniqueness(&subscription)
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.checkUniqueness(ctx, subscription); err != nil {
+		return models.Subscription{}, err
 	}
-	return m.storage.Create(subscription)
+	created, err := m.storage.Create(ctx, subscription)
+	return created, m.conflictError(ctx, err)
 }
 
-func (m *Manager) GetSubscription(id string) (models.Subscription, error) {
+func (m *Manager) GetSubscription(ctx context.Context, id string) (models.Subscription, error) {
 	parsedID, err := validateID(id)
 	if err != nil {
 		return models.Subscription{}, err
 	}
-	return m.storage.GetByID(parsedID)
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return models.Subscription{}, err
+	}
+	return m.storage.GetByID(ctx, parsedID)
 }
 
-func (m *Manager) GetAllSubscriptions() ([]models.Subscription, error) {
-	return m.storage.GetList()
+func (m *Manager) GetAllSubscriptions(ctx context.Context, params ListParams) (models.SubscriptionPage, error) {
+	filter, err := parseListParams(params)
+	if err != nil {
+		return models.SubscriptionPage{}, &BadRequestError{msg: err.Error()}
+	}
+	if err := scopeFilter(ctx, &filter.UserID); err != nil {
+		return models.SubscriptionPage{}, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.GetList(ctx, filter)
 }
 
-func (m *Manager) UpdateSubscription(id string, updatedSubscription models.Subscription) error {
+// UpdateSubscription перезаписывает подписку; expectedVersion > 0 включает проверку версии (If-Match).
+// Новая цена действует с месяца params.PriceEffectiveFrom (MM-YYYY), а без него — с текущего месяца;
+// прежние цены сохраняются. Только при params.ResetPriceHistory новая цена заменяет всю историю цен
+func (m *Manager) UpdateSubscription(ctx context.Context, id string, updatedSubscription models.Subscription, expectedVersion int, params UpdateParams) (models.Subscription, error) {
 	parsedID, err := validateID(id)
 	if err != nil {
-		return err
+		return models.Subscription{}, err
+	}
+	if err := scopeSubscription(ctx, &updatedSubscription); err != nil {
+		return models.Subscription{}, err
 	}
+	applyDefaults(&updatedSubscription)
 	if err := validateSubscription(updatedSubscription); err != nil {
-		return err
+		return models.Subscription{}, err
 	}
-	return m.storage.Update(parsedID, updatedSubscription)
+	updatedSubscription.ID = parsedID
+	m.markUniqueness(&updatedSubscription)
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return models.Subscription{}, err
+	}
+
+	var resetPriceHistory bool
+	if params.ResetPriceHistory != "" {
+		if resetPriceHistory, err = strconv.ParseBool(params.ResetPriceHistory); err != nil {
+			return models.Subscription{}, &BadRequestError{msg: ErrInvalidResetFlag.Error()}
+		}
+	}
+	var effectiveFrom models.Month
+	if params.PriceEffectiveFrom != "" {
+		if resetPriceHistory {
+			return models.Subscription{}, &BadRequestError{msg: ErrResetWithEffectiveFrom.Error()}
+		}
+		if effectiveFrom, err = models.ParseMonth(params.PriceEffectiveFrom); err != nil {
+			return models.Subscription{}, &BadRequestError{msg: ErrInvalidEffectiveFrom.Error()}
+		}
+		if err := validateEffectiveFrom(updatedSubscription, effectiveFrom); err != nil {
+			return models.Subscription{}, &BadRequestError{msg: err.Error()}
+		}
+	}
+	if err := m.checkUniqueness(ctx, updatedSubscription); err != nil {
+		return models.Subscription{}, err
+	}
+
+	var updated models.Subscription
+	if params.PriceEffectiveFrom == "" && !resetPriceHistory {
+		updated, err = m.storage.Update(ctx, parsedID, updatedSubscription, expectedVersion)
+	} else {
+		// нулевой effectiveFrom при resetPriceHistory заменяет историю цен
+		updated, err = m.storage.ChangePrice(ctx, parsedID, expectedVersion, effectiveFrom, func(models.Subscription) (models.Subscription, error) {
+			return updatedSubscription, nil
+		})
+	}
+	return updated, m.conflictError(ctx, err)
+}
+
+// ChangeSubscriptionPrice задаёт подписке новую цену с месяца change.EffectiveFrom, не меняя цены предыдущих месяцев
+func (m *Manager) ChangeSubscriptionPrice(ctx context.Context, id string, change models.PriceChange, expectedVersion int) (models.Subscription, error) {
+	parsedID, err := validateID(id)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if change.Price <= 0 {
+		return models.Subscription{}, &BadRequestError{msg: ErrPriceMustBePositive.Error()}
+	}
+	if change.EffectiveFrom.IsZero() {
+		return models.Subscription{}, &BadRequestError{msg: ErrInvalidEffectiveFrom.Error()}
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return models.Subscription{}, err
+	}
+	return m.storage.ChangePrice(ctx, parsedID, expectedVersion, change.EffectiveFrom, func(current models.Subscription) (models.Subscription, error) {
+		if err := validateEffectiveFrom(current, change.EffectiveFrom); err != nil {
+			return models.Subscription{}, &BadRequestError{msg: err.Error()}
+		}
+		current.Price = change.Price
+		return current, nil
+	})
 }
 
-func (m *Manager) DeleteSubscription(id string) error {
+func (m *Manager) GetSubscriptionPrices(ctx context.Context, id string) ([]models.PriceChange, error) {
+	parsedID, err := validateID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return nil, err
+	}
+	return m.storage.GetPrices(ctx, parsedID)
+}
+
+func (m *Manager) PatchSubscription(ctx context.Context, id string, patch []byte, expectedVersion int) (models.Subscription, error) {
+	parsedID, err := validateID(id)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return models.Subscription{}, err
+	}
+
+	// Правило уникальности проверяется по текущей версии подписки до блокировки строки;
+	// если подписку успеют изменить, конфликт отклонит ограничение базы
+	current, err := m.storage.GetByID(ctx, parsedID)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	patched, err := m.patchSubscription(ctx, current, patch)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if err := m.checkUniqueness(ctx, patched); err != nil {
+		return models.Subscription{}, err
+	}
+
+	updated, err := m.storage.Patch(ctx, parsedID, expectedVersion, func(current models.Subscription) (models.Subscription, error) {
+		return m.patchSubscription(ctx, current, patch)
+	})
+	return updated, m.conflictError(ctx, err)
+}
+
+// patchSubscription применяет JSON Merge Patch к подписке и проверяет результат так же, как при создании
+func (m *Manager) patchSubscription(ctx context.Context, current models.Subscription, patch []byte) (models.Subscription, error) {
+	patched, err := applyMergePatch(current, patch)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if err := scopeSubscription(ctx, &patched); err != nil {
+		return models.Subscription{}, err
+	}
+	applyDefaults(&patched)
+	if err := validateSubscription(patched); err != nil {
+		return models.Subscription{}, err
+	}
+	patched.ID = current.ID
+	m.markUniqueness(&patched)
+	return patched, nil
+}
+
+func (m *Manager) DeleteSubscription(ctx context.Context, id string) error {
 	parsedID, err := validateID(id)
 	if err != nil {
 		return err
 	}
-	return m.storage.Delete(parsedID)
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return err
+	}
+	return m.storage.Delete(ctx, parsedID)
+}
+
+func (m *Manager) RestoreSubscription(ctx context.Context, id string) (models.Subscription, error) {
+	parsedID, err := validateID(id)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return models.Subscription{}, err
+	}
+
+	// восстановленная подписка подчиняется текущему правилу уникальности, даже если удалена при другом
+	candidate, err := m.storage.FindByID(ctx, parsedID)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	m.markUniqueness(&candidate)
+	if candidate.DeletedAt != nil {
+		if err := m.checkUniqueness(ctx, candidate); err != nil {
+			return models.Subscription{}, err
+		}
+	}
+
+	restored, err := m.storage.Restore(ctx, parsedID, candidate.NoOverlap)
+	return restored, m.conflictError(ctx, err)
+}
+
+// PurgeDeleted окончательно удаляет подписки, помеченные удалёнными более retention назад
+func (m *Manager) PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.Purge(ctx, time.Now().Add(-retention))
+}
+
+// RunPurgeJob запускает PurgeDeleted каждые interval до отмены ctx; в журнал очистка попадает от имени SystemActor.
+// Заодно удаляются истёкшие ключи идемпотентности
+func (m *Manager) RunPurgeJob(ctx context.Context, retention, interval time.Duration) {
+	ctx = models.WithAuditInfo(ctx, models.AuditInfo{Actor: models.SystemActor})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := m.PurgeDeleted(ctx, retention)
+			if err != nil {
+				slog.Error("Failed to purge deleted subscriptions", "error", err)
+			} else if purged > 0 {
+				slog.Info("Deleted subscriptions purged", "count", purged)
+			}
+
+			expired, err := m.purgeIdempotencyKeys(ctx)
+			if err != nil {
+				slog.Error("Failed to purge expired idempotency keys", "error", err)
+			} else if expired > 0 {
+				slog.Info("Expired idempotency keys purged", "count", expired)
+			}
+		}
+	}
 }
 
-func (m *Manager) GetAllSubscriptionsSum() (int, error) {
-	return m.storage.GetTotalSum()
+// GetSubscriptionsSum считает стоимость подписок за период. Базис accrual (по умолчанию) распределяет цену
+// цикла оплаты по месяцам, cash_flow относит её целиком к месяцам списания. Стоимость пересчитывается
+// в валюту currency (по умолчанию базовую) по курсу, действующему в каждом месяце
+func (m *Manager) GetSubscriptionsSum(ctx context.Context, params SumParams) (models.SumReport, error) {
+	filter, err := parseSumParams(params)
+	if err != nil {
+		return models.SumReport{}, &BadRequestError{msg: err.Error()}
+	}
+	if err := scopeFilter(ctx, &filter.UserID); err != nil {
+		return models.SumReport{}, err
+	}
+
+	basis := models.SumBasisAccrual
+	if params.Basis != "" {
+		if !slices.Contains(models.SumBases, params.Basis) {
+			return models.SumReport{}, &BadRequestError{msg: ErrInvalidSumBasis.Error()}
+		}
+		basis = params.Basis
+	}
+
+	target := models.BaseCurrency
+	if params.Currency != "" {
+		if target, err = models.ParseCurrency(params.Currency); err != nil {
+			return models.SumReport{}, &BadRequestError{msg: err.Error()}
+		}
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	items, err := m.storage.GetSumItems(ctx, filter)
+	if err != nil {
+		return models.SumReport{}, err
+	}
+
+	rates, err := m.storage.GetExchangeRates(ctx, "")
+	if err != nil {
+		return models.SumReport{}, err
+	}
+
+	report, err := applyCosts(items, basis, newConverter(target, rates))
+	if err != nil {
+		return models.SumReport{}, err
+	}
+	report.From, report.To = filter.From, filter.To
+
+	return report, nil
 }
 
+// CreateExchangeRate сохраняет курс валюты к базовой, действующий с указанного месяца
+func (m *Manager) CreateExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error) {
+	if err := requireUnscoped(ctx); err != nil {
+		return models.ExchangeRate{}, err
+	}
+	currency, err := models.ParseCurrency(rate.Currency)
+	if err != nil {
+		return models.ExchangeRate{}, &BadRequestError{msg: err.Error()}
+	}
+	if currency == models.BaseCurrency {
+		return models.ExchangeRate{}, &BadRequestError{msg: ErrBaseCurrencyRate.Error()}
+	}
+	if rate.Rate <= 0 {
+		return models.ExchangeRate{}, &BadRequestError{msg: ErrRateMustBePositive.Error()}
+	}
+	if rate.EffectiveFrom.IsZero() {
+		return models.ExchangeRate{}, &BadRequestError{msg: ErrInvalidRateMonth.Error()}
+	}
+	rate.Currency = currency
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.SaveExchangeRate(ctx, rate)
+}
+
+// GetExchangeRates возвращает курсы валюты currency или всех валют, если она не указана
+func (m *Manager) GetExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error) {
+	if currency != "" {
+		parsed, err := models.ParseCurrency(currency)
+		if err != nil {
+			return nil, &BadRequestError{msg: err.Error()}
+		}
+		currency = parsed
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.storage.GetExchangeRates(ctx, currency)
+}
+
+func (m *Manager) GetSubscriptionHistory(ctx context.Context, id string) ([]models.SubscriptionEvent, error) {
+	parsedID, err := validateID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := m.authorizeSubscription(ctx, parsedID); err != nil {
+		return nil, err
+	}
+	return m.storage.GetHistory(ctx, parsedID)
+}
+
+func (m *Manager) GetAuditEvents(ctx context.Context, params AuditParams) (models.EventPage, error) {
+	filter, err := parseAuditParams(params)
+	if err != nil {
+		return models.EventPage{}, err
+	}
+
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	// журнал без subscription_id содержит изменения всех пользователей
+	if filter.SubscriptionID == 0 {
+		if err := requireUnscoped(ctx); err != nil {
+			return models.EventPage{}, err
+		}
+	} else if err := m.authorizeSubscription(ctx, filter.SubscriptionID); err != nil {
+		return models.EventPage{}, err
+	}
+	return m.storage.GetEvents(ctx, filter)
+}
+
+// validateSubscription проверяет все поля подписки и возвращает *ValidationError со всеми найденными ошибками
 func validateSubscription(subscription models.Subscription) error {
+	var fields []FieldError
+	invalid := func(field string, err error) {
+		fields = append(fields, FieldError{Field: field, Err: err})
+	}
+
 	if subscription.UserID == uuid.Nil {
-		return ErrUserIDEmpty
+		invalid("user_id", ErrUserIDEmpty)
 	}
 	if subscription.ServiceName == "" {
-		return ErrServiceNameEmpty
+		invalid("service_name", ErrServiceNameEmpty)
 	}
-	if err := validateDate(subscription.StartDate); err != nil {
-		return err
+	if err := subscription.StartDate.Err(); err != nil {
+		invalid("start_date", err)
+	} else if subscription.StartDate.IsZero() {
+		invalid("start_date", ErrStartDateEmpty)
+	}
+	if subscription.EndDate != nil {
+		if err := subscription.EndDate.Err(); err != nil {
+			invalid("end_date", err)
+		} else if subscription.EndDate.Before(subscription.StartDate) {
+			invalid("end_date", ErrEndDateBeforeStart)
+		}
 	}
 	if subscription.Price <= 0 {
-		return ErrPriceMustBePositive
+		invalid("price", ErrPriceMustBePositive)
+	}
+	if _, err := models.ParseCurrency(subscription.Currency); err != nil {
+		invalid("currency", err)
+	}
+	if !slices.Contains(models.BillingPeriods, subscription.BillingPeriod) {
+		invalid("billing_period", ErrInvalidBillingPeriod)
+	}
+	if subscription.BillingInterval <= 0 {
+		invalid("billing_interval", ErrInvalidBillingInterval)
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
 	}
 	return nil
 }
 
-func validateDate(date string) error {
-	_, err := time.Parse("2006-01-02", date)
-	if err != nil {
-		return ErrInvalidStartDate
+func validateEffectiveFrom(subscription models.Subscription, effectiveFrom models.Month) error {
+	if effectiveFrom.Before(subscription.StartDate) {
+		return ErrEffectiveFromOutside
+	}
+	if subscription.EndDate != nil && effectiveFrom.After(*subscription.EndDate) {
+		return ErrEffectiveFromOutside
 	}
 	return nil
 }
 
+func parseListParams(params ListParams) (models.ListFilter, error) {
+	filter := models.ListFilter{
+		ServiceName:       params.ServiceName,
+		ServiceNamePrefix: params.ServiceNamePrefix,
+		Sort:              models.SortByID,
+		Order:             models.OrderAsc,
+		Limit:             DefaultListLimit,
+	}
+
+	if params.UserID != "" {
+		userID, err := uuid.Parse(params.UserID)
+		if err != nil {
+			return models.ListFilter{}, ErrInvalidUserID
+		}
+		filter.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+
+	var err error
+	if filter.PriceMin, err = parseOptionalPositive(params.PriceMin); err != nil {
+		return models.ListFilter{}, ErrInvalidPriceRange
+	}
+	if filter.PriceMax, err = parseOptionalPositive(params.PriceMax); err != nil {
+		return models.ListFilter{}, ErrInvalidPriceRange
+	}
+	if filter.PriceMin > 0 && filter.PriceMax > 0 && filter.PriceMin > filter.PriceMax {
+		return models.ListFilter{}, ErrInvalidPriceRange
+	}
+
+	if params.ActiveIn != "" {
+		if filter.ActiveIn, err = models.ParseMonth(params.ActiveIn); err != nil {
+			return models.ListFilter{}, ErrInvalidActiveMonth
+		}
+	}
+
+	if params.IncludeDeleted != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(params.IncludeDeleted); err != nil {
+			return models.ListFilter{}, ErrInvalidDeletedFlag
+		}
+	}
+
+	if params.Sort != "" {
+		if !slices.Contains(models.ListSortFields, params.Sort) {
+			return models.ListFilter{}, ErrInvalidSort
+		}
+		filter.Sort = params.Sort
+	}
+	if params.Order != "" {
+		if !slices.Contains(models.ListOrders, params.Order) {
+			return models.ListFilter{}, ErrInvalidOrder
+		}
+		filter.Order = params.Order
+	}
+
+	if params.Limit != "" {
+		limit, err := strconv.Atoi(params.Limit)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return models.ListFilter{}, ErrInvalidLimit
+		}
+		filter.Limit = limit
+	}
+
+	if params.Cursor != "" {
+		cursor, err := models.DecodeListCursor(params.Cursor)
+		if err != nil {
+			return models.ListFilter{}, err
+		}
+		if cursor.Sort != filter.Sort || cursor.Order != filter.Order {
+			return models.ListFilter{}, ErrCursorMismatch
+		}
+		filter.Cursor = &cursor
+	}
+
+	return filter, nil
+}
+
+func parseOptionalPositive(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return 0, ErrInvalidPriceRange
+	}
+	return parsed, nil
+}
+
+func parseSumParams(params SumParams) (models.SumFilter, error) {
+	from, err := models.ParseMonth(params.From)
+	if err != nil {
+		return models.SumFilter{}, ErrInvalidPeriodFrom
+	}
+	to, err := models.ParseMonth(params.To)
+	if err != nil {
+		return models.SumFilter{}, ErrInvalidPeriodTo
+	}
+	if to.Before(from) {
+		return models.SumFilter{}, ErrInvalidPeriod
+	}
+
+	filter := models.SumFilter{
+		From:        from,
+		To:          to,
+		ServiceName: params.ServiceName,
+	}
+
+	if params.UserID != "" {
+		userID, err := uuid.Parse(params.UserID)
+		if err != nil {
+			return models.SumFilter{}, ErrInvalidUserID
+		}
+		filter.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+
+	return filter, nil
+}
+
+func parseAuditParams(params AuditParams) (models.EventFilter, error) {
+	filter := models.EventFilter{
+		Actor: params.Actor,
+		Limit: DefaultListLimit,
+	}
+
+	if params.SubscriptionID != "" {
+		subscriptionID, err := validateID(params.SubscriptionID)
+		if err != nil {
+			return models.EventFilter{}, err
+		}
+		filter.SubscriptionID = subscriptionID
+	}
+
+	if params.Action != "" {
+		if !slices.Contains(models.EventActions, params.Action) {
+			return models.EventFilter{}, &BadRequestError{msg: ErrInvalidAction.Error()}
+		}
+		filter.Action = params.Action
+	}
+
+	var err error
+	if params.Since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, params.Since); err != nil {
+			return models.EventFilter{}, &BadRequestError{msg: ErrInvalidSince.Error()}
+		}
+	}
+	if params.Until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, params.Until); err != nil {
+			return models.EventFilter{}, &BadRequestError{msg: ErrInvalidUntil.Error()}
+		}
+	}
+
+	if params.Limit != "" {
+		limit, err := strconv.Atoi(params.Limit)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return models.EventFilter{}, &BadRequestError{msg: ErrInvalidLimit.Error()}
+		}
+		filter.Limit = limit
+	}
+
+	if params.Cursor != "" {
+		beforeID, err := strconv.ParseInt(params.Cursor, 10, 64)
+		if err != nil || beforeID <= 0 {
+			return models.EventFilter{}, &BadRequestError{msg: ErrInvalidEventCursor.Error()}
+		}
+		filter.BeforeID = beforeID
+	}
+
+	return filter, nil
+}
+
 func validateID(id string) (int, error) {
 	parsedID, err := strconv.Atoi(id)
 	if err != nil {
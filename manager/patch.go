@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"subscription-aggregator-api/models"
+)
+
+var ErrPatchNotObject = errors.New("merge patch must be a JSON object")
+
+// applyMergePatch применяет к подписке JSON Merge Patch (RFC 7396)
+func applyMergePatch(subscription models.Subscription, patch []byte) (models.Subscription, error) {
+	var patchDoc map[string]any
+	if err := json.Unmarshal(patch, &patchDoc); err != nil || patchDoc == nil {
+		return models.Subscription{}, &BadRequestError{msg: ErrPatchNotObject.Error()}
+	}
+
+	original, err := json.Marshal(subscription)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	var target map[string]any
+	if err := json.Unmarshal(original, &target); err != nil {
+		return models.Subscription{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patchDoc))
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+
+	var patched models.Subscription
+	if err := decoder.Decode(&patched); err != nil {
+		return models.Subscription{}, DecodeError(err)
+	}
+	patched.ID = subscription.ID
+
+	return patched, nil
+}
+
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
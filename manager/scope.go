@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"subscription-aggregator-api/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrSubscriptionNotOwned — подписка принадлежит другому пользователю; для клиента неотличима от несуществующей
+	ErrSubscriptionNotOwned = errors.New("subscription belongs to another user")
+	// ErrForeignUser — запрос явно обращается к данным другого пользователя
+	ErrForeignUser = errors.New("user_id must match the authenticated user")
+	// ErrAdminRequired — операция затрагивает данные всех пользователей
+	ErrAdminRequired = errors.New("operation requires the admin role")
+)
+
+// userScope возвращает пользователя, которым ограничен запрос; ok = false для клиентов без ограничения
+// (ключей доступа, токенов с ролью администратора и фоновых задач)
+func userScope(ctx context.Context) (uuid.UUID, bool) {
+	principal, ok := models.PrincipalFrom(ctx)
+	if !ok || !principal.UserID.Valid {
+		return uuid.Nil, false
+	}
+	return principal.UserID.UUID, true
+}
+
+// scopeSubscription подставляет пользователя запроса в подписку без user_id и запрещает подписки других пользователей
+func scopeSubscription(ctx context.Context, subscription *models.Subscription) error {
+	userID, ok := userScope(ctx)
+	if !ok {
+		return nil
+	}
+	if subscription.UserID == uuid.Nil {
+		subscription.UserID = userID
+	}
+	if subscription.UserID != userID {
+		return ErrForeignUser
+	}
+	return nil
+}
+
+// scopeFilter ограничивает фильтр по пользователю пользователем запроса
+func scopeFilter(ctx context.Context, filterUserID *uuid.NullUUID) error {
+	userID, ok := userScope(ctx)
+	if !ok {
+		return nil
+	}
+	if filterUserID.Valid && filterUserID.UUID != userID {
+		return ErrForeignUser
+	}
+	*filterUserID = uuid.NullUUID{UUID: userID, Valid: true}
+	return nil
+}
+
+// authorizeSubscription проверяет, что подписка id, в том числе удалённая, принадлежит пользователю запроса
+func (m *Manager) authorizeSubscription(ctx context.Context, id int) error {
+	userID, ok := userScope(ctx)
+	if !ok {
+		return nil
+	}
+
+	owner, err := m.storage.GetOwner(ctx, id)
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return ErrSubscriptionNotOwned
+	}
+	return nil
+}
+
+// requireUnscoped разрешает операцию только клиентам без ограничения пользователем
+func requireUnscoped(ctx context.Context) error {
+	if _, ok := userScope(ctx); ok {
+		return ErrAdminRequired
+	}
+	return nil
+}
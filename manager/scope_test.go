@@ -0,0 +1,165 @@
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ownerID    = uuid.MustParse("6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b")
+	strangerID = uuid.MustParse("0b9a8c7d-6e5f-4a3b-8c2d-1e0f9a8b7c6d")
+)
+
+func userContext(ctx context.Context, userID uuid.UUID) context.Context {
+	return models.WithPrincipal(ctx, models.Principal{
+		ID:     "token:" + userID.String(),
+		Name:   userID.String(),
+		Scopes: []string{models.ScopeRead, models.ScopeWrite},
+		UserID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+}
+
+func TestAuthorizeSubscription(t *testing.T) {
+	operations := []struct {
+		name string
+		call func(ctx context.Context, m *manager.Manager, id string) error
+	}{
+		{"get", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.GetSubscription(ctx, id)
+			return err
+		}},
+		{"update", func(ctx context.Context, m *manager.Manager, id string) error {
+			updated := models.Subscription{ServiceName: "Yandex Plus", Price: 500, StartDate: models.NewMonth(2025, time.July)}
+			// клиенту, ограниченному пользователем, user_id подставляется из токена, остальным его нужно передать
+			if principal, ok := models.PrincipalFrom(ctx); !ok || !principal.UserID.Valid {
+				updated.UserID = ownerID
+			}
+			_, err := m.UpdateSubscription(ctx, id, updated, 0, manager.UpdateParams{})
+			return err
+		}},
+		{"patch", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.PatchSubscription(ctx, id, []byte(`{"price":500}`), 0)
+			return err
+		}},
+		{"change price", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.ChangeSubscriptionPrice(ctx, id, models.PriceChange{Price: 500, EffectiveFrom: models.NewMonth(2025, time.September)}, 0)
+			return err
+		}},
+		{"get prices", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.GetSubscriptionPrices(ctx, id)
+			return err
+		}},
+		{"get history", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.GetSubscriptionHistory(ctx, id)
+			return err
+		}},
+		{"get audit", func(ctx context.Context, m *manager.Manager, id string) error {
+			_, err := m.GetAuditEvents(ctx, manager.AuditParams{SubscriptionID: id})
+			return err
+		}},
+		{"delete", func(ctx context.Context, m *manager.Manager, id string) error {
+			return m.DeleteSubscription(ctx, id)
+		}},
+	}
+	callers := []struct {
+		name    string
+		ctx     func(context.Context) context.Context
+		wantErr error
+	}{
+		{"owner", func(ctx context.Context) context.Context { return userContext(ctx, ownerID) }, nil},
+		{"another user", func(ctx context.Context) context.Context { return userContext(ctx, strangerID) }, manager.ErrSubscriptionNotOwned},
+		{"API key", func(ctx context.Context) context.Context {
+			return models.WithPrincipal(ctx, models.Principal{ID: "api_key:1", Name: "ci", Scopes: []string{models.ScopeRead, models.ScopeWrite}})
+		}, nil},
+		{"background job", func(ctx context.Context) context.Context { return ctx }, nil},
+	}
+
+	for _, operation := range operations {
+		for _, caller := range callers {
+			t.Run(operation.name+" by "+caller.name, func(t *testing.T) {
+				m := manager.New(storage.NewMemory(), time.Second)
+				created, err := m.CreateSubscription(t.Context(), models.Subscription{UserID: ownerID, ServiceName: "Yandex Plus", Price: 400, StartDate: models.NewMonth(2025, time.July)})
+				if err != nil {
+					t.Fatalf("CreateSubscription() error = %v", err)
+				}
+
+				err = operation.call(caller.ctx(t.Context()), m, strconv.Itoa(created.ID))
+				if !errors.Is(err, caller.wantErr) {
+					t.Fatalf("%s error = %v, want %v", operation.name, err, caller.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizeDeletedSubscription(t *testing.T) {
+	m := manager.New(storage.NewMemory(), time.Second)
+	created, err := m.CreateSubscription(t.Context(), models.Subscription{UserID: ownerID, ServiceName: "Yandex Plus", Price: 400, StartDate: models.NewMonth(2025, time.July)})
+	if err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+	id := strconv.Itoa(created.ID)
+	if err := m.DeleteSubscription(t.Context(), id); err != nil {
+		t.Fatalf("DeleteSubscription() error = %v", err)
+	}
+
+	if _, err := m.RestoreSubscription(userContext(t.Context(), strangerID), id); !errors.Is(err, manager.ErrSubscriptionNotOwned) {
+		t.Fatalf("RestoreSubscription() by another user error = %v, want %v", err, manager.ErrSubscriptionNotOwned)
+	}
+	if _, err := m.RestoreSubscription(userContext(t.Context(), ownerID), id); err != nil {
+		t.Fatalf("RestoreSubscription() by owner error = %v", err)
+	}
+}
+
+func TestRequireUnscoped(t *testing.T) {
+	m := manager.New(storage.NewMemory(), time.Second)
+	rate := models.ExchangeRate{Currency: "USD", Rate: 92.5, EffectiveFrom: models.NewMonth(2025, time.January)}
+	admin := models.WithPrincipal(t.Context(), models.Principal{ID: "token:ops", Name: "ops", Scopes: []string{models.ScopeAdmin}})
+
+	if _, err := m.CreateSubscription(t.Context(), models.Subscription{UserID: ownerID, ServiceName: "Yandex Plus", Price: 400, StartDate: models.NewMonth(2025, time.July)}); err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+
+	if _, err := m.CreateExchangeRate(userContext(t.Context(), ownerID), rate); !errors.Is(err, manager.ErrAdminRequired) {
+		t.Fatalf("CreateExchangeRate() by user error = %v, want %v", err, manager.ErrAdminRequired)
+	}
+	if _, err := m.GetAuditEvents(userContext(t.Context(), ownerID), manager.AuditParams{}); !errors.Is(err, manager.ErrAdminRequired) {
+		t.Fatalf("GetAuditEvents() by user error = %v, want %v", err, manager.ErrAdminRequired)
+	}
+	if _, err := m.CreateExchangeRate(admin, rate); err != nil {
+		t.Fatalf("CreateExchangeRate() by admin error = %v", err)
+	}
+	if _, err := m.GetAuditEvents(admin, manager.AuditParams{}); err != nil {
+		t.Fatalf("GetAuditEvents() by admin error = %v", err)
+	}
+}
+
+func TestScopeSubscriptionUser(t *testing.T) {
+	m := manager.New(storage.NewMemory(), time.Second)
+	ctx := userContext(t.Context(), ownerID)
+
+	created, err := m.CreateSubscription(ctx, models.Subscription{ServiceName: "Yandex Plus", Price: 400, StartDate: models.NewMonth(2025, time.July)})
+	if err != nil {
+		t.Fatalf("CreateSubscription() without user_id error = %v", err)
+	}
+	if created.UserID != ownerID {
+		t.Fatalf("CreateSubscription() user = %s, want the authenticated user %s", created.UserID, ownerID)
+	}
+	if _, err := m.CreateSubscription(ctx, models.Subscription{UserID: strangerID, ServiceName: "Sber Prime", Price: 200, StartDate: models.NewMonth(2025, time.July)}); !errors.Is(err, manager.ErrForeignUser) {
+		t.Fatalf("CreateSubscription() for another user error = %v, want %v", err, manager.ErrForeignUser)
+	}
+	if _, err := m.UpdateSubscription(userContext(t.Context(), strangerID), strconv.Itoa(created.ID), models.Subscription{UserID: ownerID, ServiceName: "Yandex Plus", Price: 500, StartDate: models.NewMonth(2025, time.July)}, 0, manager.UpdateParams{}); !errors.Is(err, manager.ErrForeignUser) {
+		t.Fatalf("UpdateSubscription() with another user's user_id error = %v, want %v", err, manager.ErrForeignUser)
+	}
+	if _, err := m.GetAllSubscriptions(ctx, manager.ListParams{UserID: strangerID.String()}); !errors.Is(err, manager.ErrForeignUser) {
+		t.Fatalf("GetAllSubscriptions() for another user error = %v, want %v", err, manager.ErrForeignUser)
+	}
+}
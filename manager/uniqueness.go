@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"subscription-aggregator-api/models"
+)
+
+// SetUniquenessPolicy задаёт правило уникальности подписок; по умолчанию models.UniquenessSameStart
+func (m *Manager) SetUniquenessPolicy(policy string) {
+	m.uniqueness = policy
+}
+
+func (m *Manager) uniquenessPolicy() string {
+	if m.uniqueness == "" {
+		return models.UniquenessSameStart
+	}
+	return m.uniqueness
+}
+
+// markUniqueness включает для подписки ограничение базы на пересечение периодов, если действует models.UniquenessNoOverlap
+func (m *Manager) markUniqueness(subscription *models.Subscription) {
+	subscription.NoOverlap = m.uniquenessPolicy() == models.UniquenessNoOverlap
+}
+
+// conflicts сообщает, нарушает ли пара подписок правило уникальности
+func (m *Manager) conflicts(a, b models.Subscription) bool {
+	if a.ID != 0 && a.ID == b.ID || a.Key() != b.Key() {
+		return false
+	}
+	if m.uniquenessPolicy() == models.UniquenessNoOverlap {
+		return a.Overlaps(b)
+	}
+	return a.StartsWith(b)
+}
+
+// findConflict возвращает первую подписку из active, с которой конфликтует subscription
+func (m *Manager) findConflict(subscription models.Subscription, active []models.Subscription) (models.Subscription, bool) {
+	for _, existing := range active {
+		if m.conflicts(subscription, existing) {
+			return existing, true
+		}
+	}
+	return models.Subscription{}, false
+}
+
+// checkUniqueness возвращает *models.ConflictError, если у пользователя уже есть действующая подписка на тот же сервис,
+// которая конфликтует с subscription. Параллельные запросы проверка не упорядочивает: конфликтующую запись
+// из них отклонит ограничение базы, см. conflictError
+func (m *Manager) checkUniqueness(ctx context.Context, subscription models.Subscription) error {
+	active, err := m.storage.FindActive(ctx, []models.ServiceKey{subscription.Key()})
+	if err != nil {
+		return err
+	}
+	if existing, ok := m.findConflict(subscription, active); ok {
+		return &models.ConflictError{ID: existing.ID, Policy: m.uniquenessPolicy()}
+	}
+	return nil
+}
+
+// conflictError заменяет *models.UniquenessViolationError хранилища на *models.ConflictError и ищет подписку,
+// с которой конфликтует отклонённая; остальные ошибки возвращаются без изменений
+func (m *Manager) conflictError(ctx context.Context, err error) error {
+	var violation *models.UniquenessViolationError
+	if !errors.As(err, &violation) {
+		return err
+	}
+
+	conflict := &models.ConflictError{Policy: m.uniquenessPolicy()}
+	rejected := violation.Subscription
+	if rejected.ServiceName == "" {
+		return conflict
+	}
+
+	active, findErr := m.storage.FindActive(ctx, []models.ServiceKey{rejected.Key()})
+	if findErr != nil {
+		return conflict
+	}
+	for _, existing := range active {
+		if existing.ID == rejected.ID {
+			continue
+		}
+		if policy := existing.ConstraintConflict(rejected); policy != "" {
+			conflict.ID, conflict.Policy = existing.ID, policy
+			break
+		}
+	}
+	return conflict
+}
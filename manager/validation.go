@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"subscription-aggregator-api/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrBodyEmpty     = errors.New("request body is empty")
+	ErrBodyMalformed = errors.New("request body is not valid JSON")
+	ErrBodyNotObject = errors.New("request body must be a JSON object")
+	ErrBodyInvalid   = errors.New("request body does not match the expected schema")
+)
+
+// FieldError описывает некорректное значение одного поля
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+// ValidationError перечисляет все некорректные поля, а не только первое из них
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// jsonTypeNames — названия типов JSON для типов Go, в которые разбирается тело запроса
+var jsonTypeNames = map[string]string{
+	"int":     "an integer",
+	"float64": "a number",
+	"string":  "a string",
+	"bool":    "a boolean",
+}
+
+// DecodeError переводит ошибку разбора JSON в ошибку запроса, не раскрывая внутренних сообщений декодера:
+// несовпадение типа поля становится *ValidationError, остальные ошибки — *BadRequestError
+func DecodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return &BadRequestError{msg: ErrBodyEmpty.Error()}
+	case errors.As(err, &syntaxErr):
+		return &BadRequestError{msg: fmt.Sprintf("%s: syntax error at offset %d", ErrBodyMalformed, syntaxErr.Offset)}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &BadRequestError{msg: ErrBodyMalformed.Error()}
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return &BadRequestError{msg: ErrBodyNotObject.Error()}
+	case errors.As(err, &typeErr):
+		typeName, ok := jsonTypeNames[typeErr.Type.String()]
+		if !ok {
+			typeName = "a valid value"
+		}
+		return &ValidationError{Fields: []FieldError{{
+			Field: typeErr.Field,
+			Err:   fmt.Errorf("%s must be %s", typeErr.Field, typeName),
+		}}}
+	case errors.Is(err, models.ErrInvalidMonth), errors.Is(err, models.ErrInvalidCursor):
+		return &BadRequestError{msg: err.Error()}
+	case uuid.IsInvalidLengthError(err), strings.HasPrefix(err.Error(), "invalid UUID"):
+		return &ValidationError{Fields: []FieldError{{Field: "user_id", Err: ErrInvalidUserID}}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &ValidationError{Fields: []FieldError{{Field: field, Err: fmt.Errorf("unknown field %q", field)}}}
+	default:
+		return &BadRequestError{msg: ErrBodyInvalid.Error()}
+	}
+}
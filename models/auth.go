@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	// ScopeAdmin разрешает управление ключами и включает все остальные права
+	ScopeAdmin = "admin"
+)
+
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// APIKey описывает ключ доступа к API; сам ключ не хранится, только его хеш
+// swagger:model APIKey
+type APIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name" example:"billing-service"`
+	// Prefix — начало ключа, по которому его можно узнать в списке
+	Prefix     string     `json:"prefix" example:"sa_Xb3kP9qL"`
+	Scopes     []string   `json:"scopes" example:"read,write"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Principal описывает аутентифицированного клиента запроса
+type Principal struct {
+	// ID однозначно определяет клиента (например, "api_key:3"), Name — имя, которое попадает в журнал изменений
+	ID     string
+	Name   string
+	Scopes []string
+	// UserID ограничивает запросы подписками одного пользователя; не задан у ключей доступа и администраторов
+	UserID uuid.NullUUID
+}
+
+// HasScope сообщает, есть ли у клиента право scope; право admin включает все остальные
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom возвращает клиента запроса из контекста; ok = false, если запрос не аутентифицирован
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
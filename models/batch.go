@@ -0,0 +1,43 @@
+package models
+
+import "errors"
+
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+
+	// BatchModeAtomic — операции выполняются в одной транзакции: ошибка любой отменяет все
+	BatchModeAtomic = "atomic"
+	// BatchModeBestEffort — каждая операция применяется независимо от остальных
+	BatchModeBestEffort = "best_effort"
+)
+
+var (
+	BatchOps   = []string{BatchOpCreate, BatchOpUpdate, BatchOpDelete}
+	BatchModes = []string{BatchModeAtomic, BatchModeBestEffort}
+)
+
+// BatchOperation описывает одну операцию пакетного запроса
+// swagger:model BatchOperation
+type BatchOperation struct {
+	Op string `json:"op" enums:"create,update,delete" example:"create"`
+	// ID — подписка для update и delete
+	ID int `json:"id,omitempty"`
+	// Version — ожидаемая версия подписки для update, как If-Match; 0 — без проверки, если не включён SERVER_REQUIRE_IF_MATCH
+	Version int `json:"version,omitempty"`
+	// Subscription — новая подписка для create или её новое состояние для update
+	Subscription *Subscription `json:"subscription,omitempty"`
+}
+
+// BatchResult описывает результат одной операции пакетного запроса
+type BatchResult struct {
+	Op           string
+	ID           int
+	Subscription *Subscription
+	// Err — ошибка операции; в режиме atomic у операций, отменённых из-за чужой ошибки, это ErrBatchRolledBack
+	Err error
+}
+
+// ErrBatchRolledBack — операция не применена, потому что в режиме atomic ошибкой завершилась другая операция пакета
+var ErrBatchRolledBack = errors.New("operation was not applied because another batch operation failed")
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// BaseCurrency — валюта, к которой задаются курсы; курс базовой валюты всегда равен 1
+const BaseCurrency = "RUB"
+
+var ErrInvalidCurrency = errors.New("currency must be a three-letter ISO 4217 code")
+
+// ParseCurrency приводит код валюты к верхнему регистру и проверяет, что он состоит из трёх латинских букв
+func ParseCurrency(value string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(value))
+	if len(code) != 3 {
+		return "", ErrInvalidCurrency
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrInvalidCurrency
+		}
+	}
+	return code, nil
+}
+
+// ExchangeRate описывает курс валюты, действующий с месяца EffectiveFrom до следующего курса
+// swagger:model ExchangeRate
+type ExchangeRate struct {
+	Currency string `json:"currency" example:"USD"`
+	// Rate — стоимость одной единицы Currency в BaseCurrency
+	Rate          float64   `json:"rate" example:"92.5"`
+	EffectiveFrom Month     `json:"effective_from" swaggertype:"string" example:"01-2025"`
+	CreatedAt     time.Time `json:"created_at,omitzero" readonly:"true"`
+}
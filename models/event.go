@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventActionCreate  = "create"
+	EventActionUpdate  = "update"
+	EventActionDelete  = "delete"
+	EventActionRestore = "restore"
+	EventActionPurge   = "purge"
+	// EventActionPriceChange — цена изменена с определённого месяца без переписывания истории
+	EventActionPriceChange = "price_change"
+
+	// AnonymousActor записывается в журнал, если инициатор изменения неизвестен
+	AnonymousActor = "anonymous"
+	// SystemActor — инициатор фоновых изменений (например, очистки удалённых подписок)
+	SystemActor = "system"
+)
+
+var EventActions = []string{EventActionCreate, EventActionUpdate, EventActionPriceChange, EventActionDelete, EventActionRestore, EventActionPurge}
+
+// SubscriptionEvent описывает запись журнала изменений подписки
+// swagger:model SubscriptionEvent
+type SubscriptionEvent struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	Action         string          `json:"action" example:"update"`
+	Actor          string          `json:"actor"`
+	RequestID      string          `json:"request_id,omitempty"`
+	Before         json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After          json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// EventFilter описывает фильтры журнала изменений; события отдаются от новых к старым
+type EventFilter struct {
+	SubscriptionID int
+	Actor          string
+	Action         string
+	Since          time.Time
+	Until          time.Time
+	Limit          int
+	// BeforeID — ID последнего события предыдущей страницы
+	BeforeID int64
+}
+
+// EventPage описывает страницу журнала изменений
+type EventPage struct {
+	Items      []SubscriptionEvent
+	NextCursor string
+}
+
+// AuditInfo описывает инициатора изменения, который попадает в журнал
+type AuditInfo struct {
+	Actor     string
+	RequestID string
+}
+
+type auditInfoKey struct{}
+
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFrom возвращает инициатора изменения из контекста; по умолчанию AnonymousActor
+func AuditInfoFrom(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	if info.Actor == "" {
+		info.Actor = AnonymousActor
+	}
+	return info
+}
+
+// NewSubscriptionEvent строит запись журнала по состояниям подписки до и после изменения
+func NewSubscriptionEvent(ctx context.Context, action string, subscriptionID int, before, after *Subscription) (SubscriptionEvent, error) {
+	info := AuditInfoFrom(ctx)
+	event := SubscriptionEvent{
+		SubscriptionID: subscriptionID,
+		Action:         action,
+		Actor:          info.Actor,
+		RequestID:      info.RequestID,
+	}
+
+	var err error
+	if before != nil {
+		if event.Before, err = json.Marshal(before); err != nil {
+			return SubscriptionEvent{}, err
+		}
+	}
+	if after != nil {
+		if event.After, err = json.Marshal(after); err != nil {
+			return SubscriptionEvent{}, err
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,9 @@
+package models
+
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+	ExportFormatXLSX  = "xlsx"
+)
+
+var ExportFormats = []string{ExportFormatCSV, ExportFormatJSONL, ExportFormatXLSX}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// IdempotencyRecord — запрос с ключом идемпотентности (Idempotency-Key) и сохранённый ответ на него
+type IdempotencyRecord struct {
+	// Owner — клиент, которому принадлежит ключ; ключи разных клиентов не пересекаются
+	Owner string
+	Key   string
+	// RequestHash — хеш метода, пути и тела запроса, по нему распознаётся повтор того же запроса
+	RequestHash string
+	// Response равен nil, пока запрос выполняется
+	Response  *StoredResponse
+	ExpiresAt time.Time
+}
+
+// StoredResponse — ответ, который возвращается при повторе запроса с тем же ключом
+type StoredResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
@@ -0,0 +1,40 @@
+package models
+
+const (
+	ImportFormatCSV   = "csv"
+	ImportFormatJSONL = "jsonl"
+)
+
+var ImportFormats = []string{ImportFormatCSV, ImportFormatJSONL}
+
+// ImportRowError описывает строку файла импорта, которая не прошла разбор или проверку
+// swagger:model ImportRowError
+type ImportRowError struct {
+	Line  int    `json:"line" example:"3"`
+	Error string `json:"error" example:"price must be greater than 0"`
+	// ConflictingID — действующая подписка, с которой конфликтует подписка строки
+	ConflictingID int `json:"conflicting_id,omitempty" example:"42"`
+	// ConflictingLine — предыдущая строка файла, с которой конфликтует подписка строки
+	ConflictingLine int `json:"conflicting_line,omitempty" example:"2"`
+	// Fields — некорректные поля строки в том же виде, что errors в ответе с ошибкой проверки запроса
+	Fields []ImportFieldError `json:"errors,omitempty"`
+}
+
+// ImportFieldError описывает некорректное поле строки файла импорта
+// swagger:model ImportFieldError
+type ImportFieldError struct {
+	Field   string `json:"field" example:"price"`
+	Message string `json:"message" example:"price must be greater than 0"`
+}
+
+// ImportReport описывает результат импорта подписок
+type ImportReport struct {
+	DryRun bool
+	// Rows — число прочитанных строк с данными
+	Rows     int
+	Valid    int
+	Imported int
+	Failed   int
+	// Errors — ошибки первых строк, не прошедших проверку, в порядке строк; их общее число — Failed
+	Errors []ImportRowError
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -11,5 +13,128 @@ type Subscription struct {
 	UserID      uuid.UUID `json:"user_id"`
 	ServiceName string    `json:"service_name"`
 	Price       int       `json:"price"`
-	StartDate   string    `json:"start_date"`
+	// Currency — код валюты цены по ISO 4217
+	Currency  string `json:"currency,omitempty" example:"RUB"`
+	StartDate Month  `json:"start_date" swaggertype:"string" example:"07-2025"`
+	EndDate   *Month `json:"end_date,omitempty" swaggertype:"string" example:"12-2025"`
+	// BillingPeriod и BillingInterval задают цикл оплаты: Price списывается раз в BillingInterval периодов
+	BillingPeriod   string     `json:"billing_period,omitempty" enums:"week,month,quarter,year" example:"month"`
+	BillingInterval int        `json:"billing_interval,omitempty" example:"1"`
+	Version         int        `json:"version,omitempty" readonly:"true"`
+	CreatedAt       time.Time  `json:"created_at,omitzero" readonly:"true"`
+	UpdatedAt       time.Time  `json:"updated_at,omitzero" readonly:"true"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" readonly:"true"`
+	// NoOverlap включает для подписки ограничение базы на пересечение периодов, см. UniquenessNoOverlap
+	NoOverlap bool `json:"-"`
+}
+
+const (
+	BillingPeriodWeek    = "week"
+	BillingPeriodMonth   = "month"
+	BillingPeriodQuarter = "quarter"
+	BillingPeriodYear    = "year"
+)
+
+var BillingPeriods = []string{BillingPeriodWeek, BillingPeriodMonth, BillingPeriodQuarter, BillingPeriodYear}
+
+// ActiveIn сообщает, активна ли подписка в указанном месяце
+func (s Subscription) ActiveIn(month Month) bool {
+	if month.Before(s.StartDate) {
+		return false
+	}
+	return s.EndDate == nil || !month.After(*s.EndDate)
+}
+
+// PriceChange описывает цену подписки, действующую с месяца EffectiveFrom до следующего изменения
+// swagger:model PriceChange
+type PriceChange struct {
+	Price         int       `json:"price"`
+	EffectiveFrom Month     `json:"effective_from" swaggertype:"string" example:"01-2026"`
+	CreatedAt     time.Time `json:"created_at,omitzero" readonly:"true"`
+}
+
+// SumFilter описывает период и фильтры для подсчёта стоимости подписок
+type SumFilter struct {
+	From        Month
+	To          Month
+	UserID      uuid.NullUUID
+	ServiceName string
+}
+
+// SumItem описывает вклад подписки в суммарную стоимость за отрезок периода с одной ценой;
+// если цена менялась внутри периода, подписка даёт несколько элементов
+// swagger:model SumItem
+type SumItem struct {
+	ID          int       `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ServiceName string    `json:"service_name"`
+	Price       int       `json:"price"`
+	// Currency — валюта цены; Cost пересчитан в валюту отчёта
+	Currency string `json:"currency"`
+	From     Month  `json:"from" swaggertype:"string" example:"01-2025"`
+	To       Month  `json:"to" swaggertype:"string" example:"06-2025"`
+	Months   int    `json:"months"`
+	// Charges — число списаний за отрезок; заполняется только при расчёте по движению денег
+	Charges         int    `json:"charges,omitempty"`
+	Cost            int    `json:"cost"`
+	BillingPeriod   string `json:"billing_period"`
+	BillingInterval int    `json:"billing_interval"`
+	// StartDate — начало подписки, от которого отсчитываются месяцы продления
+	StartDate Month `json:"-"`
+}
+
+const (
+	// SumBasisAccrual распределяет стоимость цикла оплаты равномерно по месяцам
+	SumBasisAccrual = "accrual"
+	// SumBasisCashFlow относит стоимость цикла оплаты целиком к месяцу списания
+	SumBasisCashFlow = "cash_flow"
+)
+
+var SumBases = []string{SumBasisAccrual, SumBasisCashFlow}
+
+// SumReport описывает суммарную стоимость подписок за период с разбивкой
+type SumReport struct {
+	// From и To — период отчёта, приведённый к месяцам
+	From     Month
+	To       Month
+	Basis    string
+	Currency string
+	TotalSum int
+	Items    []SumItem
+}
+
+const (
+	SortByID          = "id"
+	SortByPrice       = "price"
+	SortByStartDate   = "start_date"
+	SortByServiceName = "service_name"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+var (
+	ListSortFields = []string{SortByID, SortByPrice, SortByStartDate, SortByServiceName}
+	ListOrders     = []string{OrderAsc, OrderDesc}
+)
+
+// ListFilter описывает фильтры, сортировку и страницу списка подписок
+type ListFilter struct {
+	UserID            uuid.NullUUID
+	ServiceName       string
+	ServiceNamePrefix string
+	PriceMin          int
+	PriceMax          int
+	ActiveIn          Month
+	IncludeDeleted    bool
+	Sort              string
+	Order             string
+	Limit             int
+	Cursor            *ListCursor
+}
+
+// SubscriptionPage описывает страницу списка подписок
+type SubscriptionPage struct {
+	Items      []Subscription
+	NextCursor string
 }
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MonthLayout       = "01-2006"
+	legacyMonthLayout = "2006-01-02"
+)
+
+var ErrInvalidMonth = errors.New("date must be in format MM-YYYY")
+
+// Month описывает дату с точностью до месяца (MM-YYYY)
+type Month struct {
+	t time.Time
+	// invalid — ошибка разбора значения из JSON; такой месяц считается пустым
+	invalid error
+}
+
+func NewMonth(year int, month time.Month) Month {
+	return Month{t: time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func MonthOf(t time.Time) Month {
+	return NewMonth(t.Year(), t.Month())
+}
+
+// ParseMonth разбирает дату в формате MM-YYYY, а также устаревший формат YYYY-MM-DD
+func ParseMonth(value string) (Month, error) {
+	for _, layout := range []string{MonthLayout, legacyMonthLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return MonthOf(t), nil
+		}
+	}
+	return Month{}, fmt.Errorf("%w, got: %q", ErrInvalidMonth, value)
+}
+
+func (m Month) Time() time.Time {
+	return m.t
+}
+
+func (m Month) IsZero() bool {
+	return m.t.IsZero()
+}
+
+func (m Month) Before(other Month) bool {
+	return m.t.Before(other.t)
+}
+
+func (m Month) After(other Month) bool {
+	return m.t.After(other.t)
+}
+
+func (m Month) AddMonths(n int) Month {
+	return Month{t: m.t.AddDate(0, n, 0)}
+}
+
+// MonthsUntil возвращает число месяцев от m до other включительно
+func (m Month) MonthsUntil(other Month) int {
+	return (other.t.Year()-m.t.Year())*12 + int(other.t.Month()) - int(m.t.Month()) + 1
+}
+
+func (m Month) String() string {
+	if m.IsZero() {
+		return ""
+	}
+	return m.t.Format(MonthLayout)
+}
+
+func (m Month) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// Err возвращает ошибку разбора, если значение из JSON не является датой
+func (m Month) Err() error {
+	return m.invalid
+}
+
+// UnmarshalJSON не прерывает разбор документа на некорректной дате: месяц остаётся пустым,
+// а ошибку возвращает Err, чтобы проверка тела запроса сообщила о ней вместе с остальными полями
+func (m *Month) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		*m = Month{invalid: ErrInvalidMonth}
+		return nil
+	}
+	parsed, err := ParseMonth(value)
+	if err != nil {
+		*m = Month{invalid: err}
+		return nil
+	}
+	*m = parsed
+	return nil
+}
+
+func (m *Month) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		*m = MonthOf(v)
+		return nil
+	case string:
+		parsed, err := ParseMonth(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case []byte:
+		return m.Scan(string(v))
+	case nil:
+		*m = Month{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Month", src)
+	}
+}
+
+func (m Month) Value() (driver.Value, error) {
+	if m.IsZero() {
+		return nil, nil
+	}
+	return m.t.Format(legacyMonthLayout), nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// UniquenessSameStart запрещает пользователю две действующие подписки на один сервис, начинающиеся в одном месяце
+	UniquenessSameStart = "same_start"
+	// UniquenessNoOverlap запрещает пересечение периодов действующих подписок пользователя на один сервис
+	UniquenessNoOverlap = "no_overlap"
+)
+
+var UniquenessPolicies = []string{UniquenessSameStart, UniquenessNoOverlap}
+
+// ServiceKey — пользователь и сервис, в пределах которых действует правило уникальности подписок
+type ServiceKey struct {
+	UserID      uuid.UUID
+	ServiceName string
+}
+
+// Key возвращает пользователя и сервис подписки
+func (s Subscription) Key() ServiceKey {
+	return ServiceKey{UserID: s.UserID, ServiceName: s.ServiceName}
+}
+
+// openEnd заменяет отсутствующую дату окончания при проверке пересечения периодов
+var openEnd = NewMonth(9999, time.December)
+
+// StartsWith сообщает, начинаются ли подписки в одном месяце
+func (s Subscription) StartsWith(other Subscription) bool {
+	return s.StartDate.Time().Equal(other.StartDate.Time())
+}
+
+// Overlaps сообщает, пересекаются ли периоды действия подписок
+func (s Subscription) Overlaps(other Subscription) bool {
+	end, otherEnd := openEnd, openEnd
+	if s.EndDate != nil {
+		end = *s.EndDate
+	}
+	if other.EndDate != nil {
+		otherEnd = *other.EndDate
+	}
+	return !s.StartDate.After(otherEnd) && !other.StartDate.After(end)
+}
+
+// ConstraintConflict возвращает правило, ограничение базы для которого не даёт подпискам одного пользователя
+// на один сервис быть действующими одновременно: UniquenessSameStart действует всегда, UniquenessNoOverlap —
+// для подписок, сохранённых с NoOverlap. Пустая строка означает, что ограничения не нарушены
+func (s Subscription) ConstraintConflict(other Subscription) string {
+	switch {
+	case s.Key() != other.Key():
+		return ""
+	case s.StartsWith(other):
+		return UniquenessSameStart
+	case s.NoOverlap && other.NoOverlap && s.Overlaps(other):
+		return UniquenessNoOverlap
+	default:
+		return ""
+	}
+}
+
+// ConflictError — подписка нарушает правило уникальности Policy. ID — действующая подписка, с которой она
+// конфликтует, Line — строка файла импорта с такой подпиской; оба 0, если подписка неизвестна
+type ConflictError struct {
+	ID     int
+	Line   int
+	Policy string
+}
+
+func (e *ConflictError) Error() string {
+	rule := "starts in the same month as"
+	if e.Policy == UniquenessNoOverlap {
+		rule = "overlaps"
+	}
+	switch {
+	case e.ID != 0:
+		return fmt.Sprintf("subscription %s subscription %d of the same user to the same service", rule, e.ID)
+	case e.Line != 0:
+		return fmt.Sprintf("subscription %s the subscription on line %d of the same user to the same service", rule, e.Line)
+	default:
+		return "subscription " + rule + " another subscription of the same user to the same service"
+	}
+}
+
+// UniquenessViolationError — хранилище отклонило подписку Subscription по ограничению уникальности базы.
+// Subscription пуста, если хранилище не знает, какая из записанных подписок нарушила ограничение
+type UniquenessViolationError struct {
+	Subscription Subscription
+}
+
+func (e *UniquenessViolationError) Error() string {
+	return "subscription violates a uniqueness constraint"
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"subscription-aggregator-api/models"
+
+	"github.com/go-chi/chi"
+)
+
+// CreateAPIKeyRequest описывает выпуск ключа доступа
+// swagger:model CreateAPIKeyRequest
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" example:"billing-service"`
+	Scopes []string `json:"scopes" example:"read,write"`
+}
+
+// CreateAPIKeyResponse содержит выпущенный ключ; key больше нигде не показывается, сохраните его сразу
+// swagger:model CreateAPIKeyResponse
+type CreateAPIKeyResponse struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key" example:"sa_Xb3kP9qLr2mVt8wYc1dFh6jN0sA4eG7uI5oZ3xB9kQw"`
+}
+
+// APIKeysResponse описывает список ключей доступа
+// swagger:model APIKeysResponse
+type APIKeysResponse struct {
+	Items []models.APIKey `json:"items"`
+}
+
+// @Summary      Выпустить ключ доступа
+// @Description  Создаёт ключ доступа с правами read, write и/или admin; сам ключ возвращается только в этом ответе
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        key  body      CreateAPIKeyRequest  true  "Имя и права ключа"
+// @Success      201  {object}  CreateAPIKeyResponse
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Router       /api-keys [post]
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.handleDecodeError(w, r, "CreateAPIKeyRequest", err)
+		return
+	}
+
+	key, secret, err := s.manager.CreateAPIKey(r.Context(), request.Name, request.Scopes)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	slog.Info("API key created successfully", "id", key.ID, "name", key.Name, "scopes", key.Scopes)
+	writeJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: key, Key: secret})
+}
+
+// @Summary      Получить ключи доступа
+// @Description  Возвращает все ключи доступа, включая отозванные, без самих ключей
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  APIKeysResponse
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Router       /api-keys [get]
+func (s *Server) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.manager.GetAPIKeys(r.Context())
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, APIKeysResponse{Items: keys})
+	slog.Info("API keys retrieved successfully", "count", len(keys))
+}
+
+// @Summary      Отозвать ключ доступа
+// @Description  Отзывает ключ доступа: запросы с ним сразу получают 401. Запись о ключе остаётся в списке
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID ключа"
+// @Success      200  {object}  models.APIKey
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Router       /api-keys/{id} [delete]
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	key, err := s.manager.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	slog.Info("API key revoked successfully", "id", key.ID, "name", key.Name)
+	writeJSON(w, http.StatusOK, key)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+)
+
+const (
+	AuthorizationHeader = "Authorization"
+	BearerScheme        = "Bearer"
+
+	ErrUnauthorized      = "Authorization header must contain a valid API key or token: Bearer <credentials>"
+	ErrInsufficientScope = "API key does not have the required scope"
+)
+
+// authenticate проверяет ключ доступа или JWT из Authorization: Bearer и передаёт клиента в контекст.
+// Инициатором в журнале изменений всегда записывается имя ключа или sub токена: заголовок X-Actor
+// не подтверждён аутентификацией и учитывается, только когда она выключена
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authCfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.checkAuthFailures(w, r) {
+			return
+		}
+
+		scheme, token, ok := strings.Cut(r.Header.Get(AuthorizationHeader), " ")
+		token = strings.TrimSpace(token)
+		if !ok || !strings.EqualFold(scheme, BearerScheme) || token == "" {
+			s.rejectCredentials(w, r)
+			return
+		}
+
+		var principal models.Principal
+		switch {
+		case strings.HasPrefix(token, manager.APIKeyPrefix):
+			var err error
+			principal, err = s.manager.AuthenticateAPIKey(r.Context(), token)
+			if errors.Is(err, manager.ErrUnauthenticated) {
+				slog.Warn("Invalid API key", "path", r.URL.Path)
+				s.rejectCredentials(w, r)
+				return
+			}
+			if err != nil {
+				s.handleSubscriptionError(w, r, err)
+				return
+			}
+		case s.jwt != nil:
+			var err error
+			principal, err = s.jwt.Verify(token)
+			if err != nil {
+				slog.Warn("Invalid token", "path", r.URL.Path, "error", err)
+				s.rejectCredentials(w, r)
+				return
+			}
+		default:
+			s.rejectCredentials(w, r)
+			return
+		}
+
+		ctx := models.WithPrincipal(r.Context(), principal)
+		info := models.AuditInfoFrom(ctx)
+		info.Actor = principal.Name
+		ctx = models.WithAuditInfo(ctx, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope пропускает только запросы клиентов с правом scope
+func (s *Server) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.authCfg.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, ok := models.PrincipalFrom(r.Context())
+			if !ok {
+				unauthorized(w, r)
+				return
+			}
+			if !principal.HasScope(scope) {
+				slog.Warn("Insufficient API key scope", "name", principal.Name, "scope", scope, "path", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", BearerScheme+` error="insufficient_scope", scope="`+scope+`"`)
+				writeProblem(w, r, problemForbidden, ErrInsufficientScope+": "+scope, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectCredentials отвечает 401 и учитывает неудачную попытку аутентификации в лимите адреса клиента
+func (s *Server) rejectCredentials(w http.ResponseWriter, r *http.Request) {
+	s.chargeAuthFailure(r)
+	unauthorized(w, r)
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", BearerScheme)
+	writeProblem(w, r, problemUnauthorized, ErrUnauthorized, nil)
+}
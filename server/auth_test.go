@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const strangerUserID = "0b9a8c7d-6e5f-4a3b-8c2d-1e0f9a8b7c6d"
+
+var testAuthConfig = config.AuthConfig{Enabled: true, JWTSecret: testJWTSecret, JWTAdminRole: "admin"}
+
+// newAuthTestServer создаёт сервер с включённой аутентификацией; токены проверяются на момент testJWTNow
+func newAuthTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s := newTestServer(t, config.ServerConfig{}, testAuthConfig)
+	s.jwt.now = func() time.Time { return testJWTNow }
+	return s
+}
+
+// userToken выпускает токен HS256 пользователя userID
+func userToken(t *testing.T, userID string, overrides map[string]any) string {
+	t.Helper()
+
+	claims := userClaims(overrides)
+	claims["sub"] = userID
+	return signHS256(t, []byte(testJWTSecret), map[string]any{"alg": jwtAlgHS256, "typ": "JWT"}, claims)
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newAuthTestServer(t)
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	_, secret := s.newAPIKey(t, "reader", models.ScopeRead)
+	token := userToken(t, testUserID, nil)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + secret, http.StatusUnauthorized},
+		{"scheme without credentials", BearerScheme, http.StatusUnauthorized},
+		{"blank credentials", BearerScheme + "   ", http.StatusUnauthorized},
+		{"unknown API key", bearer("sa_unknown"), http.StatusUnauthorized},
+		{"malformed token", bearer("not.a.token"), http.StatusUnauthorized},
+		{"expired token", bearer(userToken(t, testUserID, map[string]any{"exp": testJWTNow.Add(-time.Hour).Unix()})), http.StatusUnauthorized},
+		{"API key", bearer(secret), http.StatusOK},
+		{"lowercase scheme", "bearer " + secret, http.StatusOK},
+		{"token", bearer(token), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.authorization != "" {
+				headers = []string{AuthorizationHeader, tt.authorization}
+			}
+			w := s.do(t, http.MethodGet, target, "", headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				if problem := decodeProblem(t, w, http.StatusUnauthorized); problem.Code != problemUnauthorized.code {
+					t.Fatalf("problem code = %q, want %q", problem.Code, problemUnauthorized.code)
+				}
+				if w.Header().Get("WWW-Authenticate") != BearerScheme {
+					t.Fatalf("WWW-Authenticate = %q, want %q", w.Header().Get("WWW-Authenticate"), BearerScheme)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutJWT(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{}, config.AuthConfig{Enabled: true})
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+
+	// без AUTH_JWT_* принимаются только ключи доступа
+	w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(userToken(t, testUserID, nil)))
+	decodeProblem(t, w, http.StatusUnauthorized)
+}
+
+func TestAuthenticateRevokedKey(t *testing.T) {
+	s := newAuthTestServer(t)
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	key, secret := s.newAPIKey(t, "reader", models.ScopeRead)
+	_, adminSecret := s.newAPIKey(t, "ops", models.ScopeAdmin)
+
+	if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret)); w.Code != http.StatusOK {
+		t.Fatalf("status before revocation = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := s.do(t, http.MethodDelete, "/api-keys/"+strconv.Itoa(key.ID), "", AuthorizationHeader, bearer(adminSecret)); w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, want %d, body %s", w.Code, http.StatusOK, w.Body)
+	}
+	decodeProblem(t, s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret)), http.StatusUnauthorized)
+}
+
+func TestRequireScope(t *testing.T) {
+	s := newAuthTestServer(t)
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	_, reader := s.newAPIKey(t, "reader", models.ScopeRead)
+	_, writer := s.newAPIKey(t, "writer", models.ScopeWrite)
+	_, editor := s.newAPIKey(t, "editor", models.ScopeRead, models.ScopeWrite)
+	_, admin := s.newAPIKey(t, "ops", models.ScopeAdmin)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		credential string
+		wantStatus int
+		wantScope  string
+	}{
+		{"read with read scope", http.MethodGet, target, "", reader, http.StatusOK, ""},
+		{"read with write scope only", http.MethodGet, target, "", writer, http.StatusForbidden, models.ScopeRead},
+		{"write with read scope only", http.MethodPost, "/subscriptions", subscriptionBody("Sber Prime", 200), reader, http.StatusForbidden, models.ScopeWrite},
+		{"write with write scope", http.MethodPost, "/subscriptions", subscriptionBody("Sber Prime", 200), writer, http.StatusCreated, ""},
+		{"admin route with read and write scopes", http.MethodGet, "/api-keys", "", editor, http.StatusForbidden, models.ScopeAdmin},
+		{"admin route for a user token", http.MethodGet, "/api-keys", "", userToken(t, testUserID, nil), http.StatusForbidden, models.ScopeAdmin},
+		{"admin route with admin scope", http.MethodGet, "/api-keys", "", admin, http.StatusOK, ""},
+		{"admin route for an admin token", http.MethodGet, "/api-keys", "", userToken(t, "ops", map[string]any{"role": "admin"}), http.StatusOK, ""},
+		{"admin scope includes read", http.MethodGet, target, "", admin, http.StatusOK, ""},
+		{"read-only token cannot write", http.MethodDelete, target, "", userToken(t, testUserID, map[string]any{"scope": "read"}), http.StatusForbidden, models.ScopeWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.target, tt.body, AuthorizationHeader, bearer(tt.credential))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusForbidden {
+				return
+			}
+			if problem := decodeProblem(t, w, http.StatusForbidden); problem.Detail != ErrInsufficientScope+": "+tt.wantScope {
+				t.Fatalf("problem detail = %q, want the missing scope %q", problem.Detail, tt.wantScope)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, `scope="`+tt.wantScope+`"`) {
+				t.Fatalf("WWW-Authenticate = %q, want scope %q", challenge, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestRequireScopeWithoutPrincipal(t *testing.T) {
+	s := newAuthTestServer(t)
+	handler := s.requireScope(models.ScopeRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler called without an authenticated client")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/subscriptions", nil))
+	decodeProblem(t, w, http.StatusUnauthorized)
+}
+
+func TestAuthenticateOverridesActor(t *testing.T) {
+	tests := []struct {
+		name       string
+		authCfg    config.AuthConfig
+		credential func(s *testServer) string
+		wantActor  string
+	}{
+		{"authentication disabled", config.AuthConfig{}, func(*testServer) string { return "" }, "mallory"},
+		{"API key", testAuthConfig, func(s *testServer) string {
+			_, secret := s.newAPIKey(t, "ci-bot", models.ScopeWrite)
+			return secret
+		}, "ci-bot"},
+		{"token", testAuthConfig, func(*testServer) string { return userToken(t, testUserID, nil) }, testUserID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, config.ServerConfig{}, tt.authCfg)
+			if s.jwt != nil {
+				s.jwt.now = func() time.Time { return testJWTNow }
+			}
+			headers := []string{ActorHeader, "mallory"}
+			if credential := tt.credential(s); credential != "" {
+				headers = append(headers, AuthorizationHeader, bearer(credential))
+			}
+
+			w := s.do(t, http.MethodPost, "/subscriptions", subscriptionBody("Yandex Plus", 400), headers...)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("create status = %d, body %s", w.Code, w.Body)
+			}
+			var created models.Subscription
+			if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+				t.Fatalf("decode subscription: %v", err)
+			}
+			events, err := s.manager.GetSubscriptionHistory(t.Context(), strconv.Itoa(created.ID))
+			if err != nil || len(events) != 1 {
+				t.Fatalf("GetSubscriptionHistory() = %d events, %v, want 1 event", len(events), err)
+			}
+			if events[0].Actor != tt.wantActor {
+				t.Fatalf("event actor = %q, want %q", events[0].Actor, tt.wantActor)
+			}
+		})
+	}
+}
+
+func TestScopedUserAccess(t *testing.T) {
+	s := newAuthTestServer(t)
+	own := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	foreign := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(strangerUserID)).ID)
+	token := userToken(t, testUserID, nil)
+	update := `{"service_name":"Yandex Plus","price":500,"start_date":"07-2025"}`
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"read own subscription", http.MethodGet, own, "", http.StatusOK},
+		{"read another user's subscription", http.MethodGet, foreign, "", http.StatusNotFound},
+		{"read another user's price history", http.MethodGet, foreign + "/price-changes", "", http.StatusNotFound},
+		{"read another user's history", http.MethodGet, foreign + "/history", "", http.StatusNotFound},
+		{"update another user's subscription", http.MethodPut, foreign, update, http.StatusNotFound},
+		{"patch another user's subscription", http.MethodPatch, foreign, `{"price":500}`, http.StatusNotFound},
+		{"delete another user's subscription", http.MethodDelete, foreign, "", http.StatusNotFound},
+		{"create for another user", http.MethodPost, "/subscriptions", strings.Replace(subscriptionBody("Sber Prime", 200), testUserID, strangerUserID, 1), http.StatusForbidden},
+		{"list another user's subscriptions", http.MethodGet, "/subscriptions?user_id=" + strangerUserID, "", http.StatusForbidden},
+		{"read the whole audit log", http.MethodGet, "/audit", "", http.StatusForbidden},
+		{"update own subscription", http.MethodPut, own, update, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{AuthorizationHeader, bearer(token)}
+			if tt.method == http.MethodPatch {
+				headers = append(headers, "Content-Type", MergePatchContentType)
+			}
+			w := s.do(t, tt.method, tt.target, tt.body, headers...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	// чужая подписка не изменилась
+	foreignID, _ := strconv.Atoi(strings.TrimPrefix(foreign, "/subscriptions/"))
+	got, err := s.storage.GetByID(t.Context(), foreignID)
+	if err != nil || got.Price != 400 {
+		t.Fatalf("GetByID() = %d, %v, want the untouched price 400", got.Price, err)
+	}
+}
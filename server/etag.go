@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errIfMatchRequired = errors.New("If-Match header is required")
+	errIfMatchInvalid  = errors.New("If-Match header must contain a strong entity tag")
+)
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch возвращает ожидаемую версию подписки из If-Match; 0 означает отсутствие проверки.
+// Поддерживается один сильный ETag или "*".
+func parseIfMatch(header string, required bool) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		if required {
+			return 0, errIfMatchRequired
+		}
+		return 0, nil
+	}
+	if header == "*" {
+		return 0, nil
+	}
+
+	tag, _, _ := strings.Cut(header, ",")
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, errIfMatchInvalid
+	}
+
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version <= 0 {
+		return 0, errIfMatchInvalid
+	}
+	return version, nil
+}
+
+func etagMatches(header string, version int) bool {
+	for tag := range strings.SplitSeq(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+// exportColumns — столбцы выгрузки CSV и XLSX
+var exportColumns = []string{
+	"id", "user_id", "service_name", "price", "currency", "start_date", "end_date",
+	"billing_period", "billing_interval", "version", "created_at", "updated_at", "deleted_at",
+}
+
+// exportContentTypes — Content-Type ответа для каждого формата выгрузки
+var exportContentTypes = map[string]string{
+	models.ExportFormatCSV:   CSVContentType + "; charset=utf-8",
+	models.ExportFormatJSONL: JSONLinesContentType,
+	models.ExportFormatXLSX:  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportWriter записывает подписки в тело ответа по одной; close дописывает окончание файла
+type exportWriter interface {
+	write(subscription models.Subscription) error
+	close() error
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case models.ExportFormatJSONL:
+		return &jsonlExportWriter{encoder: json.NewEncoder(w)}, nil
+	case models.ExportFormatXLSX:
+		return newXLSXExportWriter(w)
+	default:
+		return newCSVExportWriter(w)
+	}
+}
+
+// exportRecord возвращает значения столбцов exportColumns; пустая строка означает отсутствующее значение
+func exportRecord(subscription models.Subscription) []string {
+	endDate, deletedAt := "", ""
+	if subscription.EndDate != nil {
+		endDate = subscription.EndDate.String()
+	}
+	if subscription.DeletedAt != nil {
+		deletedAt = subscription.DeletedAt.Format(time.RFC3339)
+	}
+
+	return []string{
+		strconv.Itoa(subscription.ID),
+		subscription.UserID.String(),
+		subscription.ServiceName,
+		strconv.Itoa(subscription.Price),
+		subscription.Currency,
+		subscription.StartDate.String(),
+		endDate,
+		subscription.BillingPeriod,
+		strconv.Itoa(subscription.BillingInterval),
+		strconv.Itoa(subscription.Version),
+		subscription.CreatedAt.Format(time.RFC3339),
+		subscription.UpdatedAt.Format(time.RFC3339),
+		deletedAt,
+	}
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer) (*csvExportWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{writer: writer}, nil
+}
+
+func (e *csvExportWriter) write(subscription models.Subscription) error {
+	return e.writer.Write(exportRecord(subscription))
+}
+
+func (e *csvExportWriter) close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlExportWriter) write(subscription models.Subscription) error {
+	return e.encoder.Encode(subscription)
+}
+
+func (e *jsonlExportWriter) close() error {
+	return nil
+}
+
+// xlsxExportWriter пишет книгу XLSX с одним листом. Служебные части пакета записываются сразу,
+// а лист — последним элементом архива, поэтому строки передаются клиенту по мере записи
+type xlsxExportWriter struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	row     int
+}
+
+// xlsxParts — служебные части пакета Office Open XML, одинаковые для любой выгрузки
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Subscriptions" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxNumericColumns — столбцы, которые записываются в лист числами, а не строками
+var xlsxNumericColumns = map[string]bool{"id": true, "price": true, "billing_interval": true, "version": true}
+
+func newXLSXExportWriter(w io.Writer) (*xlsxExportWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		writer, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(writer, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	header := xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	if _, err := io.WriteString(sheet, header); err != nil {
+		return nil, err
+	}
+
+	e := &xlsxExportWriter{archive: archive, sheet: sheet}
+	if err := e.writeRow(exportColumns, false); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *xlsxExportWriter) write(subscription models.Subscription) error {
+	return e.writeRow(exportRecord(subscription), true)
+}
+
+// writeRow записывает строку листа; при typed числовые столбцы записываются числами, пустые ячейки пропускаются
+func (e *xlsxExportWriter) writeRow(values []string, typed bool) error {
+	e.row++
+	if _, err := io.WriteString(e.sheet, `<row r="`+strconv.Itoa(e.row)+`">`); err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		ref := xlsxColumnName(i) + strconv.Itoa(e.row)
+		if typed && xlsxNumericColumns[exportColumns[i]] {
+			if _, err := io.WriteString(e.sheet, `<c r="`+ref+`"><v>`+value+`</v></c>`); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := io.WriteString(e.sheet, `<c r="`+ref+`" t="inlineStr"><is><t>`); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(e.sheet, []byte(value)); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(e.sheet, `</t></is></c>`); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(e.sheet, `</row>`)
+	return err
+}
+
+func (e *xlsxExportWriter) close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.archive.Close()
+}
+
+// xlsxColumnName переводит номер столбца, начиная с 0, в буквенное обозначение (A, B, ..., AA)
+func xlsxColumnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
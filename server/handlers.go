@@ -3,40 +3,123 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"subscription-aggregator-api/manager"
 	"subscription-aggregator-api/models"
-	"subscription-aggregator-api/storage"
 
 	"github.com/go-chi/chi"
 )
 
 const (
-	ErrSubscriptionNotFound  = "Subscription not found"
-	ErrSubscriptionsNotFound = "Subscriptions not found"
-	ErrInternalServerError   = "Internal Server Error"
+	ErrSubscriptionNotFound   = "Subscription not found"
+	ErrSubscriptionsNotFound  = "Subscriptions not found"
+	ErrInternalServerError    = "Internal Server Error"
+	ErrGatewayTimeout         = "Request timed out"
+	ErrPreconditionFailed     = "Subscription was modified, fetch the current version and retry"
+	ErrUnsupportedPatchType   = "Content-Type must be " + MergePatchContentType
+	ErrSubscriptionNotDeleted = "Subscription is not deleted"
+	ErrEventsNotFound         = "Subscription events not found"
+	ErrAPIKeyNotFound         = "API key not found"
+	ErrUnsupportedImportType  = "Content-Type must be " + CSVContentType + " or " + JSONLinesContentType
+
+	MergePatchContentType = "application/merge-patch+json"
+	CSVContentType        = "text/csv"
+	JSONLinesContentType  = "application/x-ndjson"
 
-	StatusCreated = "created"
 	StatusUpdated = "updated"
 )
 
-// ErrorResponse описывает тело ошибки
-// swagger:model ErrorResponse
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
 // Response описывает ответ с результатом операции
 // swagger:model Response
 type Response struct {
 	Status string `json:"status"`
 }
 
+// ListResponse описывает страницу списка подписок
+// swagger:model ListResponse
+type ListResponse struct {
+	Items      []models.Subscription `json:"items"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
 // TotalSumResponse описывает ответ с суммой подписок
 // swagger:model TotalSumResponse
 type TotalSumResponse struct {
-	TotalSum int `json:"total_sum"`
+	TotalSum int              `json:"total_sum"`
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Basis    string           `json:"basis" enums:"accrual,cash_flow"`
+	Currency string           `json:"currency" example:"RUB"`
+	Items    []models.SumItem `json:"items"`
+}
+
+// BatchRequest описывает пакет операций с подписками
+// swagger:model BatchRequest
+type BatchRequest struct {
+	Mode       string                  `json:"mode,omitempty" enums:"atomic,best_effort" example:"atomic"`
+	Operations []models.BatchOperation `json:"operations"`
+}
+
+// BatchItemResult описывает результат одной операции пакета; status — HTTP-статус, который вернул бы одиночный запрос
+// swagger:model BatchItemResult
+type BatchItemResult struct {
+	Index        int                  `json:"index"`
+	Op           string               `json:"op"`
+	Status       int                  `json:"status" example:"201"`
+	ID           int                  `json:"id,omitempty"`
+	Subscription *models.Subscription `json:"subscription,omitempty"`
+	Code         string               `json:"code,omitempty" example:"validation_failed"`
+	Error        string               `json:"error,omitempty"`
+	Errors       []ProblemField       `json:"errors,omitempty"`
+	// ConflictingID — подписка, с которой конфликтует операция, для ошибки subscription_conflict
+	ConflictingID int `json:"conflicting_id,omitempty"`
+}
+
+// BatchResponse описывает результаты пакета в порядке операций запроса
+// swagger:model BatchResponse
+type BatchResponse struct {
+	Mode    string            `json:"mode" enums:"atomic,best_effort"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// ImportResponse описывает результат импорта подписок
+// swagger:model ImportResponse
+type ImportResponse struct {
+	DryRun   bool                    `json:"dry_run"`
+	Rows     int                     `json:"rows"`
+	Valid    int                     `json:"valid"`
+	Imported int                     `json:"imported"`
+	Failed   int                     `json:"failed"`
+	Errors   []models.ImportRowError `json:"errors"`
+}
+
+// ExchangeRatesResponse описывает список курсов валют
+// swagger:model ExchangeRatesResponse
+type ExchangeRatesResponse struct {
+	Items []models.ExchangeRate `json:"items"`
+}
+
+// PricesResponse описывает историю цен подписки
+// swagger:model PricesResponse
+type PricesResponse struct {
+	Items []models.PriceChange `json:"items"`
+}
+
+// HistoryResponse описывает журнал изменений одной подписки
+// swagger:model HistoryResponse
+type HistoryResponse struct {
+	Items []models.SubscriptionEvent `json:"items"`
+}
+
+// AuditResponse описывает страницу журнала изменений
+// swagger:model AuditResponse
+type AuditResponse struct {
+	Items      []models.SubscriptionEvent `json:"items"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
 }
 
 func writeJSON[T any](w http.ResponseWriter, status int, data T) {
@@ -47,55 +130,76 @@ func writeJSON[T any](w http.ResponseWriter, status int, data T) {
 	}
 }
 
-func writeErrorJSON(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, ErrorResponse{Error: message})
-}
-
 // @Summary      Создать подписку
-// @Description  Создаёт новую подписку
+// @Description  Создаёт новую подписку и возвращает её вместе с присвоенным ID
 // @Tags         subscriptions
 // @Accept       json
 // @Produce      json
-// @Param        subscription  body      models.Subscription  true  "Подписка"
-// @Success      201           {object}  ResultResponse
-// @Failure      400           {object}  ErrorResponse
-// @Failure      405           {object}  ErrorResponse
-// @Failure      500           {object}  ErrorResponse
+// @Param        subscription     body      models.Subscription  true   "Подписка"
+// @Param        Idempotency-Key  header    string               false  "Ключ для безопасного повтора запроса"
+// @Success      201              {object}  models.Subscription
+// @Header       201              {string}  Location  "/subscriptions/{id}"
+// @Failure      400              {object}  Problem
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      405              {object}  Problem
+// @Failure      409              {object}  Problem
+// @Failure      413              {object}  Problem
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions [post]
 func (s *Server) Create(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var subscription models.Subscription
 	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
-		slog.Error("Failed to decode Subscription from JSON", "error", err)
-		writeErrorJSON(w, http.StatusBadRequest, err.Error())
+		s.handleDecodeError(w, r, "Subscription", err)
 		return
 	}
 
-	if err := s.manager.CreateSubscription(subscription); err != nil {
-		s.handleSubscriptionError(w, err)
+	created, err := s.manager.CreateSubscription(r.Context(), subscription)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
 		return
 	}
 
-	slog.Info("Subscription created successfully", "service_name", subscription.ServiceName, "user_id", subscription.UserID)
-	writeJSON(w, http.StatusCreated, Response{Status: StatusCreated})
+	slog.Info("Subscription created successfully", "id", created.ID, "service_name", created.ServiceName, "user_id", created.UserID)
+	w.Header().Set("Location", fmt.Sprintf("/subscriptions/%d", created.ID))
+	w.Header().Set("ETag", etag(created.Version))
+	writeJSON(w, http.StatusCreated, created)
 }
 
 // @Summary      Получить информацию о подписке
-// @Description  Возвращает подписку по ID
+// @Description  Возвращает подписку по ID вместе с ETag её текущей версии
 // @Tags         subscriptions
 // @Produce      json
-// @Param        id   path      string  true  "ID подписки"
+// @Param        id             path      string  true   "ID подписки"
+// @Param        If-None-Match  header    string  false  "ETag закэшированной версии"
 // @Success      200  {object}  models.Subscription
-// @Failure      404  {object}  ErrorResponse
-// @Failure      500  {object}  ErrorResponse
+// @Header       200  {string}  ETag  "Версия подписки"
+// @Success      304  "Not Modified"
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions/{id} [get]
 func (s *Server) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	subscription, err := s.manager.GetSubscription(id)
+	subscription, err := s.manager.GetSubscription(r.Context(), id)
 	if err != nil {
-		s.handleSubscriptionError(w, err)
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(subscription.Version))
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, subscription.Version) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -104,71 +208,284 @@ func (s *Server) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      Получить список подписок
-// @Description  Возвращает список всех подписок
+// @Description  Возвращает страницу списка подписок с фильтрацией, сортировкой и keyset-пагинацией
 // @Tags         subscriptions
 // @Produce      json
-// @Success      200  {array}   models.Subscription
-// @Failure      404  {object}  ErrorResponse
-// @Failure      500  {object}  ErrorResponse
+// @Param        user_id              query     string  false  "ID пользователя"
+// @Param        service_name         query     string  false  "Название сервиса (точное совпадение)"
+// @Param        service_name_prefix  query     string  false  "Префикс названия сервиса"
+// @Param        price_min            query     int     false  "Минимальная цена"
+// @Param        price_max            query     int     false  "Максимальная цена"
+// @Param        active_in            query     string  false  "Месяц, в котором подписка активна (MM-YYYY)"
+// @Param        sort                 query     string  false  "Поле сортировки"  Enums(id, price, start_date, service_name)
+// @Param        order                query     string  false  "Направление сортировки"  Enums(asc, desc)
+// @Param        limit                query     int     false  "Размер страницы (1-500, по умолчанию 50)"
+// @Param        include_deleted      query     bool    false  "Включить удалённые подписки"
+// @Param        cursor               query     string  false  "Курсор следующей страницы"
+// @Success      200                  {object}  ListResponse
+// @Failure      400                  {object}  Problem
+// @Failure      401                  {object}  Problem
+// @Failure      403                  {object}  Problem
+// @Failure      404                  {object}  Problem
+// @Failure      429                  {object}  Problem
+// @Failure      500                  {object}  Problem
+// @Failure      504                  {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions [get]
 func (s *Server) GetList(w http.ResponseWriter, r *http.Request) {
-	subscriptions, err := s.manager.GetAllSubscriptions()
+	query := r.URL.Query()
+	params := manager.ListParams{
+		UserID:            query.Get("user_id"),
+		ServiceName:       query.Get("service_name"),
+		ServiceNamePrefix: query.Get("service_name_prefix"),
+		PriceMin:          query.Get("price_min"),
+		PriceMax:          query.Get("price_max"),
+		ActiveIn:          query.Get("active_in"),
+		IncludeDeleted:    query.Get("include_deleted"),
+		Sort:              query.Get("sort"),
+		Order:             query.Get("order"),
+		Limit:             query.Get("limit"),
+		Cursor:            query.Get("cursor"),
+	}
+
+	page, err := s.manager.GetAllSubscriptions(r.Context(), params)
 	if err != nil {
-		s.handleSubscriptionError(w, err)
+		s.handleSubscriptionError(w, r, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, subscriptions)
-	slog.Info("Subscriptions list retrieved successfully", "count", len(subscriptions))
+	writeJSON(w, http.StatusOK, ListResponse{Items: page.Items, NextCursor: page.NextCursor})
+	slog.Info("Subscriptions list retrieved successfully", "count", len(page.Items), "has_more", page.NextCursor != "")
+}
+
+// @Summary      Выгрузить подписки
+// @Description  Передаёт подписки по мере чтения из базы, не загружая весь список в память. Фильтры и сортировка — как у списка подписок, без разбиения на страницы
+// @Tags         subscriptions
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Produce      application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param        format               query     string  false  "Формат файла (по умолчанию csv)"  Enums(csv, jsonl, xlsx)
+// @Param        user_id              query     string  false  "ID пользователя"
+// @Param        service_name         query     string  false  "Название сервиса (точное совпадение)"
+// @Param        service_name_prefix  query     string  false  "Префикс названия сервиса"
+// @Param        price_min            query     int     false  "Минимальная цена"
+// @Param        price_max            query     int     false  "Максимальная цена"
+// @Param        active_in            query     string  false  "Месяц, в котором подписка активна (MM-YYYY)"
+// @Param        sort                 query     string  false  "Поле сортировки"  Enums(id, price, start_date, service_name)
+// @Param        order                query     string  false  "Направление сортировки"  Enums(asc, desc)
+// @Param        include_deleted      query     bool    false  "Включить удалённые подписки"
+// @Success      200                  {file}    file
+// @Failure      400                  {object}  Problem
+// @Failure      401                  {object}  Problem
+// @Failure      403                  {object}  Problem
+// @Failure      429                  {object}  Problem
+// @Failure      500                  {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/export [get]
+func (s *Server) Export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	params := manager.ListParams{
+		UserID:            query.Get("user_id"),
+		ServiceName:       query.Get("service_name"),
+		ServiceNamePrefix: query.Get("service_name_prefix"),
+		PriceMin:          query.Get("price_min"),
+		PriceMax:          query.Get("price_max"),
+		ActiveIn:          query.Get("active_in"),
+		IncludeDeleted:    query.Get("include_deleted"),
+		Sort:              query.Get("sort"),
+		Order:             query.Get("order"),
+	}
+
+	subscriptions, err := s.manager.ExportSubscriptions(r.Context(), format, params)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+	if format == "" {
+		format = models.ExportFormatCSV
+	}
+
+	// ответ начинается с первой прочитанной подписки, чтобы ошибка запроса ещё могла вернуться как JSON
+	var writer exportWriter
+	start := func() error {
+		w.Header().Set("Content-Type", exportContentTypes[format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subscriptions.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		writer, err = newExportWriter(format, w)
+		return err
+	}
+
+	count := 0
+	for subscription, err := range subscriptions {
+		if err != nil {
+			if writer == nil {
+				s.handleSubscriptionError(w, r, err)
+				return
+			}
+			slog.Error("Export interrupted", "format", format, "count", count, "error", err)
+			return
+		}
+		if writer == nil {
+			if err := start(); err != nil {
+				slog.Error("Failed to start export", "format", format, "error", err)
+				return
+			}
+		}
+		if err := writer.write(subscription); err != nil {
+			slog.Error("Failed to write export", "format", format, "count", count, "error", err)
+			return
+		}
+		count++
+	}
+
+	if writer == nil {
+		if err := start(); err != nil {
+			slog.Error("Failed to start export", "format", format, "error", err)
+			return
+		}
+	}
+	if err := writer.close(); err != nil {
+		slog.Error("Failed to finish export", "format", format, "count", count, "error", err)
+		return
+	}
+	slog.Info("Subscriptions exported successfully", "format", format, "count", count)
 }
 
 // @Summary      Обновить информацию о подписке
-// @Description  Обновляет подписку по ID
+// @Description  Обновляет подписку по ID. С заголовком If-Match изменение применяется только к указанной версии.
+// @Description  Новая цена действует с месяца price_effective_from, а без него — с текущего месяца; прежние цены сохраняются.
+// @Description  С reset_price_history=true новая цена заменяет всю историю цен и действует с начала подписки
 // @Tags         subscriptions
 // @Accept       json
 // @Produce      json
-// @Param        id   path      string  true  "ID подписки"
-// @Param        subscription  body      models.Subscription  true  "Обновлённая подписка"
-// @Success      200           {object}  ResultResponse
-// @Failure      400           {object}  ErrorResponse
-// @Failure      404           {object}  ErrorResponse
-// @Failure      500           {object}  ErrorResponse
+// @Param        id                    path      string               true   "ID подписки"
+// @Param        price_effective_from  query     string               false  "Месяц, с которого действует новая цена (MM-YYYY)"
+// @Param        reset_price_history   query     bool                 false  "Заменить всю историю цен новой ценой"
+// @Param        If-Match              header    string               false  "ETag ожидаемой версии"
+// @Param        subscription          body      models.Subscription  true   "Обновлённая подписка"
+// @Success      200           {object}  Response
+// @Header       200           {string}  ETag  "Новая версия подписки"
+// @Failure      400           {object}  Problem
+// @Failure      401           {object}  Problem
+// @Failure      403           {object}  Problem
+// @Failure      404           {object}  Problem
+// @Failure      409           {object}  Problem
+// @Failure      412           {object}  Problem
+// @Failure      428           {object}  Problem
+// @Failure      429           {object}  Problem
+// @Failure      500           {object}  Problem
+// @Failure      504           {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions/{id} [put]
 func (s *Server) Update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	defer r.Body.Close()
 
+	expectedVersion, ok := s.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
 	var subscription models.Subscription
 	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
-		slog.Error("Failed to decode Subscription from JSON", "error", err)
-		writeErrorJSON(w, http.StatusBadRequest, err.Error())
+		s.handleDecodeError(w, r, "Subscription", err)
 		return
 	}
 
-	if err := s.manager.UpdateSubscription(id, subscription); err != nil {
-		s.handleSubscriptionError(w, err)
+	query := r.URL.Query()
+	params := manager.UpdateParams{
+		PriceEffectiveFrom: query.Get("price_effective_from"),
+		ResetPriceHistory:  query.Get("reset_price_history"),
+	}
+	updated, err := s.manager.UpdateSubscription(r.Context(), id, subscription, expectedVersion, params)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
 		return
 	}
 
-	slog.Info("Subscription updated successfully", "id", id)
+	slog.Info("Subscription updated successfully", "id", id, "version", updated.Version)
+	w.Header().Set("ETag", etag(updated.Version))
 	writeJSON(w, http.StatusOK, Response{Status: StatusUpdated})
 }
 
+// @Summary      Частично обновить подписку
+// @Description  Применяет к подписке JSON Merge Patch (RFC 7396): изменяются только переданные поля, null удаляет необязательное поле
+// @Tags         subscriptions
+// @Accept       application/merge-patch+json
+// @Produce      json
+// @Param        id        path      string  true   "ID подписки"
+// @Param        If-Match  header    string  false  "ETag ожидаемой версии"
+// @Param        patch     body      object  true   "Изменяемые поля подписки"
+// @Success      200       {object}  models.Subscription
+// @Header       200       {string}  ETag  "Новая версия подписки"
+// @Failure      400       {object}  Problem
+// @Failure      401    {object}  Problem
+// @Failure      403    {object}  Problem
+// @Failure      404       {object}  Problem
+// @Failure      409       {object}  Problem
+// @Failure      412       {object}  Problem
+// @Failure      415       {object}  Problem
+// @Failure      428       {object}  Problem
+// @Failure      429    {object}  Problem
+// @Failure      500    {object}  Problem
+// @Failure      504    {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/{id} [patch]
+func (s *Server) Patch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	defer r.Body.Close()
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != MergePatchContentType && mediaType != "application/json") {
+		writeProblem(w, r, problemUnsupportedMediaType, ErrUnsupportedPatchType, nil)
+		return
+	}
+
+	expectedVersion, ok := s.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("Failed to read merge patch body", "error", err)
+		writeProblem(w, r, problemInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	subscription, err := s.manager.PatchSubscription(r.Context(), id, patch, expectedVersion)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	slog.Info("Subscription patched successfully", "id", id, "version", subscription.Version)
+	w.Header().Set("ETag", etag(subscription.Version))
+	writeJSON(w, http.StatusOK, subscription)
+}
+
 // @Summary      Удалить подписку
-// @Description  Удаляет подписку по ID
+// @Description  Помечает подписку удалённой; до окончательной очистки её можно восстановить
 // @Tags         subscriptions
 // @Produce      json
 // @Param        id   path      string  true  "ID подписки"
 // @Success      204  "No Content"
-// @Failure      404  {object}  ErrorResponse
-// @Failure      500  {object}  ErrorResponse
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions/{id} [delete]
 func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	if err := s.manager.DeleteSubscription(id); err != nil {
-		s.handleSubscriptionError(w, err)
+	if err := s.manager.DeleteSubscription(r.Context(), id); err != nil {
+		s.handleSubscriptionError(w, r, err)
 		return
 	}
 
@@ -176,39 +493,476 @@ func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// @Summary      Получить сумму всех подписок
-// @Description  Возвращает сумму цен всех подписок
+// @Summary      Восстановить подписку
+// @Description  Снимает с подписки пометку удаления, если она ещё не очищена
 // @Tags         subscriptions
 // @Produce      json
-// @Success      200  {object}  TotalSumResponse
-// @Failure      500  {object}  ErrorResponse
+// @Param        id               path      string  true   "ID подписки"
+// @Param        Idempotency-Key  header    string  false  "Ключ для безопасного повтора запроса"
+// @Success      200              {object}  models.Subscription
+// @Header       200              {string}  ETag  "Версия подписки"
+// @Failure      400              {object}  Problem
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      404              {object}  Problem
+// @Failure      409              {object}  Problem
+// @Failure      413              {object}  Problem
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/{id}/restore [post]
+func (s *Server) Restore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	restored, err := s.manager.RestoreSubscription(r.Context(), id)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(restored.Version))
+	writeJSON(w, http.StatusOK, restored)
+	slog.Info("Subscription restored successfully", "id", id, "version", restored.Version)
+}
+
+// @Summary      Изменить цену подписки с указанного месяца
+// @Description  Записывает новую цену, действующую с effective_from; цены предыдущих месяцев и рассчитанные по ним суммы не меняются
+// @Tags         subscriptions
+// @Accept       json
+// @Produce      json
+// @Param        id               path      string              true   "ID подписки"
+// @Param        If-Match         header    string              false  "ETag ожидаемой версии"
+// @Param        change           body      models.PriceChange  true   "Новая цена и месяц начала её действия"
+// @Param        Idempotency-Key  header    string              false  "Ключ для безопасного повтора запроса"
+// @Success      200              {object}  models.Subscription
+// @Header       200              {string}  ETag  "Новая версия подписки"
+// @Failure      400              {object}  Problem
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      404              {object}  Problem
+// @Failure      409              {object}  Problem
+// @Failure      412              {object}  Problem
+// @Failure      413              {object}  Problem
+// @Failure      428              {object}  Problem
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/{id}/price-changes [post]
+func (s *Server) ChangePrice(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	defer r.Body.Close()
+
+	expectedVersion, ok := s.expectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var change models.PriceChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		s.handleDecodeError(w, r, "PriceChange", err)
+		return
+	}
+
+	updated, err := s.manager.ChangeSubscriptionPrice(r.Context(), id, change, expectedVersion)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	slog.Info("Subscription price changed successfully", "id", id, "price", change.Price, "effective_from", change.EffectiveFrom, "version", updated.Version)
+	w.Header().Set("ETag", etag(updated.Version))
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// @Summary      Получить историю цен подписки
+// @Description  Возвращает цены подписки по возрастанию месяца начала их действия
+// @Tags         subscriptions
+// @Produce      json
+// @Param        id   path      string  true  "ID подписки"
+// @Success      200  {object}  PricesResponse
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/{id}/price-changes [get]
+func (s *Server) GetPrices(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	prices, err := s.manager.GetSubscriptionPrices(r.Context(), id)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PricesResponse{Items: prices})
+	slog.Info("Subscription prices retrieved successfully", "id", id, "count", len(prices))
+}
+
+// @Summary      Получить историю изменений подписки
+// @Description  Возвращает журнал изменений подписки в хронологическом порядке: кто, когда и что изменил
+// @Tags         audit
+// @Produce      json
+// @Param        id   path      string  true  "ID подписки"
+// @Success      200  {object}  HistoryResponse
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Failure      504  {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/{id}/history [get]
+func (s *Server) GetHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	events, err := s.manager.GetSubscriptionHistory(r.Context(), id)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HistoryResponse{Items: events})
+	slog.Info("Subscription history retrieved successfully", "id", id, "count", len(events))
+}
+
+// @Summary      Получить журнал изменений
+// @Description  Возвращает журнал изменений всех подписок от новых событий к старым
+// @Tags         audit
+// @Produce      json
+// @Param        subscription_id  query     int     false  "ID подписки"
+// @Param        actor            query     string  false  "Инициатор изменения"
+// @Param        action           query     string  false  "Тип изменения"  Enums(create, update, delete, restore, purge)
+// @Param        since            query     string  false  "Начало интервала (RFC 3339, включительно)"
+// @Param        until            query     string  false  "Конец интервала (RFC 3339, не включительно)"
+// @Param        limit            query     int     false  "Размер страницы (1-500, по умолчанию 50)"
+// @Param        cursor           query     string  false  "Курсор следующей страницы"
+// @Success      200              {object}  AuditResponse
+// @Failure      400              {object}  Problem
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      404              {object}  Problem
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
+// @Router       /audit [get]
+func (s *Server) GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := manager.AuditParams{
+		SubscriptionID: query.Get("subscription_id"),
+		Actor:          query.Get("actor"),
+		Action:         query.Get("action"),
+		Since:          query.Get("since"),
+		Until:          query.Get("until"),
+		Limit:          query.Get("limit"),
+		Cursor:         query.Get("cursor"),
+	}
+
+	page, err := s.manager.GetAuditEvents(r.Context(), params)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, AuditResponse{Items: page.Items, NextCursor: page.NextCursor})
+	slog.Info("Audit events retrieved successfully", "count", len(page.Items), "has_more", page.NextCursor != "")
+}
+
+// @Summary      Получить суммарную стоимость подписок за период
+// @Description  Возвращает стоимость подписок за выбранный период с разбивкой по подпискам с учётом цикла оплаты (billing_period, billing_interval).
+// @Description  Каждый месяц считается по цене, действовавшей в нём; при смене цены подписка даёт несколько элементов
+// @Tags         subscriptions
+// @Produce      json
+// @Param        from          query     string  true   "Начало периода (MM-YYYY)"
+// @Param        to            query     string  true   "Конец периода (MM-YYYY)"
+// @Param        user_id       query     string  false  "ID пользователя"
+// @Param        service_name  query     string  false  "Название сервиса"
+// @Param        basis         query     string  false  "Метод расчёта: accrual — цена цикла оплаты распределяется по месяцам, cash_flow — относится к месяцу списания"  Enums(accrual, cash_flow)
+// @Param        currency      query     string  false  "Валюта отчёта (ISO 4217, по умолчанию RUB); стоимость пересчитывается по курсу каждого месяца"
+// @Success      200           {object}  TotalSumResponse
+// @Failure      400           {object}  Problem
+// @Failure      401           {object}  Problem
+// @Failure      403           {object}  Problem
+// @Failure      422           {object}  Problem
+// @Failure      429           {object}  Problem
+// @Failure      500           {object}  Problem
+// @Failure      504           {object}  Problem
+// @Security     BearerAuth
 // @Router       /subscriptions/sum [get]
 func (s *Server) GetSum(w http.ResponseWriter, r *http.Request) {
-	totalSum, err := s.manager.GetAllSubscriptionsSum()
+	query := r.URL.Query()
+	params := manager.SumParams{
+		From:        query.Get("from"),
+		To:          query.Get("to"),
+		UserID:      query.Get("user_id"),
+		ServiceName: query.Get("service_name"),
+		Basis:       query.Get("basis"),
+		Currency:    query.Get("currency"),
+	}
+
+	report, err := s.manager.GetSubscriptionsSum(r.Context(), params)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TotalSumResponse{
+		TotalSum: report.TotalSum,
+		From:     report.From.String(),
+		To:       report.To.String(),
+		Basis:    report.Basis,
+		Currency: report.Currency,
+		Items:    report.Items,
+	})
+	slog.Info("Total subscription cost retrieved successfully", "from", report.From.String(), "to", report.To.String(), "currency", report.Currency, "total_sum", report.TotalSum)
+}
+
+// @Summary      Выполнить пакет операций с подписками
+// @Description  Выполняет до 100 операций create, update (полная замена, как PUT) и delete за один запрос и возвращает результат каждой.
+// @Description  В режиме atomic (по умолчанию) операции выполняются в одной транзакции, и ошибка любой отменяет все: ответ получает статус этой ошибки,
+// @Description  остальные операции — 424. В режиме best_effort каждая операция применяется независимо; если хотя бы одна не удалась, ответ — 207
+// @Tags         subscriptions
+// @Accept       json
+// @Produce      json
+// @Param        batch            body      BatchRequest  true   "Пакет операций"
+// @Param        Idempotency-Key  header    string        false  "Ключ для безопасного повтора запроса"
+// @Success      200              {object}  BatchResponse
+// @Success      207              {object}  BatchResponse
+// @Failure      400              {object}  BatchResponse
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      404              {object}  BatchResponse
+// @Failure      409              {object}  Problem
+// @Failure      412              {object}  BatchResponse
+// @Failure      413              {object}  Problem
+// @Failure      428              {object}  BatchResponse
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions:batch [post]
+func (s *Server) Batch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.handleDecodeError(w, r, "BatchRequest", err)
+		return
+	}
+
+	results, err := s.manager.ExecuteBatch(r.Context(), request.Mode, request.Operations)
 	if err != nil {
-		s.handleSubscriptionError(w, err)
+		s.handleSubscriptionError(w, r, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, TotalSumResponse{TotalSum: totalSum})
-	slog.Info("Total subscription price sum retrieved successfully", "total_sum", totalSum)
+	response := BatchResponse{Mode: request.Mode, Results: make([]BatchItemResult, len(results))}
+	if response.Mode == "" {
+		response.Mode = models.BatchModeAtomic
+	}
+	status, failed := http.StatusOK, 0
+	for i, result := range results {
+		item := batchItemResult(i, result)
+		if result.Err != nil {
+			failed++
+			if status == http.StatusOK && !errors.Is(result.Err, models.ErrBatchRolledBack) {
+				status = item.Status
+			}
+		}
+		response.Results[i] = item
+	}
+	if failed > 0 && response.Mode == models.BatchModeBestEffort {
+		status = http.StatusMultiStatus
+	}
+
+	writeJSON(w, status, response)
+	slog.Info("Batch executed", "mode", response.Mode, "operations", len(results), "failed", failed)
 }
 
-func (s *Server) handleSubscriptionError(w http.ResponseWriter, err error) {
-	var badReqErr *manager.BadRequestError
+// batchItemResult возвращает результат операции пакета с HTTP-статусом и описанием ошибки
+func batchItemResult(index int, result models.BatchResult) BatchItemResult {
+	item := BatchItemResult{Index: index, Op: result.Op, ID: result.ID, Subscription: result.Subscription}
 
 	switch {
-	case errors.As(err, &badReqErr):
-		slog.Error(err.Error(), "error", err)
-		writeErrorJSON(w, http.StatusBadRequest, err.Error())
-	case errors.Is(err, storage.ErrSubscriptionNotFound):
-		slog.Error(err.Error())
-		writeErrorJSON(w, http.StatusNotFound, ErrSubscriptionNotFound)
-	case errors.Is(err, storage.ErrNoSubscriptions):
-		slog.Error(err.Error())
-		writeErrorJSON(w, http.StatusNotFound, ErrSubscriptionsNotFound)
+	case result.Err == nil && result.Op == models.BatchOpCreate:
+		item.Status = http.StatusCreated
+	case result.Err == nil && result.Op == models.BatchOpDelete:
+		item.Status = http.StatusNoContent
+	case result.Err == nil:
+		item.Status = http.StatusOK
 	default:
-		slog.Error("internal server error", "error", err)
-		writeErrorJSON(w, http.StatusInternalServerError, ErrInternalServerError)
+		kind, detail, fields := classifyError(result.Err)
+		if kind.status >= http.StatusInternalServerError {
+			slog.Error("Batch operation failed", "op", result.Op, "id", result.ID, "error", result.Err)
+		}
+		item.Status, item.Code, item.Error, item.Errors = kind.status, kind.code, detail, fields
+		item.ConflictingID = conflictingID(result.Err)
+	}
+	return item
+}
+
+// importFormats сопоставляет Content-Type запроса импорта с форматом файла
+var importFormats = map[string]string{
+	CSVContentType:            models.ImportFormatCSV,
+	"application/csv":         models.ImportFormatCSV,
+	JSONLinesContentType:      models.ImportFormatJSONL,
+	"application/jsonl":       models.ImportFormatJSONL,
+	"application/x-jsonlines": models.ImportFormatJSONL,
+}
+
+// @Summary      Импортировать подписки из CSV или JSON Lines
+// @Description  Читает файл построчно и проверяет каждую подписку так же, как при создании; корректные строки сохраняются пакетами, строки с ошибками пропускаются.
+// @Description  Подписка, которая нарушает правило уникальности вместе с действующей подпиской или с предыдущей строкой файла, считается строкой с ошибкой: в отчёте указываются conflicting_id или conflicting_line.
+// @Description  Формат определяется по Content-Type: text/csv (первая строка — заголовок с названиями полей подписки) или application/x-ndjson (одна подписка в формате JSON на строку).
+// @Description  В отчёт попадают первые 100 ошибок с номерами строк; при dry_run=true подписки только проверяются, в том числе на уникальность.
+// @Description  Если импорт прерван, ответ об ошибке содержит в поле import отчёт о строках, обработанных до неё; сохранённые пакеты не откатываются
+// @Tags         subscriptions
+// @Accept       text/csv
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Param        dry_run  query     bool    false  "Только проверить файл, не сохраняя подписки"
+// @Param        file     body      string  true   "Содержимое файла"
+// @Success      200      {object}  ImportResponse
+// @Failure      400      {object}  Problem
+// @Failure      401      {object}  Problem
+// @Failure      403      {object}  Problem
+// @Failure      409      {object}  Problem
+// @Failure      415      {object}  Problem
+// @Failure      429      {object}  Problem
+// @Failure      500      {object}  Problem
+// @Failure      504      {object}  Problem
+// @Security     BearerAuth
+// @Router       /subscriptions/import [post]
+func (s *Server) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := importFormats[mediaType]
+	if err != nil || !ok {
+		slog.Warn("Unsupported import Content-Type", "content_type", r.Header.Get("Content-Type"))
+		writeProblem(w, r, problemUnsupportedMediaType, ErrUnsupportedImportType, nil)
+		return
+	}
+
+	params := manager.ImportParams{
+		Format: format,
+		DryRun: r.URL.Query().Get("dry_run"),
+	}
+
+	report, err := s.manager.ImportSubscriptions(r.Context(), params, r.Body)
+	response := newImportResponse(report)
+	if err != nil {
+		slog.Error("Import interrupted", "rows", report.Rows, "imported", report.Imported, "error", err)
+		if problem, ok := s.errorProblem(r, err); ok {
+			problem.Import = &response
+			sendProblem(w, problem)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+	slog.Info("Subscriptions imported", "format", format, "dry_run", report.DryRun, "rows", report.Rows, "imported", report.Imported, "failed", report.Failed)
+}
+
+func newImportResponse(report models.ImportReport) ImportResponse {
+	errs := report.Errors
+	if errs == nil {
+		errs = []models.ImportRowError{}
+	}
+	return ImportResponse{
+		DryRun:   report.DryRun,
+		Rows:     report.Rows,
+		Valid:    report.Valid,
+		Imported: report.Imported,
+		Failed:   report.Failed,
+		Errors:   errs,
+	}
+}
+
+// @Summary      Добавить курс валюты
+// @Description  Сохраняет курс валюты к RUB, действующий с effective_from до следующего курса; курс на тот же месяц заменяется
+// @Tags         exchange-rates
+// @Accept       json
+// @Produce      json
+// @Param        rate             body      models.ExchangeRate  true   "Курс валюты"
+// @Param        Idempotency-Key  header    string               false  "Ключ для безопасного повтора запроса"
+// @Success      201              {object}  models.ExchangeRate
+// @Failure      400              {object}  Problem
+// @Failure      401              {object}  Problem
+// @Failure      403              {object}  Problem
+// @Failure      409              {object}  Problem
+// @Failure      413              {object}  Problem
+// @Failure      429              {object}  Problem
+// @Failure      500              {object}  Problem
+// @Failure      504              {object}  Problem
+// @Security     BearerAuth
+// @Router       /exchange-rates [post]
+func (s *Server) CreateExchangeRate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var rate models.ExchangeRate
+	if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		s.handleDecodeError(w, r, "ExchangeRate", err)
+		return
+	}
+
+	saved, err := s.manager.CreateExchangeRate(r.Context(), rate)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	slog.Info("Exchange rate saved successfully", "currency", saved.Currency, "rate", saved.Rate, "effective_from", saved.EffectiveFrom)
+	writeJSON(w, http.StatusCreated, saved)
+}
+
+// @Summary      Получить курсы валют
+// @Description  Возвращает курсы валют к RUB по валюте и возрастанию месяца начала действия
+// @Tags         exchange-rates
+// @Produce      json
+// @Param        currency  query     string  false  "Валюта (ISO 4217)"
+// @Success      200       {object}  ExchangeRatesResponse
+// @Failure      400       {object}  Problem
+// @Failure      401       {object}  Problem
+// @Failure      403       {object}  Problem
+// @Failure      429       {object}  Problem
+// @Failure      500       {object}  Problem
+// @Failure      504       {object}  Problem
+// @Security     BearerAuth
+// @Router       /exchange-rates [get]
+func (s *Server) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	currency := r.URL.Query().Get("currency")
+
+	rates, err := s.manager.GetExchangeRates(r.Context(), currency)
+	if err != nil {
+		s.handleSubscriptionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ExchangeRatesResponse{Items: rates})
+	slog.Info("Exchange rates retrieved successfully", "currency", currency, "count", len(rates))
+}
+
+// expectedVersion разбирает If-Match и при ошибке сам отвечает 412 или 428
+func (s *Server) expectedVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	version, err := parseIfMatch(r.Header.Get("If-Match"), s.cfg.RequireIfMatch)
+	switch {
+	case errors.Is(err, errIfMatchRequired):
+		slog.Warn("Conditional request required", "path", r.URL.Path)
+		writeProblem(w, r, problemPreconditionRequired, err.Error(), nil)
+		return 0, false
+	case err != nil:
+		slog.Warn("Invalid If-Match header", "path", r.URL.Path, "error", err)
+		writeProblem(w, r, problemInvalidPrecondition, err.Error(), nil)
+		return 0, false
 	}
+	return version, true
 }
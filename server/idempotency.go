@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	ErrRequestBodyUnreadable = "Failed to read request body"
+	ErrRequestBodyTooLarge   = "Request body with Idempotency-Key must not exceed 1 MiB"
+
+	// MaxIdempotentBodySize ограничивает тело запроса с Idempotency-Key: оно читается в память целиком, чтобы
+	// сравнить его с телом первого запроса
+	MaxIdempotentBodySize = 1 << 20
+)
+
+// storedHeaders — заголовки ответа, которые сохраняются вместе с ним и возвращаются при повторе
+var storedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// responseRecorder передаёт ответ клиенту и одновременно запоминает его
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotency выполняет POST-запрос с заголовком Idempotency-Key не больше одного раза: повтор с тем же ключом,
+// телом и If-Match получает сохранённый ответ, повтор с другим телом — 409 Conflict. Ответы 5xx не сохраняются,
+// чтобы после временного сбоя запрос можно было повторить. Подключается только к POST-маршрутам
+// (PUT, PATCH и DELETE идемпотентны сами по себе)
+func (s *Server) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			slog.Warn("Idempotent request body too large", "key", key, "path", r.URL.Path)
+			writeProblem(w, r, problemBodyTooLarge, ErrRequestBodyTooLarge, nil)
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to read request body", "error", err)
+			writeProblem(w, r, problemInvalidRequest, ErrRequestBodyUnreadable, nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := s.manager.BeginIdempotentRequest(r.Context(), manager.IdempotentRequest{
+			Key:     key,
+			Method:  r.Method,
+			Path:    r.URL.RequestURI(),
+			IfMatch: r.Header.Get("If-Match"),
+			Body:    body,
+			TTL:     s.cfg.IdempotencyTTL,
+		})
+		if err != nil {
+			s.handleSubscriptionError(w, r, err)
+			return
+		}
+		if stored != nil {
+			slog.Info("Idempotent request replayed", "key", key, "path", r.URL.Path, "status", stored.Status)
+			replayResponse(w, *stored)
+			return
+		}
+
+		// ключ освобождается и после отмены запроса клиентом, поэтому работа с ним не зависит от контекста запроса
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := s.manager.ReleaseIdempotentRequest(ctx, key); err != nil {
+				slog.Error("Failed to release idempotency key", "key", key, "error", err)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			return
+		}
+
+		response := models.StoredResponse{
+			Status:  recorder.status,
+			Headers: make(map[string]string, len(storedHeaders)),
+			Body:    recorder.body.Bytes(),
+		}
+		for _, name := range storedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+		if err := s.manager.CompleteIdempotentRequest(ctx, key, response); err != nil {
+			slog.Error("Failed to save idempotent response", "key", key, "error", err)
+			return
+		}
+		completed = true
+	})
+}
+
+func replayResponse(w http.ResponseWriter, response models.StoredResponse) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(IdempotentReplayedHeader, strconv.FormatBool(true))
+	w.WriteHeader(response.Status)
+	if _, err := w.Write(response.Body); err != nil {
+		slog.Error("Failed to write replayed response", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{IdempotencyTTL: time.Hour}, config.AuthConfig{})
+	body := subscriptionBody("Yandex Plus", 400)
+
+	first := s.do(t, http.MethodPost, "/subscriptions", body, IdempotencyKeyHeader, "key-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request status = %d, want %d, body %s", first.Code, http.StatusCreated, first.Body)
+	}
+	replayed := s.do(t, http.MethodPost, "/subscriptions", body, IdempotencyKeyHeader, "key-1")
+	if replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() {
+		t.Fatalf("replayed response = %d %s, want %d %s", replayed.Code, replayed.Body, first.Code, first.Body)
+	}
+	if replayed.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("replayed response has no %s header", IdempotentReplayedHeader)
+	}
+	if first.Header().Get("Location") == "" || replayed.Header().Get("Location") != first.Header().Get("Location") {
+		t.Fatalf("replayed Location = %q, want %q", replayed.Header().Get("Location"), first.Header().Get("Location"))
+	}
+
+	page, err := s.storage.GetList(t.Context(), models.ListFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("stored %d subscriptions, want 1", len(page.Items))
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{IdempotencyTTL: time.Hour}, config.AuthConfig{})
+
+	tests := []struct {
+		name    string
+		first   []string
+		second  []string
+		target  string
+		body    string
+		changed string
+	}{
+		{
+			name:    "different body",
+			target:  "/subscriptions",
+			first:   []string{IdempotencyKeyHeader, "key-body"},
+			second:  []string{IdempotencyKeyHeader, "key-body"},
+			body:    subscriptionBody("Yandex Plus", 400),
+			changed: subscriptionBody("Yandex Plus", 500),
+		},
+		{
+			name:    "different If-Match",
+			target:  "/subscriptions/1/price-changes",
+			first:   []string{IdempotencyKeyHeader, "key-if-match", "If-Match", `"1"`},
+			second:  []string{IdempotencyKeyHeader, "key-if-match", "If-Match", `"2"`},
+			body:    `{"price":500,"effective_from":"09-2025"}`,
+			changed: `{"price":500,"effective_from":"09-2025"}`,
+		},
+	}
+	if w := s.do(t, http.MethodPost, "/subscriptions", subscriptionBody("Sber Prime", 200)); w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body %s", w.Code, w.Body)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(t, http.MethodPost, tt.target, tt.body, tt.first...); w.Code >= http.StatusBadRequest {
+				t.Fatalf("first request status = %d, body %s", w.Code, w.Body)
+			}
+			problem := decodeProblem(t, s.do(t, http.MethodPost, tt.target, tt.changed, tt.second...), http.StatusConflict)
+			if problem.Code != problemIdempotencyReused.code {
+				t.Fatalf("problem code = %q, want %q", problem.Code, problemIdempotencyReused.code)
+			}
+		})
+	}
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{IdempotencyTTL: time.Hour}, config.AuthConfig{})
+	body := `{"service_name":"` + strings.Repeat("a", MaxIdempotentBodySize) + `"}`
+
+	problem := decodeProblem(t, s.do(t, http.MethodPost, "/subscriptions", body, IdempotencyKeyHeader, "key-large"), http.StatusRequestEntityTooLarge)
+	if problem.Code != problemBodyTooLarge.code {
+		t.Fatalf("problem code = %q, want %q", problem.Code, problemBodyTooLarge.code)
+	}
+
+	// без ключа тело не буферизуется и не ограничивается middleware
+	if w := s.do(t, http.MethodPost, "/subscriptions", body); w.Code == http.StatusRequestEntityTooLarge {
+		t.Fatalf("request without key status = %d, want the handler's response", w.Code)
+	}
+}
+
+func TestIdempotencyServerErrorNotStored(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{IdempotencyTTL: time.Hour}, config.AuthConfig{})
+
+	calls := 0
+	handler := s.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(`{}`))
+		r.Header.Set(IdempotencyKeyHeader, "key-retry")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w := send(); w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("retry status = %d, replayed %q, want a fresh %d", w.Code, w.Header().Get(IdempotentReplayedHeader), http.StatusCreated)
+	}
+	if w := send(); w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("second retry status = %d, replayed %q, want the stored %d", w.Code, w.Header().Get(IdempotentReplayedHeader), http.StatusCreated)
+	}
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyOnlyOnPost(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{IdempotencyTTL: time.Hour}, config.AuthConfig{})
+	if w := s.do(t, http.MethodPost, "/subscriptions", subscriptionBody("Yandex Plus", 400)); w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body %s", w.Code, w.Body)
+	}
+
+	for _, price := range []int{500, 600} {
+		w := s.do(t, http.MethodPut, "/subscriptions/1", subscriptionBody("Yandex Plus", price), IdempotencyKeyHeader, "key-put")
+		if w.Code != http.StatusOK || w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Fatalf("PUT with price %d status = %d, replayed %q, want a fresh 200", price, w.Code, w.Header().Get(IdempotentReplayedHeader))
+		}
+	}
+	got, err := s.storage.GetByID(t.Context(), 1)
+	if err != nil || got.Price != 600 {
+		t.Fatalf("GetByID() = %d, %v, want price 600", got.Price, err)
+	}
+}
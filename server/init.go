@@ -3,41 +3,76 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
+	"iter"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/manager"
 	"subscription-aggregator-api/models"
 	"syscall"
 
 	_ "subscription-aggregator-api/docs"
 
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 )
 
 type SubscriptionManager interface {
-	CreateSubscription(subscription models.Subscription) error
-	GetSubscription(id string) (models.Subscription, error)
-	GetAllSubscriptions() ([]models.Subscription, error)
-	UpdateSubscription(id string, updatedSubscription models.Subscription) error
-	DeleteSubscription(id string) error
-	GetAllSubscriptionsSum() (totalSum int, err error)
+	CreateSubscription(ctx context.Context, subscription models.Subscription) (models.Subscription, error)
+	GetSubscription(ctx context.Context, id string) (models.Subscription, error)
+	GetAllSubscriptions(ctx context.Context, params manager.ListParams) (models.SubscriptionPage, error)
+	UpdateSubscription(ctx context.Context, id string, updatedSubscription models.Subscription, expectedVersion int, params manager.UpdateParams) (models.Subscription, error)
+	ChangeSubscriptionPrice(ctx context.Context, id string, change models.PriceChange, expectedVersion int) (models.Subscription, error)
+	GetSubscriptionPrices(ctx context.Context, id string) ([]models.PriceChange, error)
+	PatchSubscription(ctx context.Context, id string, patch []byte, expectedVersion int) (models.Subscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	RestoreSubscription(ctx context.Context, id string) (models.Subscription, error)
+	GetSubscriptionHistory(ctx context.Context, id string) ([]models.SubscriptionEvent, error)
+	GetAuditEvents(ctx context.Context, params manager.AuditParams) (models.EventPage, error)
+	CreateExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error)
+	GetExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error)
+	GetSubscriptionsSum(ctx context.Context, params manager.SumParams) (models.SumReport, error)
+	ExecuteBatch(ctx context.Context, mode string, ops []models.BatchOperation) ([]models.BatchResult, error)
+	ExportSubscriptions(ctx context.Context, format string, params manager.ListParams) (iter.Seq2[models.Subscription, error], error)
+	ImportSubscriptions(ctx context.Context, params manager.ImportParams, body io.Reader) (models.ImportReport, error)
+	AuthenticateAPIKey(ctx context.Context, secret string) (models.Principal, error)
+	CreateAPIKey(ctx context.Context, name string, scopes []string) (models.APIKey, string, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) (models.APIKey, error)
+	BeginIdempotentRequest(ctx context.Context, request manager.IdempotentRequest) (*models.StoredResponse, error)
+	CompleteIdempotentRequest(ctx context.Context, key string, response models.StoredResponse) error
+	ReleaseIdempotentRequest(ctx context.Context, key string) error
 }
 
 type Server struct {
 	ctx        context.Context
+	cfg        config.ServerConfig
+	authCfg    config.AuthConfig
+	jwt        *JWTVerifier
+	rateLimits RateLimitStore
 	manager    SubscriptionManager
 	httpServer *http.Server
 }
 
-func Init(ctx context.Context, manager SubscriptionManager) *Server {
-	slog.Info("Server initialized")
+// Init создаёт сервер; rateLimits — общее хранилище лимитов запросов, nil — лимиты в памяти процесса
+func Init(ctx context.Context, manager SubscriptionManager, srvCfg config.ServerConfig, authCfg config.AuthConfig, rateLimits RateLimitStore) *Server {
+	if rateLimits == nil {
+		rateLimits = newMemoryRateLimitStore()
+	}
+
+	slog.Info("Server initialized", "auth_enabled", authCfg.Enabled, "rate_limit_read", srvCfg.RateLimitRead.String(),
+		"rate_limit_write", srvCfg.RateLimitWrite.String(), "rate_limit_admin", srvCfg.RateLimitAdmin.String())
 	return &Server{
-		ctx:     ctx,
-		manager: manager,
+		ctx:        ctx,
+		cfg:        srvCfg,
+		authCfg:    authCfg,
+		rateLimits: rateLimits,
+		manager:    manager,
 	}
 }
 
@@ -57,15 +92,50 @@ func Init(ctx context.Context, manager SubscriptionManager) *Server {
 // @BasePath /
 // @schemes http https
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Ключ доступа ("Bearer sa_...") или JWT ("Bearer eyJ...")
+
 func (s *Server) setupRouter() *chi.Mux {
 	router := chi.NewRouter()
-
-	router.Post("/subscriptions", s.Create)
-	router.Get("/subscriptions/{id}", s.Get)
-	router.Get("/subscriptions", s.GetList)
-	router.Get("/subscriptions/sum", s.GetSum)
-	router.Put("/subscriptions/{id}", s.Update)
-	router.Delete("/subscriptions/{id}", s.Delete)
+	router.Use(middleware.RequestID, auditContext)
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
+
+	router.Group(func(r chi.Router) {
+		r.Use(s.authenticate, s.rateLimit(models.ScopeRead, s.cfg.RateLimitRead), s.requireScope(models.ScopeRead))
+		r.Get("/subscriptions/{id}", s.Get)
+		r.Get("/subscriptions", s.GetList)
+		r.Get("/subscriptions/sum", s.GetSum)
+		r.Get("/subscriptions/export", s.Export)
+		r.Get("/subscriptions/{id}/price-changes", s.GetPrices)
+		r.Get("/subscriptions/{id}/history", s.GetHistory)
+		r.Get("/audit", s.GetAudit)
+		r.Get("/exchange-rates", s.GetExchangeRates)
+	})
+	router.Group(func(r chi.Router) {
+		r.Use(s.authenticate, s.rateLimit(models.ScopeWrite, s.cfg.RateLimitWrite), s.requireScope(models.ScopeWrite))
+		// импорт читает файл потоком, поэтому не буферизуется для Idempotency-Key
+		r.Post("/subscriptions/import", s.Import)
+		r.Put("/subscriptions/{id}", s.Update)
+		r.Patch("/subscriptions/{id}", s.Patch)
+		r.Delete("/subscriptions/{id}", s.Delete)
+		r.Group(func(r chi.Router) {
+			r.Use(s.idempotency)
+			r.Post("/subscriptions", s.Create)
+			r.Post("/subscriptions:batch", s.Batch)
+			r.Post("/subscriptions/{id}/restore", s.Restore)
+			r.Post("/subscriptions/{id}/price-changes", s.ChangePrice)
+			r.Post("/exchange-rates", s.CreateExchangeRate)
+		})
+	})
+	router.Group(func(r chi.Router) {
+		r.Use(s.authenticate, s.rateLimit(models.ScopeAdmin, s.cfg.RateLimitAdmin), s.requireScope(models.ScopeAdmin))
+		r.Post("/api-keys", s.CreateAPIKey)
+		r.Get("/api-keys", s.GetAPIKeys)
+		r.Delete("/api-keys/{id}", s.RevokeAPIKey)
+	})
 	router.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
 	))
@@ -74,9 +144,18 @@ func (s *Server) setupRouter() *chi.Mux {
 	return router
 }
 
-func (s *Server) MustRun(srvCfg config.ServerConfig) error {
+func (s *Server) MustRun() error {
+	if s.authCfg.Enabled {
+		verifier, err := NewJWTVerifier(s.authCfg)
+		if err != nil {
+			return err
+		}
+		s.jwt = verifier
+	}
+
 	router := s.setupRouter()
-	address := fmt.Sprintf("%s:%d", srvCfg.Host, srvCfg.Port)
+	go s.runRateLimitPurge(s.ctx)
+	address := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
 
 	httpServer := &http.Server{
 		Addr:    address,
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	jwtAlgHS256 = "HS256"
+	jwtAlgRS256 = "RS256"
+
+	// jwtLeeway допускает расхождение часов сервиса и издателя токенов
+	jwtLeeway = 30 * time.Second
+)
+
+var (
+	errTokenMalformed   = errors.New("token is malformed")
+	errTokenAlgorithm   = errors.New("token algorithm is not accepted")
+	errTokenKeyUnknown  = errors.New("token signing key is unknown")
+	errTokenSignature   = errors.New("token signature is invalid")
+	errTokenExpired     = errors.New("token is expired or not yet valid")
+	errTokenIssuer      = errors.New("token issuer is not accepted")
+	errTokenAudience    = errors.New("token audience is not accepted")
+	errTokenSubject     = errors.New("token subject must be a user ID (UUID)")
+	errTokenExpRequired = errors.New("token must have an expiration time")
+)
+
+// JWTVerifier проверяет JWT, подписанные общим секретом (HS256) или ключом RSA из локального JWKS (RS256)
+type JWTVerifier struct {
+	secret    []byte
+	keys      map[string]*rsa.PublicKey
+	issuer    string
+	audience  string
+	adminRole string
+	now       func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	Role      string      `json:"role"`
+	Roles     []string    `json:"roles"`
+	// Scope — права через пробел, как в OAuth 2.0; без него токен даёт read и write
+	Scope string `json:"scope"`
+}
+
+// jwtAudience читает aud, заданный строкой или массивом строк
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// NewJWTVerifier создаёт проверку токенов по настройкам AUTH_JWT_*; nil, если ни секрет, ни JWKS не заданы
+func NewJWTVerifier(authCfg config.AuthConfig) (*JWTVerifier, error) {
+	if !authCfg.JWTConfigured() {
+		return nil, nil
+	}
+
+	verifier := &JWTVerifier{
+		secret:    []byte(authCfg.JWTSecret),
+		issuer:    authCfg.JWTIssuer,
+		audience:  authCfg.JWTAudience,
+		adminRole: authCfg.JWTAdminRole,
+		now:       time.Now,
+	}
+	if authCfg.JWKSFile != "" {
+		keys, err := loadJWKS(authCfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keys = keys
+	}
+
+	return verifier, nil
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of JWKS key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of JWKS key %q", key.Kid)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s contains no RSA signing keys", path)
+	}
+
+	return keys, nil
+}
+
+// Verify проверяет подпись и срок действия токена и возвращает его владельца. Токен с ролью администратора
+// не ограничен пользователем и получает право admin; остальные ограничены пользователем из sub
+func (v *JWTVerifier) Verify(token string) (models.Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return models.Principal{}, errTokenMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return models.Principal{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return models.Principal{}, errTokenMalformed
+	}
+	if err := v.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return models.Principal{}, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return models.Principal{}, err
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return models.Principal{}, err
+	}
+
+	return v.principal(claims)
+}
+
+func (v *JWTVerifier) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	switch {
+	case header.Alg == jwtAlgHS256 && len(v.secret) > 0:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errTokenSignature
+		}
+		return nil
+	case header.Alg == jwtAlgRS256 && len(v.keys) > 0:
+		key, ok := v.keys[header.Kid]
+		if !ok && header.Kid == "" && len(v.keys) == 1 {
+			for _, only := range v.keys {
+				key, ok = only, true
+			}
+		}
+		if !ok {
+			return errTokenKeyUnknown
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errTokenSignature
+		}
+		return nil
+	default:
+		return errTokenAlgorithm
+	}
+}
+
+func (v *JWTVerifier) validateClaims(claims jwtClaims) error {
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return errTokenExpRequired
+	}
+	if now.After(unixTime(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return errTokenExpired
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return errTokenIssuer
+	}
+	if v.audience != "" && !slices.Contains(claims.Audience, v.audience) {
+		return errTokenAudience
+	}
+	return nil
+}
+
+func (v *JWTVerifier) principal(claims jwtClaims) (models.Principal, error) {
+	principal := models.Principal{ID: "token:" + claims.Subject, Name: claims.Subject}
+
+	if claims.Scope == "" {
+		principal.Scopes = []string{models.ScopeRead, models.ScopeWrite}
+	}
+	for scope := range strings.FieldsSeq(claims.Scope) {
+		if scope != models.ScopeAdmin && slices.Contains(models.Scopes, scope) {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+
+	if claims.Role == v.adminRole || slices.Contains(claims.Roles, v.adminRole) {
+		principal.Scopes = append(principal.Scopes, models.ScopeAdmin)
+		return principal, nil
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || userID == uuid.Nil {
+		return models.Principal{}, errTokenSubject
+	}
+	principal.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+
+	return principal, nil
+}
+
+func decodeJWTPart(part string, target any) error {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errTokenMalformed
+	}
+	if err := json.Unmarshal(content, target); err != nil {
+		return errTokenMalformed
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
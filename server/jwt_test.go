@@ -0,0 +1,272 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testJWTSecret = "0123456789abcdef0123456789abcdef"
+	testJWTKid    = "key-1"
+)
+
+var (
+	testJWTNow = time.Date(2025, time.September, 1, 12, 0, 0, 0, time.UTC)
+	testRSAKey = sync.OnceValue(func() *rsa.PrivateKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		return key
+	})
+)
+
+// writeJWKS сохраняет открытый ключ в файл JWKS и возвращает путь к нему
+func writeJWKS(t *testing.T, key *rsa.PublicKey) string {
+	t.Helper()
+
+	content, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testJWTKid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+	return path
+}
+
+// newTestJWTVerifier создаёт проверку токенов с часами, остановленными на testJWTNow
+func newTestJWTVerifier(t *testing.T, authCfg config.AuthConfig) *JWTVerifier {
+	t.Helper()
+
+	if authCfg.JWTAdminRole == "" {
+		authCfg.JWTAdminRole = "admin"
+	}
+	verifier, err := NewJWTVerifier(authCfg)
+	if err != nil {
+		t.Fatalf("NewJWTVerifier() error = %v", err)
+	}
+	verifier.now = func() time.Time { return testJWTNow }
+	return verifier
+}
+
+func encodeJWTPart(t *testing.T, part any) string {
+	t.Helper()
+
+	content, err := json.Marshal(part)
+	if err != nil {
+		t.Fatalf("marshal token part: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// signHS256 подписывает токен секретом; заголовок задаётся целиком, чтобы подменять alg и kid
+func signHS256(t *testing.T, secret []byte, header, claims map[string]any) string {
+	t.Helper()
+
+	signed := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+
+	header := map[string]any{"alg": jwtAlgRS256, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// userClaims возвращает утверждения действующего токена пользователя testUserID с заменой полей из overrides;
+// значение nil удаляет поле
+func userClaims(overrides map[string]any) map[string]any {
+	claims := map[string]any{
+		"sub": testUserID,
+		"iss": "https://issuer.example",
+		"aud": "subscriptions",
+		"exp": testJWTNow.Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	privateKey := testRSAKey()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	hsHeader := map[string]any{"alg": jwtAlgHS256, "typ": "JWT"}
+	secretCfg := config.AuthConfig{JWTSecret: testJWTSecret, JWTIssuer: "https://issuer.example", JWTAudience: "subscriptions"}
+	jwksCfg := config.AuthConfig{JWKSFile: writeJWKS(t, &privateKey.PublicKey)}
+	// открытый ключ известен всем, поэтому токен HS256, подписанный им как секретом, должен отвергаться
+	publicKeyBytes := privateKey.PublicKey.N.Bytes()
+
+	tests := []struct {
+		name    string
+		cfg     config.AuthConfig
+		token   string
+		wantErr error
+	}{
+		{"valid HS256", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(nil)), nil},
+		{"valid RS256", jwksCfg, signRS256(t, privateKey, testJWTKid, userClaims(nil)), nil},
+		{"RS256 without kid and single key", jwksCfg, signRS256(t, privateKey, "", userClaims(nil)), nil},
+		{"malformed", secretCfg, "not-a-token", errTokenMalformed},
+		{"malformed signature", secretCfg, encodeJWTPart(t, hsHeader) + "." + encodeJWTPart(t, userClaims(nil)) + ".!", errTokenMalformed},
+		{"wrong secret", secretCfg, signHS256(t, []byte("another-secret-another-secret-123"), hsHeader, userClaims(nil)), errTokenSignature},
+		{"tampered claims", secretCfg, encodeJWTPart(t, hsHeader) + "." + encodeJWTPart(t, userClaims(map[string]any{"role": "admin"})) + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), errTokenSignature},
+		{"HS256 signed with the JWKS public key", jwksCfg, signHS256(t, publicKeyBytes, hsHeader, userClaims(nil)), errTokenAlgorithm},
+		{"RS256 without JWKS", secretCfg, signRS256(t, privateKey, testJWTKid, userClaims(nil)), errTokenAlgorithm},
+		{"alg none", secretCfg, encodeJWTPart(t, map[string]any{"alg": "none"}) + "." + encodeJWTPart(t, userClaims(nil)) + ".", errTokenAlgorithm},
+		{"alg none without signature", secretCfg, encodeJWTPart(t, map[string]any{"alg": "none"}) + "." + encodeJWTPart(t, userClaims(nil)), errTokenMalformed},
+		{"unknown kid", jwksCfg, signRS256(t, privateKey, "key-2", userClaims(nil)), errTokenKeyUnknown},
+		{"signed by another RSA key", jwksCfg, signRS256(t, otherKey, testJWTKid, userClaims(nil)), errTokenSignature},
+		{"expired", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(map[string]any{"exp": testJWTNow.Add(-time.Minute).Unix()})), errTokenExpired},
+		{"missing exp", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(map[string]any{"exp": nil})), errTokenExpRequired},
+		{"wrong issuer", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(map[string]any{"iss": "https://evil.example"})), errTokenIssuer},
+		{"wrong audience", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(map[string]any{"aud": []string{"billing"}})), errTokenAudience},
+		{"non-UUID subject", secretCfg, signHS256(t, []byte(testJWTSecret), hsHeader, userClaims(map[string]any{"sub": "alice"})), errTokenSubject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := newTestJWTVerifier(t, tt.cfg).Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && principal.UserID.UUID.String() != testUserID {
+				t.Fatalf("Verify() user = %v, want %s", principal.UserID, testUserID)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierValidateClaims(t *testing.T) {
+	valid := func(change func(*jwtClaims)) jwtClaims {
+		expiresAt := float64(testJWTNow.Add(time.Hour).Unix())
+		claims := jwtClaims{Issuer: "https://issuer.example", Audience: jwtAudience{"subscriptions"}, ExpiresAt: &expiresAt}
+		if change != nil {
+			change(&claims)
+		}
+		return claims
+	}
+	at := func(offset time.Duration) *float64 {
+		seconds := float64(testJWTNow.Add(offset).Unix())
+		return &seconds
+	}
+
+	tests := []struct {
+		name    string
+		claims  jwtClaims
+		wantErr error
+	}{
+		{"valid", valid(nil), nil},
+		{"missing exp", valid(func(c *jwtClaims) { c.ExpiresAt = nil }), errTokenExpRequired},
+		{"expired within leeway", valid(func(c *jwtClaims) { c.ExpiresAt = at(-jwtLeeway + time.Second) }), nil},
+		{"expired at leeway", valid(func(c *jwtClaims) { c.ExpiresAt = at(-jwtLeeway) }), nil},
+		{"expired past leeway", valid(func(c *jwtClaims) { c.ExpiresAt = at(-jwtLeeway - time.Second) }), errTokenExpired},
+		{"not yet valid within leeway", valid(func(c *jwtClaims) { c.NotBefore = at(jwtLeeway) }), nil},
+		{"not yet valid past leeway", valid(func(c *jwtClaims) { c.NotBefore = at(jwtLeeway + time.Second) }), errTokenExpired},
+		{"wrong issuer", valid(func(c *jwtClaims) { c.Issuer = "https://evil.example" }), errTokenIssuer},
+		{"missing issuer", valid(func(c *jwtClaims) { c.Issuer = "" }), errTokenIssuer},
+		{"audience among several", valid(func(c *jwtClaims) { c.Audience = jwtAudience{"billing", "subscriptions"} }), nil},
+		{"wrong audience", valid(func(c *jwtClaims) { c.Audience = jwtAudience{"billing"} }), errTokenAudience},
+		{"missing audience", valid(func(c *jwtClaims) { c.Audience = nil }), errTokenAudience},
+	}
+	verifier := &JWTVerifier{
+		issuer:   "https://issuer.example",
+		audience: "subscriptions",
+		now:      func() time.Time { return testJWTNow },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifier.validateClaims(tt.claims); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateClaims() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// без AUTH_JWT_ISSUER и AUTH_JWT_AUDIENCE издатель и получатель не проверяются
+	unrestricted := &JWTVerifier{now: func() time.Time { return testJWTNow }}
+	if err := unrestricted.validateClaims(valid(func(c *jwtClaims) { c.Issuer, c.Audience = "", nil })); err != nil {
+		t.Fatalf("validateClaims() without issuer and audience settings error = %v", err)
+	}
+}
+
+func TestJWTVerifierPrincipal(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     jwtClaims
+		wantScopes []string
+		wantUser   bool
+		wantErr    error
+	}{
+		{"default scopes", jwtClaims{Subject: testUserID}, []string{models.ScopeRead, models.ScopeWrite}, true, nil},
+		{"read only", jwtClaims{Subject: testUserID, Scope: "read"}, []string{models.ScopeRead}, true, nil},
+		{"admin scope is ignored", jwtClaims{Subject: testUserID, Scope: "read admin"}, []string{models.ScopeRead}, true, nil},
+		{"unknown scope is ignored", jwtClaims{Subject: testUserID, Scope: "read billing"}, []string{models.ScopeRead}, true, nil},
+		{"admin role", jwtClaims{Subject: "ops", Role: "admin"}, []string{models.ScopeRead, models.ScopeWrite, models.ScopeAdmin}, false, nil},
+		{"admin among roles", jwtClaims{Subject: testUserID, Roles: []string{"support", "admin"}, Scope: "read"}, []string{models.ScopeRead, models.ScopeAdmin}, false, nil},
+		{"other role", jwtClaims{Subject: testUserID, Role: "support"}, []string{models.ScopeRead, models.ScopeWrite}, true, nil},
+		{"non-UUID subject", jwtClaims{Subject: "alice"}, nil, false, errTokenSubject},
+		{"nil UUID subject", jwtClaims{Subject: "00000000-0000-0000-0000-000000000000"}, nil, false, errTokenSubject},
+		{"missing subject", jwtClaims{}, nil, false, errTokenSubject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := &JWTVerifier{adminRole: "admin"}
+			principal, err := verifier.principal(tt.claims)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("principal() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(principal.Scopes, tt.wantScopes) {
+				t.Fatalf("principal() scopes = %v, want %v", principal.Scopes, tt.wantScopes)
+			}
+			if principal.UserID.Valid != tt.wantUser {
+				t.Fatalf("principal() user = %v, want scoped to a user: %v", principal.UserID, tt.wantUser)
+			}
+			if principal.Name != tt.claims.Subject {
+				t.Fatalf("principal() name = %q, want %q", principal.Name, tt.claims.Subject)
+			}
+		})
+	}
+}
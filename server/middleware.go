@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"subscription-aggregator-api/models"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// ActorHeader содержит идентификатор инициатора изменений для журнала
+const ActorHeader = "X-Actor"
+
+// auditContext передаёт инициатора и ID запроса в контекст, откуда их читает журнал изменений.
+// При включённой аутентификации authenticate заменяет инициатора из X-Actor на клиента
+func auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetReqID(r.Context())
+		if requestID != "" {
+			w.Header().Set(middleware.RequestIDHeader, requestID)
+		}
+
+		ctx := models.WithAuditInfo(r.Context(), models.AuditInfo{
+			Actor:     strings.TrimSpace(r.Header.Get(ActorHeader)),
+			RequestID: requestID,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+
+	// problemTypePrefix — префикс URI типа проблемы; за ним следует стабильный код ошибки
+	problemTypePrefix = "/problems/"
+)
+
+// Problem описывает ошибку в формате RFC 7807; code совпадает с последним сегментом type
+// и не меняется между версиями API, instance — ID запроса из заголовка X-Request-Id
+// swagger:model Problem
+type Problem struct {
+	Type     string         `json:"type" example:"/problems/validation_failed"`
+	Code     string         `json:"code" example:"validation_failed"`
+	Title    string         `json:"title" example:"Request validation failed"`
+	Status   int            `json:"status" example:"400"`
+	Detail   string         `json:"detail,omitempty" example:"price must be positive"`
+	Instance string         `json:"instance,omitempty" example:"host/abcdef-000001"`
+	Errors   []ProblemField `json:"errors,omitempty"`
+	// ConflictingID — подписка, с которой конфликтует запрос, для ошибки subscription_conflict
+	ConflictingID int `json:"conflicting_id,omitempty" example:"42"`
+	// Import — отчёт о строках, обработанных до того, как импорт был прерван
+	Import *ImportResponse `json:"import,omitempty"`
+}
+
+// ProblemField описывает некорректное поле запроса
+// swagger:model ProblemField
+type ProblemField struct {
+	Field   string `json:"field" example:"price"`
+	Message string `json:"message" example:"price must be positive"`
+}
+
+// problemKind — тип проблемы: стабильный код, заголовок и HTTP-статус
+type problemKind struct {
+	code   string
+	title  string
+	status int
+}
+
+var (
+	problemValidation           = problemKind{"validation_failed", "Request validation failed", http.StatusBadRequest}
+	problemInvalidRequest       = problemKind{"invalid_request", "Invalid request", http.StatusBadRequest}
+	problemUnauthorized         = problemKind{"unauthorized", "Authentication required", http.StatusUnauthorized}
+	problemForbidden            = problemKind{"insufficient_scope", "Insufficient scope", http.StatusForbidden}
+	problemForeignUser          = problemKind{"forbidden", "Access to another user's data is forbidden", http.StatusForbidden}
+	problemNotFound             = problemKind{"not_found", "Resource not found", http.StatusNotFound}
+	problemSubscriptionNotFound = problemKind{"subscription_not_found", "Subscription not found", http.StatusNotFound}
+	problemNoSubscriptions      = problemKind{"subscriptions_not_found", "Subscriptions not found", http.StatusNotFound}
+	problemNoEvents             = problemKind{"events_not_found", "Subscription events not found", http.StatusNotFound}
+	problemAPIKeyNotFound       = problemKind{"api_key_not_found", "API key not found", http.StatusNotFound}
+	problemMethodNotAllowed     = problemKind{"method_not_allowed", "Method not allowed", http.StatusMethodNotAllowed}
+	problemSubscriptionConflict = problemKind{"subscription_conflict", "Subscription conflicts with an existing one", http.StatusConflict}
+	problemNotDeleted           = problemKind{"subscription_not_deleted", "Subscription is not deleted", http.StatusConflict}
+	problemIdempotencyReused    = problemKind{"idempotency_key_reused", "Idempotency key reused", http.StatusConflict}
+	problemIdempotencyConflict  = problemKind{"idempotency_key_in_progress", "Idempotent request in progress", http.StatusConflict}
+	problemVersionMismatch      = problemKind{"version_mismatch", "Subscription version mismatch", http.StatusPreconditionFailed}
+	problemBodyTooLarge         = problemKind{"request_too_large", "Request body too large", http.StatusRequestEntityTooLarge}
+	problemUnsupportedMediaType = problemKind{"unsupported_media_type", "Unsupported media type", http.StatusUnsupportedMediaType}
+	problemExchangeRateMissing  = problemKind{"exchange_rate_missing", "Exchange rate missing", http.StatusUnprocessableEntity}
+	problemBatchRolledBack      = problemKind{"batch_rolled_back", "Batch rolled back", http.StatusFailedDependency}
+	problemInvalidPrecondition  = problemKind{"invalid_precondition", "Invalid precondition", http.StatusPreconditionFailed}
+	problemPreconditionRequired = problemKind{"precondition_required", "Precondition required", http.StatusPreconditionRequired}
+	problemTooManyRequests      = problemKind{"rate_limited", "Too many requests", http.StatusTooManyRequests}
+	problemTimeout              = problemKind{"timeout", "Request timed out", http.StatusGatewayTimeout}
+	problemInternal             = problemKind{"internal_error", "Internal server error", http.StatusInternalServerError}
+)
+
+func writeProblem(w http.ResponseWriter, r *http.Request, kind problemKind, detail string, fields []ProblemField) {
+	sendProblem(w, newProblem(r, kind, detail, fields))
+}
+
+func newProblem(r *http.Request, kind problemKind, detail string, fields []ProblemField) Problem {
+	return Problem{
+		Type:     problemTypePrefix + kind.code,
+		Code:     kind.code,
+		Title:    kind.title,
+		Status:   kind.status,
+		Detail:   detail,
+		Instance: middleware.GetReqID(r.Context()),
+		Errors:   fields,
+	}
+}
+
+func sendProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		slog.Error("Failed to write problem response", "error", err)
+	}
+}
+
+// classifyError сопоставляет ошибку менеджера или хранилища с типом проблемы, текстом и ошибками полей
+func classifyError(err error) (problemKind, string, []ProblemField) {
+	var validationErr *manager.ValidationError
+	var badReqErr *manager.BadRequestError
+	var conflictErr *models.ConflictError
+
+	switch {
+	case errors.As(err, &validationErr):
+		fields := make([]ProblemField, len(validationErr.Fields))
+		for i, field := range validationErr.Fields {
+			fields[i] = ProblemField{Field: field.Field, Message: field.Err.Error()}
+		}
+		return problemValidation, validationErr.Error(), fields
+	case errors.As(err, &badReqErr):
+		return problemInvalidRequest, err.Error(), nil
+	case errors.Is(err, storage.ErrSubscriptionNotFound), errors.Is(err, manager.ErrSubscriptionNotOwned):
+		return problemSubscriptionNotFound, ErrSubscriptionNotFound, nil
+	case errors.Is(err, manager.ErrForeignUser), errors.Is(err, manager.ErrAdminRequired):
+		return problemForeignUser, err.Error(), nil
+	case errors.As(err, &conflictErr):
+		return problemSubscriptionConflict, err.Error(), nil
+	case errors.Is(err, storage.ErrNotDeleted):
+		return problemNotDeleted, ErrSubscriptionNotDeleted, nil
+	case errors.Is(err, manager.ErrIdempotencyKeyReused):
+		return problemIdempotencyReused, err.Error(), nil
+	case errors.Is(err, manager.ErrIdempotencyKeyInProgress), errors.Is(err, storage.ErrIdempotencyKeyContended):
+		return problemIdempotencyConflict, err.Error(), nil
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return problemVersionMismatch, ErrPreconditionFailed, nil
+	case errors.Is(err, manager.ErrBatchVersionRequired):
+		return problemPreconditionRequired, err.Error(), nil
+	case errors.Is(err, storage.ErrNoEvents):
+		return problemNoEvents, ErrEventsNotFound, nil
+	case errors.Is(err, storage.ErrAPIKeyNotFound):
+		return problemAPIKeyNotFound, ErrAPIKeyNotFound, nil
+	case errors.Is(err, storage.ErrNoSubscriptions):
+		return problemNoSubscriptions, ErrSubscriptionsNotFound, nil
+	case errors.Is(err, manager.ErrExchangeRateMissing):
+		return problemExchangeRateMissing, err.Error(), nil
+	case errors.Is(err, models.ErrBatchRolledBack):
+		return problemBatchRolledBack, err.Error(), nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return problemTimeout, ErrGatewayTimeout, nil
+	default:
+		return problemInternal, ErrInternalServerError, nil
+	}
+}
+
+func (s *Server) handleSubscriptionError(w http.ResponseWriter, r *http.Request, err error) {
+	if problem, ok := s.errorProblem(r, err); ok {
+		sendProblem(w, problem)
+	}
+}
+
+// errorProblem записывает ошибку в журнал и описывает её для ответа; false, если клиент отменил запрос
+func (s *Server) errorProblem(r *http.Request, err error) (Problem, bool) {
+	if errors.Is(err, context.Canceled) {
+		slog.Warn("Request canceled by client", "error", err)
+		return Problem{}, false
+	}
+
+	kind, detail, fields := classifyError(err)
+	switch {
+	case kind == problemTimeout:
+		slog.Error("Storage request timed out", "error", err)
+	case kind.status >= http.StatusInternalServerError:
+		slog.Error("Internal server error", "error", err)
+	default:
+		slog.Warn(err.Error(), "code", kind.code)
+	}
+
+	problem := newProblem(r, kind, detail, fields)
+	problem.ConflictingID = conflictingID(err)
+	return problem, true
+}
+
+// conflictingID возвращает подписку, с которой конфликтует запрос, или 0
+func conflictingID(err error) int {
+	var conflictErr *models.ConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr.ID
+	}
+	return 0
+}
+
+// handleDecodeError отвечает на тело запроса, которое не удалось разобрать как JSON
+func (s *Server) handleDecodeError(w http.ResponseWriter, r *http.Request, model string, err error) {
+	slog.Warn("Failed to decode "+model+" from JSON", "error", err)
+	s.handleSubscriptionError(w, r, manager.DecodeError(err))
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemNotFound, "No route for "+r.Method+" "+r.URL.Path, nil)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemMethodNotAllowed, "Method "+r.Method+" is not allowed for "+r.URL.Path, nil)
+}
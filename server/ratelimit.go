@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"sync"
+	"time"
+)
+
+const (
+	RetryAfterHeader         = "Retry-After"
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+
+	ErrTooManyRequests     = "Rate limit exceeded, retry later"
+	ErrTooManyAuthFailures = "Too many failed authentication attempts, retry later"
+
+	// rateLimitPurgeInterval — как часто удаляются вёдра неактивных клиентов
+	rateLimitPurgeInterval = 10 * time.Minute
+)
+
+// RateLimitStore хранит вёдра маркеров; реализация в базе позволяет нескольким экземплярам сервиса
+// делить один лимит
+type RateLimitStore interface {
+	TakeRateLimitToken(ctx context.Context, key string, burst int, rate float64) (float64, bool, error)
+	PeekRateLimitTokens(ctx context.Context, key string, burst int, rate float64) (float64, error)
+	PurgeRateLimitBuckets(ctx context.Context, idleBefore time.Time) (int, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// memoryRateLimitStore хранит вёдра в памяти процесса
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+func (m *memoryRateLimitStore) TakeRateLimitToken(ctx context.Context, key string, burst int, rate float64) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), updated: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return bucket.tokens, false, nil
+	}
+	bucket.tokens--
+	return bucket.tokens, true, nil
+}
+
+func (m *memoryRateLimitStore) PeekRateLimitTokens(ctx context.Context, key string, burst int, rate float64) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		return float64(burst), nil
+	}
+	return math.Min(float64(burst), bucket.tokens+m.now().Sub(bucket.updated).Seconds()*rate), nil
+}
+
+func (m *memoryRateLimitStore) PurgeRateLimitBuckets(ctx context.Context, idleBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for key, bucket := range m.buckets {
+		if bucket.updated.Before(idleBefore) {
+			delete(m.buckets, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// rateLimit ограничивает запросы одного клиента к группе маршрутов group: аутентифицированные клиенты
+// различаются по ключу доступа или пользователю токена, остальные — по IP-адресу
+func (s *Server) rateLimit(group string, limit config.RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		rate := limit.Rate()
+		policy := strconv.Itoa(limit.Requests) + ";w=" + strconv.Itoa(int(math.Ceil(limit.Period.Seconds())))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := group + ":" + s.rateLimitClient(r)
+			tokens, allowed, err := s.rateLimits.TakeRateLimitToken(r.Context(), key, limit.Requests, rate)
+			if err != nil {
+				// недоступное хранилище лимитов не должно останавливать сервис
+				slog.Error("Failed to check rate limit, request allowed", "key", key, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set(RateLimitPolicyHeader, policy)
+			header.Set(RateLimitLimitHeader, strconv.Itoa(limit.Requests))
+			header.Set(RateLimitRemainingHeader, strconv.Itoa(max(int(tokens), 0)))
+			header.Set(RateLimitResetHeader, strconv.Itoa(secondsUntil(float64(limit.Requests)-tokens, rate)))
+
+			if !allowed {
+				slog.Warn("Rate limit exceeded", "key", key, "path", r.URL.Path)
+				header.Set(RetryAfterHeader, strconv.Itoa(secondsUntil(1-tokens, rate)))
+				writeProblem(w, r, problemTooManyRequests, ErrTooManyRequests, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkAuthFailures отвечает 429, если с адреса клиента было слишком много неудачных попыток аутентификации.
+// Ведро только проверяется, а маркер забирает chargeAuthFailure, поэтому успешные запросы лимит не расходуют,
+// а перебор ключей останавливается до их поиска в базе
+func (s *Server) checkAuthFailures(w http.ResponseWriter, r *http.Request) bool {
+	limit := s.cfg.RateLimitAuth
+	if !limit.Enabled() {
+		return true
+	}
+
+	key := authFailureKey(s.clientIP(r))
+	tokens, err := s.rateLimits.PeekRateLimitTokens(r.Context(), key, limit.Requests, limit.Rate())
+	if err != nil {
+		slog.Error("Failed to check authentication failures, request allowed", "key", key, "error", err)
+		return true
+	}
+	if tokens >= 1 {
+		return true
+	}
+
+	slog.Warn("Too many failed authentication attempts", "key", key, "path", r.URL.Path)
+	w.Header().Set(RetryAfterHeader, strconv.Itoa(secondsUntil(1-tokens, limit.Rate())))
+	writeProblem(w, r, problemTooManyRequests, ErrTooManyAuthFailures, nil)
+	return false
+}
+
+// chargeAuthFailure забирает маркер из ведра неудачных попыток аутентификации адреса клиента
+func (s *Server) chargeAuthFailure(r *http.Request) {
+	limit := s.cfg.RateLimitAuth
+	if !limit.Enabled() {
+		return
+	}
+
+	key := authFailureKey(s.clientIP(r))
+	if _, _, err := s.rateLimits.TakeRateLimitToken(r.Context(), key, limit.Requests, limit.Rate()); err != nil {
+		slog.Error("Failed to record authentication failure", "key", key, "error", err)
+	}
+}
+
+func authFailureKey(client string) string {
+	return "auth_failure:" + client
+}
+
+// rateLimitClient возвращает ключ клиента для ограничения запросов
+func (s *Server) rateLimitClient(r *http.Request) string {
+	if principal, ok := models.PrincipalFrom(r.Context()); ok && principal.ID != "" {
+		return principal.ID
+	}
+	return s.clientIP(r)
+}
+
+// clientIP возвращает ключ клиента по IP-адресу
+func (s *Server) clientIP(r *http.Request) string {
+	if s.cfg.TrustForwardedFor {
+		if client, ok := forwardedClient(r.Header.Values("X-Forwarded-For"), s.cfg.TrustedProxies); ok {
+			return "ip:" + client
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// forwardedClient возвращает адрес, который добавил в X-Forwarded-For самый дальний из trustedProxies доверенных прокси.
+// Каждый прокси дописывает адрес своего собеседника в конец списка, поэтому левые записи задаёт сам клиент
+// и верить им нельзя. Если записей меньше, чем прокси, берётся самая левая
+func forwardedClient(values []string, trustedProxies int) (string, bool) {
+	var hops []string
+	for _, value := range values {
+		for hop := range strings.SplitSeq(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		return "", false
+	}
+	return hops[max(len(hops)-max(trustedProxies, 1), 0)], true
+}
+
+// runRateLimitPurge удаляет вёдра клиентов, которые не обращались к сервису дольше самого длинного окна лимита
+func (s *Server) runRateLimitPurge(ctx context.Context) {
+	window := max(s.cfg.RateLimitRead.Period, s.cfg.RateLimitWrite.Period, s.cfg.RateLimitAdmin.Period, s.cfg.RateLimitAuth.Period)
+	ticker := time.NewTicker(rateLimitPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.rateLimits.PurgeRateLimitBuckets(ctx, time.Now().Add(-window))
+			if err != nil {
+				slog.Error("Failed to purge rate limit buckets", "error", err)
+				continue
+			}
+			if purged > 0 {
+				slog.Info("Idle rate limit buckets purged", "count", purged)
+			}
+		}
+	}
+}
+
+// secondsUntil возвращает, через сколько целых секунд в ведре наберётся ещё tokens маркеров
+func secondsUntil(tokens, rate float64) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / rate))
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// failingRateLimitStore имитирует недоступное хранилище лимитов
+type failingRateLimitStore struct{}
+
+var errRateLimitStoreDown = errors.New("rate limit store is down")
+
+func (failingRateLimitStore) TakeRateLimitToken(context.Context, string, int, float64) (float64, bool, error) {
+	return 0, false, errRateLimitStoreDown
+}
+
+func (failingRateLimitStore) PeekRateLimitTokens(context.Context, string, int, float64) (float64, error) {
+	return 0, errRateLimitStoreDown
+}
+
+func (failingRateLimitStore) PurgeRateLimitBuckets(context.Context, time.Time) (int, error) {
+	return 0, errRateLimitStoreDown
+}
+
+func TestForwardedClient(t *testing.T) {
+	tests := []struct {
+		name           string
+		values         []string
+		trustedProxies int
+		want           string
+		wantOK         bool
+	}{
+		{"no header", nil, 1, "", false},
+		{"empty header", []string{" , "}, 1, "", false},
+		{"single proxy takes the rightmost entry", []string{"203.0.113.7"}, 1, "203.0.113.7", true},
+		{"spoofed entries are ignored", []string{"10.0.0.1, 198.51.100.2, 203.0.113.7"}, 1, "203.0.113.7", true},
+		{"two proxies", []string{"10.0.0.1, 203.0.113.7, 192.0.2.10"}, 2, "203.0.113.7", true},
+		{"repeated headers", []string{"10.0.0.1", "203.0.113.7"}, 1, "203.0.113.7", true},
+		{"fewer entries than proxies", []string{"203.0.113.7"}, 3, "203.0.113.7", true},
+		{"unset proxies count as one", []string{"10.0.0.1, 203.0.113.7"}, 0, "203.0.113.7", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := forwardedClient(tt.values, tt.trustedProxies)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("forwardedClient(%q, %d) = %q, %v, want %q, %v", tt.values, tt.trustedProxies, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/subscriptions", nil)
+	r.RemoteAddr = "192.0.2.10:51234"
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+
+	untrusted := &Server{cfg: config.ServerConfig{}}
+	if got := untrusted.clientIP(r); got != "ip:192.0.2.10" {
+		t.Fatalf("clientIP() without trusted proxy = %q, want the remote address", got)
+	}
+	trusted := &Server{cfg: config.ServerConfig{TrustForwardedFor: true, TrustedProxies: 1}}
+	if got := trusted.clientIP(r); got != "ip:203.0.113.7" {
+		t.Fatalf("clientIP() behind a trusted proxy = %q, want the rightmost entry", got)
+	}
+}
+
+func TestRateLimitExceeded(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{RateLimitRead: config.RateLimit{Requests: 2, Period: time.Minute}}, config.AuthConfig{})
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		w := s.do(t, http.MethodGet, target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+		header := w.Header()
+		if header.Get(RateLimitLimitHeader) != "2" || header.Get(RateLimitRemainingHeader) != wantRemaining || header.Get(RateLimitPolicyHeader) != "2;w=60" {
+			t.Fatalf("request %d rate limit headers = %v", i+1, header)
+		}
+	}
+
+	w := s.do(t, http.MethodGet, target, "")
+	retryAfter, err := strconv.Atoi(w.Header().Get(RetryAfterHeader))
+	if err != nil || retryAfter < 1 || retryAfter > 30 {
+		t.Fatalf("Retry-After = %q, want 1-30 seconds", w.Header().Get(RetryAfterHeader))
+	}
+	if problem := decodeProblem(t, w, http.StatusTooManyRequests); problem.Code != problemTooManyRequests.code {
+		t.Fatalf("problem code = %q, want %q", problem.Code, problemTooManyRequests.code)
+	}
+
+	// другая группа маршрутов ограничивается своим ведром
+	if w := s.do(t, http.MethodPost, "/subscriptions", subscriptionBody("Sber Prime", 400)); w.Code != http.StatusCreated {
+		t.Fatalf("write request status = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestRateLimitFailOpen(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{
+		RateLimitRead: config.RateLimit{Requests: 1, Period: time.Minute},
+		RateLimitAuth: config.RateLimit{Requests: 1, Period: time.Minute},
+	}, config.AuthConfig{Enabled: true})
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	s.rateLimits = failingRateLimitStore{}
+	_, secret := s.newAPIKey(t, "reader", models.ScopeRead)
+
+	for i := range 3 {
+		w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret))
+		if w.Code != http.StatusOK || w.Header().Get(RateLimitLimitHeader) != "" {
+			t.Fatalf("request %d with failing store = %d, headers %v, want 200 without rate limit headers", i+1, w.Code, w.Header())
+		}
+		if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer("sa_unknown")); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d with unknown key and failing store = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAuthFailureLockout(t *testing.T) {
+	s := newTestServer(t, config.ServerConfig{
+		RateLimitAuth:     config.RateLimit{Requests: 2, Period: time.Minute},
+		TrustForwardedFor: true,
+		TrustedProxies:    1,
+	}, config.AuthConfig{Enabled: true})
+	target := "/subscriptions/" + strconv.Itoa(s.seedSubscription(t, "Yandex Plus", uuid.MustParse(testUserID)).ID)
+	_, secret := s.newAPIKey(t, "reader", models.ScopeRead)
+	const attacker, other = "203.0.113.7", "198.51.100.2"
+
+	// успешные запросы лимит неудачных попыток не расходуют
+	for range 3 {
+		if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret), "X-Forwarded-For", attacker); w.Code != http.StatusOK {
+			t.Fatalf("valid key status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	for i := range 2 {
+		if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer("sa_guess"), "X-Forwarded-For", attacker); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failed attempt %d status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// после исчерпания лимита адрес блокируется до проверки ключа, в том числе для верного ключа
+	for _, token := range []string{"sa_guess", secret} {
+		w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(token), "X-Forwarded-For", attacker)
+		if w.Header().Get(RetryAfterHeader) == "" {
+			t.Fatalf("locked out response has no %s header", RetryAfterHeader)
+		}
+		if problem := decodeProblem(t, w, http.StatusTooManyRequests); problem.Detail != ErrTooManyAuthFailures {
+			t.Fatalf("problem detail = %q, want %q", problem.Detail, ErrTooManyAuthFailures)
+		}
+	}
+
+	// подделанная левая запись X-Forwarded-For не обходит блокировку
+	if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret), "X-Forwarded-For", other+", "+attacker); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed X-Forwarded-For status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := s.do(t, http.MethodGet, target, "", AuthorizationHeader, bearer(secret), "X-Forwarded-For", other); w.Code != http.StatusOK {
+		t.Fatalf("other client status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
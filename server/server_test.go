@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testUserID = "6f1c6a3e-8b2d-4c8e-9c1a-2b3d4e5f6a7b"
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testServer — сервер на хранилище в памяти вместе с его маршрутами
+type testServer struct {
+	*Server
+	manager *manager.Manager
+	storage *storage.MemoryStorage
+	router  http.Handler
+}
+
+func newTestServer(t *testing.T, srvCfg config.ServerConfig, authCfg config.AuthConfig) *testServer {
+	t.Helper()
+
+	memory := storage.NewMemory()
+	subscriptionManager := manager.New(memory, time.Second)
+	server := Init(t.Context(), subscriptionManager, srvCfg, authCfg, nil)
+	if authCfg.Enabled && authCfg.JWTConfigured() {
+		verifier, err := NewJWTVerifier(authCfg)
+		if err != nil {
+			t.Fatalf("NewJWTVerifier() error = %v", err)
+		}
+		server.jwt = verifier
+	}
+
+	return &testServer{Server: server, manager: subscriptionManager, storage: memory, router: server.setupRouter()}
+}
+
+// do выполняет запрос к маршрутам сервера; headers задаются парами имя–значение
+func (s *testServer) do(t *testing.T, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// decodeProblem разбирает ответ с ошибкой и проверяет его статус
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder, wantStatus int) Problem {
+	t.Helper()
+
+	if w.Code != wantStatus {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, wantStatus, w.Body)
+	}
+	var problem Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return problem
+}
+
+func subscriptionBody(serviceName string, price int) string {
+	return `{"service_name":"` + serviceName + `","price":` + strconv.Itoa(price) + `,"user_id":"` + testUserID + `","start_date":"07-2025"}`
+}
+
+// newAPIKey выпускает ключ доступа с правами scopes и возвращает его секрет
+func (s *testServer) newAPIKey(t *testing.T, name string, scopes ...string) (models.APIKey, string) {
+	t.Helper()
+
+	key, secret, err := s.manager.CreateAPIKey(t.Context(), name, scopes)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	return key, secret
+}
+
+func bearer(token string) string {
+	return BearerScheme + " " + token
+}
+
+// seedSubscription создаёт подписку в обход HTTP-слоя и возвращает её
+func (s *testServer) seedSubscription(t *testing.T, serviceName string, userID uuid.UUID) models.Subscription {
+	t.Helper()
+
+	created, err := s.manager.CreateSubscription(t.Context(), models.Subscription{
+		UserID:      userID,
+		ServiceName: serviceName,
+		Price:       400,
+		StartDate:   models.NewMonth(2025, time.July),
+	})
+	if err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+	return created
+}
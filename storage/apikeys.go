@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+const apiKeyColumns = `id, name, prefix, scopes, created_at, last_used_at, revoked_at`
+
+// CreateAPIKey сохраняет ключ доступа вместе с хешем самого ключа
+func (s *SQLStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING ` + apiKeyColumns + ";"
+
+	return scanAPIKey(s.db.QueryRowContext(ctx, query, key.Name, key.Prefix, hash, strings.Join(key.Scopes, ",")))
+}
+
+// FindAPIKey ищет ключ доступа по хешу, в том числе отозванный; ok = false, если ключа нет
+func (s *SQLStorage) FindAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE key_hash = $1;
+	`
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, false, nil
+	}
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	return key, true, nil
+}
+
+// GetAPIKeys возвращает все ключи доступа по возрастанию ID
+func (s *SQLStorage) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		ORDER BY id;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey отзывает ключ доступа; повторный отзыв не меняет время первого
+func (s *SQLStorage) RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+		WHERE id = $1
+		RETURNING ` + apiKeyColumns + ";"
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// TouchAPIKey запоминает время последнего использования ключа
+func (s *SQLStorage) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $2
+		WHERE id = $1;
+	`
+
+	_, err := s.db.ExecContext(ctx, query, id, s.dialect.timestamp(usedAt))
+	return err
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var createdAt timestamp
+	var lastUsedAt, revokedAt *timestamp
+
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+		return models.APIKey{}, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	key.CreatedAt = time.Time(createdAt).UTC()
+	if lastUsedAt != nil {
+		used := time.Time(*lastUsedAt).UTC()
+		key.LastUsedAt = &used
+	}
+	if revokedAt != nil {
+		revoked := time.Time(*revokedAt).UTC()
+		key.RevokedAt = &revoked
+	}
+
+	return key, nil
+}
+
+func (s *MemoryStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAPIKeyID++
+	key.ID = s.lastAPIKeyID
+	key.CreatedAt = time.Now().UTC()
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	s.apiKeys[key.ID] = cloneAPIKey(key)
+	s.apiKeyHashes[hash] = key.ID
+
+	return cloneAPIKey(key), nil
+}
+
+func (s *MemoryStorage) FindAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.apiKeyHashes[hash]
+	if !ok {
+		return models.APIKey{}, false, nil
+	}
+	return cloneAPIKey(s.apiKeys[id]), true, nil
+}
+
+func (s *MemoryStorage) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, cloneAPIKey(key))
+	}
+	slices.SortFunc(keys, func(a, b models.APIKey) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return keys, nil
+}
+
+func (s *MemoryStorage) RevokeAPIKey(ctx context.Context, id int) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		s.apiKeys[id] = key
+	}
+
+	return cloneAPIKey(key), nil
+}
+
+func (s *MemoryStorage) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	usedAt = usedAt.UTC()
+	key.LastUsedAt = &usedAt
+	s.apiKeys[id] = key
+
+	return nil
+}
+
+func cloneAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = slices.Clone(key.Scopes)
+	if key.LastUsedAt != nil {
+		used := *key.LastUsedAt
+		key.LastUsedAt = &used
+	}
+	if key.RevokedAt != nil {
+		revoked := *key.RevokedAt
+		key.RevokedAt = &revoked
+	}
+	return key
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"subscription-aggregator-api/models"
+)
+
+// Batch выполняет операции в одной транзакции. В режиме atomic первая ошибка отменяет всю транзакцию,
+// иначе каждая операция выполняется в своей точке сохранения и ошибка отменяет только её.
+// Ошибки операций возвращаются в результатах, err — только при сбое самой транзакции
+func (s *SQLStorage) Batch(ctx context.Context, ops []models.BatchOperation, atomic bool) ([]models.BatchResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]models.BatchResult, len(ops))
+	for i, op := range ops {
+		if atomic {
+			if results[i] = s.applyBatchOp(ctx, tx, op); results[i].Err != nil {
+				return rollBackResults(ops, results, i), nil
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_operation;`); err != nil {
+			return nil, err
+		}
+		if results[i] = s.applyBatchOp(ctx, tx, op); results[i].Err != nil {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_operation;`); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_operation;`); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *SQLStorage) applyBatchOp(ctx context.Context, q querier, op models.BatchOperation) models.BatchResult {
+	result := models.BatchResult{Op: op.Op, ID: op.ID}
+
+	var subscription models.Subscription
+	switch op.Op {
+	case models.BatchOpCreate:
+		subscription, result.Err = s.create(ctx, q, *op.Subscription)
+	case models.BatchOpUpdate:
+		subscription, result.Err = s.patch(ctx, q, op.ID, op.Version, func(models.Subscription) (models.Subscription, error) {
+			return *op.Subscription, nil
+		})
+	case models.BatchOpDelete:
+		result.Err = s.delete(ctx, q, op.ID)
+		return result
+	default:
+		result.Err = fmt.Errorf("unknown batch operation %q", op.Op)
+		return result
+	}
+
+	if result.Err == nil {
+		result.ID = subscription.ID
+		result.Subscription = &subscription
+	}
+	return result
+}
+
+// rollBackResults помечает все операции, кроме failed, отменёнными после ошибки в режиме atomic
+func rollBackResults(ops []models.BatchOperation, results []models.BatchResult, failed int) []models.BatchResult {
+	for i, op := range ops {
+		if i != failed {
+			results[i] = models.BatchResult{Op: op.Op, ID: op.ID, Err: models.ErrBatchRolledBack}
+		}
+	}
+	return results
+}
+
+// Batch выполняет операции под одной блокировкой; в режиме atomic после ошибки восстанавливает состояние до пакета
+func (s *MemoryStorage) Batch(ctx context.Context, ops []models.BatchOperation, atomic bool) ([]models.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.snapshot()
+	results := make([]models.BatchResult, len(ops))
+	for i, op := range ops {
+		if results[i] = s.applyBatchOp(ctx, op); results[i].Err != nil && atomic {
+			s.restore(snapshot)
+			return rollBackResults(ops, results, i), nil
+		}
+	}
+
+	return results, nil
+}
+
+func (s *MemoryStorage) applyBatchOp(ctx context.Context, op models.BatchOperation) models.BatchResult {
+	result := models.BatchResult{Op: op.Op, ID: op.ID}
+
+	var subscription models.Subscription
+	switch op.Op {
+	case models.BatchOpCreate:
+		subscription, result.Err = s.create(ctx, *op.Subscription)
+	case models.BatchOpUpdate:
+		subscription, result.Err = s.patch(ctx, op.ID, op.Version, func(models.Subscription) (models.Subscription, error) {
+			return *op.Subscription, nil
+		})
+	case models.BatchOpDelete:
+		result.Err = s.delete(ctx, op.ID)
+		return result
+	default:
+		result.Err = fmt.Errorf("unknown batch operation %q", op.Op)
+		return result
+	}
+
+	if result.Err == nil {
+		result.ID = subscription.ID
+		result.Subscription = &subscription
+	}
+	return result
+}
+
+// memorySnapshot — состояние MemoryStorage, к которому откатывается пакет в режиме atomic
+type memorySnapshot struct {
+	subscriptions map[int]models.Subscription
+	prices        map[int][]models.PriceChange
+	lastID        int
+	events        int
+}
+
+// snapshot запоминает состояние хранилища; вызывается под s.mu. Записи и истории цен
+// при изменении заменяются целиком, поэтому достаточно копий карт
+func (s *MemoryStorage) snapshot() memorySnapshot {
+	return memorySnapshot{
+		subscriptions: maps.Clone(s.subscriptions),
+		prices:        maps.Clone(s.prices),
+		lastID:        s.lastID,
+		events:        len(s.events),
+	}
+}
+
+func (s *MemoryStorage) restore(snapshot memorySnapshot) {
+	s.subscriptions = snapshot.subscriptions
+	s.prices = snapshot.prices
+	s.lastID = snapshot.lastID
+	s.events = s.events[:snapshot.events]
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dialect содержит запросы, которые различаются между поддерживаемыми СУБД
+type dialect struct {
+	// activeIn — условие активности подписки в месяце, параметр подставляется как %[1]d
+	activeIn string
+	// forUpdate блокирует строку до конца транзакции; SQLite не поддерживает блокировку строк,
+	// но соединение с ней единственное, поэтому транзакции и так выполняются последовательно
+	forUpdate string
+	cursor    func(sort sortColumn, comparison string, valueArg, idArg int) string
+	// timestamp приводит время к виду, сравнимому со значениями CURRENT_TIMESTAMP в базе
+	timestamp func(time.Time) any
+	// sumItems — отрезки подписок с постоянной ценой внутри периода $1..$2
+	sumItems string
+	// copyIn строит команду COPY FROM STDIN для массовой загрузки; nil, если СУБД её не поддерживает
+	copyIn func(table string, columns ...string) string
+	// rateLimitRefill — число маркеров в ведре после пополнения: %[1]s — маркеры, %[2]s — время обновления,
+	// $%[3]d — ёмкость ведра, $%[4]d — скорость пополнения в маркерах в секунду
+	rateLimitRefill string
+	// preciseNow — текущее время с долями секунды
+	preciseNow string
+	// uniquenessViolation сообщает, что запрос нарушил уникальный индекс или ограничение пересечения подписок
+	uniquenessViolation func(error) bool
+}
+
+var postgresDialect = dialect{
+	activeIn: `DATE_TRUNC('month', start_date)::date <= $%[1]d::date
+			AND (end_date IS NULL OR DATE_TRUNC('month', end_date)::date >= $%[1]d::date)`,
+	forUpdate: "FOR UPDATE",
+	cursor: func(sort sortColumn, comparison string, valueArg, idArg int) string {
+		return fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", sort.column, comparison, valueArg, sort.cast, idArg)
+	},
+	timestamp: func(t time.Time) any {
+		return t
+	},
+	copyIn:          pq.CopyIn,
+	rateLimitRefill: `LEAST($%[3]d::float8, %[1]s + EXTRACT(EPOCH FROM (clock_timestamp() - %[2]s)) * $%[4]d::float8)`,
+	preciseNow:      `clock_timestamp()`,
+	uniquenessViolation: func(err error) bool {
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && (pqErr.Code == "23505" || pqErr.Code == "23P01")
+	},
+	sumItems: `
+		SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+			period_start, period_end, months
+		FROM (
+			SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+				period_start, period_end,
+				(EXTRACT(YEAR FROM AGE(period_end, period_start)) * 12 +
+				 EXTRACT(MONTH FROM AGE(period_end, period_start)))::int + 1 AS months
+			FROM (
+				SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+					GREATEST(segment_start, $1::date) AS period_start,
+					LEAST(segment_end, end_month, $2::date) AS period_end
+				FROM (
+					SELECT s.id, s.user_id, s.service_name, p.price, s.currency, s.billing_period, s.billing_interval,
+						DATE_TRUNC('month', s.start_date)::date AS start_month,
+						CASE WHEN ROW_NUMBER() OVER w = 1 THEN DATE_TRUNC('month', s.start_date)::date
+							ELSE GREATEST(p.effective_from, DATE_TRUNC('month', s.start_date)::date)
+						END AS segment_start,
+						(LEAD(p.effective_from) OVER w - INTERVAL '1 month')::date AS segment_end,
+						DATE_TRUNC('month', s.end_date)::date AS end_month
+					FROM subscriptions s
+					JOIN subscription_prices p ON p.subscription_id = s.id
+					WHERE s.deleted_at IS NULL
+						AND DATE_TRUNC('month', s.start_date)::date <= $2::date
+						AND (s.end_date IS NULL OR DATE_TRUNC('month', s.end_date)::date >= $1::date)
+						AND ($3::uuid IS NULL OR s.user_id = $3::uuid)
+						AND ($4 = '' OR s.service_name = $4)
+					WINDOW w AS (PARTITION BY s.id ORDER BY p.effective_from)
+				) AS segments
+			) AS bounded
+			WHERE period_start <= period_end
+		) AS overlapping
+		ORDER BY id, period_start;
+	`,
+}
+
+var sqliteDialect = dialect{
+	activeIn: `start_date <= $%[1]d AND (end_date IS NULL OR end_date >= $%[1]d)`,
+	cursor: func(sort sortColumn, comparison string, valueArg, idArg int) string {
+		return fmt.Sprintf("(%s, id) %s ($%d, $%d)", sort.column, comparison, valueArg, idArg)
+	},
+	timestamp: func(t time.Time) any {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	},
+	rateLimitRefill: `MIN($%[3]d, %[1]s + (julianday('now') - julianday(%[2]s)) * 86400 * $%[4]d)`,
+	preciseNow:      `strftime('%Y-%m-%d %H:%M:%f', 'now')`,
+	uniquenessViolation: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		if !errors.As(err, &sqliteErr) {
+			return false
+		}
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_TRIGGER && strings.Contains(sqliteErr.Error(), "subscriptions_no_overlap")
+	},
+	sumItems: `
+		SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+			period_start, period_end, months
+		FROM (
+			SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+				period_start, period_end,
+				(CAST(strftime('%Y', period_end) AS INTEGER) * 12 + CAST(strftime('%m', period_end) AS INTEGER)) -
+				(CAST(strftime('%Y', period_start) AS INTEGER) * 12 + CAST(strftime('%m', period_start) AS INTEGER)) + 1 AS months
+			FROM (
+				SELECT id, user_id, service_name, price, currency, billing_period, billing_interval, start_month,
+					MAX(segment_start, $1) AS period_start,
+					MIN(COALESCE(segment_end, $2), COALESCE(end_date, $2), $2) AS period_end
+				FROM (
+					SELECT s.id, s.user_id, s.service_name, p.price, s.currency, s.billing_period, s.billing_interval,
+						s.start_date AS start_month,
+						CASE WHEN ROW_NUMBER() OVER w = 1 THEN s.start_date
+							ELSE MAX(p.effective_from, s.start_date)
+						END AS segment_start,
+						date(LEAD(p.effective_from) OVER w, '-1 month') AS segment_end,
+						s.end_date
+					FROM subscriptions s
+					JOIN subscription_prices p ON p.subscription_id = s.id
+					WHERE s.deleted_at IS NULL
+						AND s.start_date <= $2
+						AND (s.end_date IS NULL OR s.end_date >= $1)
+						AND ($3 IS NULL OR s.user_id = $3)
+						AND ($4 = '' OR s.service_name = $4)
+					WINDOW w AS (PARTITION BY s.id ORDER BY p.effective_from)
+				) AS segments
+			) AS bounded
+			WHERE period_start <= period_end
+		) AS overlapping
+		ORDER BY id, period_start;
+	`,
+}
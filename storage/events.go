@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+var ErrNoEvents = errors.New("no subscription events found")
+
+const eventColumns = `id, subscription_id, action, actor, request_id, before_state, after_state, created_at`
+
+// recordEvent добавляет запись в журнал изменений в рамках транзакции изменения
+func (s *SQLStorage) recordEvent(ctx context.Context, q querier, action string, subscriptionID int, before, after *models.Subscription) error {
+	event, err := models.NewSubscriptionEvent(ctx, action, subscriptionID, before, after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO subscription_events (subscription_id, action, actor, request_id, before_state, after_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP);
+	`
+
+	_, err = q.ExecContext(ctx, query,
+		event.SubscriptionID,
+		event.Action,
+		event.Actor,
+		event.RequestID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+	)
+	return err
+}
+
+// GetHistory возвращает журнал изменений подписки в хронологическом порядке
+func (s *SQLStorage) GetHistory(ctx context.Context, subscriptionID int) ([]models.SubscriptionEvent, error) {
+	query := `
+		SELECT ` + eventColumns + `
+		FROM subscription_events
+		WHERE subscription_id = $1
+		ORDER BY id;
+	`
+
+	events, err := s.queryEvents(ctx, query, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrNoEvents
+	}
+
+	return events, nil
+}
+
+// GetEvents возвращает страницу журнала изменений от новых событий к старым
+func (s *SQLStorage) GetEvents(ctx context.Context, filter models.EventFilter) (models.EventPage, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.SubscriptionID > 0 {
+		where("subscription_id = $%d", filter.SubscriptionID)
+	}
+	if filter.Actor != "" {
+		where("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= $%d", s.dialect.timestamp(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < $%d", s.dialect.timestamp(filter.Until))
+	}
+	if filter.BeforeID > 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := `
+		SELECT ` + eventColumns + `
+		FROM subscription_events`
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d;`, len(args))
+
+	events, err := s.queryEvents(ctx, query, args...)
+	if err != nil {
+		return models.EventPage{}, err
+	}
+	if len(events) == 0 {
+		return models.EventPage{}, ErrNoEvents
+	}
+
+	return eventPage(events, filter.Limit), nil
+}
+
+func (s *SQLStorage) queryEvents(ctx context.Context, query string, args ...any) ([]models.SubscriptionEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.SubscriptionEvent
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func scanEvent(row rowScanner) (models.SubscriptionEvent, error) {
+	var event models.SubscriptionEvent
+	var before, after []byte
+	var createdAt timestamp
+
+	err := row.Scan(
+		&event.ID,
+		&event.SubscriptionID,
+		&event.Action,
+		&event.Actor,
+		&event.RequestID,
+		&before,
+		&after,
+		&createdAt,
+	)
+	if err != nil {
+		return models.SubscriptionEvent{}, err
+	}
+
+	event.Before = before
+	event.After = after
+	event.CreatedAt = time.Time(createdAt).UTC()
+
+	return event, nil
+}
+
+// eventPage обрезает выборку из limit+1 событий до страницы и строит курсор следующей
+func eventPage(events []models.SubscriptionEvent, limit int) models.EventPage {
+	page := models.EventPage{Items: events}
+	if len(events) > limit {
+		page.Items = events[:limit]
+		page.NextCursor = strconv.FormatInt(page.Items[len(page.Items)-1].ID, 10)
+	}
+	return page
+}
+
+func nullableJSON(data []byte) any {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+var ErrIdempotencyKeyContended = errors.New("idempotency key is contended, retry later")
+
+// reserveAttempts ограничивает повторы резервирования, когда чужой ключ освобождается между вставкой и чтением
+const reserveAttempts = 3
+
+// ReserveIdempotencyKey сохраняет ключ выполняемого запроса. Если у клиента уже есть действующий ключ
+// с тем же значением, возвращает его запись и ok = false; истёкший ключ занимается заново
+func (s *SQLStorage) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, now time.Time) (models.IdempotencyRecord, bool, error) {
+	insert := `
+		INSERT INTO idempotency_keys (owner, key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, $4)
+		ON CONFLICT (owner, key) DO UPDATE SET
+			request_hash = excluded.request_hash,
+			status = NULL,
+			headers = NULL,
+			body = NULL,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= $5
+		RETURNING owner;
+	`
+	query := `
+		SELECT request_hash, status, headers, body, expires_at
+		FROM idempotency_keys
+		WHERE owner = $1 AND key = $2;
+	`
+
+	for range reserveAttempts {
+		var owner string
+		err := s.db.QueryRowContext(ctx, insert, record.Owner, record.Key, record.RequestHash,
+			s.dialect.timestamp(record.ExpiresAt), s.dialect.timestamp(now)).Scan(&owner)
+		if err == nil {
+			return record, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return models.IdempotencyRecord{}, false, err
+		}
+
+		existing, err := scanIdempotencyRecord(s.db.QueryRowContext(ctx, query, record.Owner, record.Key))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return models.IdempotencyRecord{}, false, err
+		}
+		existing.Owner = record.Owner
+		existing.Key = record.Key
+		return existing, false, nil
+	}
+
+	return models.IdempotencyRecord{}, false, ErrIdempotencyKeyContended
+}
+
+// CompleteIdempotencyKey сохраняет ответ на запрос, выполненный с ключом
+func (s *SQLStorage) CompleteIdempotencyKey(ctx context.Context, owner, key string, response models.StoredResponse) error {
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, headers = $4, body = $5
+		WHERE owner = $1 AND key = $2;
+	`
+
+	_, err = s.db.ExecContext(ctx, query, owner, key, response.Status, string(headers), response.Body)
+	return err
+}
+
+// ReleaseIdempotencyKey освобождает ключ запроса, ответ на который сохранять не нужно
+func (s *SQLStorage) ReleaseIdempotencyKey(ctx context.Context, owner, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE owner = $1 AND key = $2 AND status IS NULL;
+	`
+
+	_, err := s.db.ExecContext(ctx, query, owner, key)
+	return err
+}
+
+// PurgeIdempotencyKeys удаляет ключи, истёкшие до expiredBefore
+func (s *SQLStorage) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < $1;
+	`
+
+	result, err := s.db.ExecContext(ctx, query, s.dialect.timestamp(expiredBefore))
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+func scanIdempotencyRecord(row rowScanner) (models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	var status sql.NullInt64
+	var headers sql.NullString
+	var body []byte
+	var expiresAt timestamp
+
+	if err := row.Scan(&record.RequestHash, &status, &headers, &body, &expiresAt); err != nil {
+		return models.IdempotencyRecord{}, err
+	}
+	record.ExpiresAt = time.Time(expiresAt).UTC()
+	if status.Valid {
+		response := &models.StoredResponse{Status: int(status.Int64), Body: body}
+		if err := json.Unmarshal([]byte(headers.String), &response.Headers); err != nil {
+			return models.IdempotencyRecord{}, err
+		}
+		record.Response = response
+	}
+
+	return record, nil
+}
+
+type idempotencyKey struct {
+	owner string
+	key   string
+}
+
+func (s *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, now time.Time) (models.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{owner: record.Owner, key: record.Key}
+	if existing, ok := s.idempotencyKeys[id]; ok && existing.ExpiresAt.After(now) {
+		return cloneIdempotencyRecord(existing), false, nil
+	}
+
+	record.Response = nil
+	s.idempotencyKeys[id] = record
+	return record, true, nil
+}
+
+func (s *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, owner, key string, response models.StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{owner: owner, key: key}
+	record, ok := s.idempotencyKeys[id]
+	if !ok {
+		return nil
+	}
+	record.Response = &response
+	s.idempotencyKeys[id] = cloneIdempotencyRecord(record)
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, owner, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{owner: owner, key: key}
+	if record, ok := s.idempotencyKeys[id]; ok && record.Response == nil {
+		delete(s.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, record := range s.idempotencyKeys {
+		if record.ExpiresAt.Before(expiredBefore) {
+			delete(s.idempotencyKeys, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func cloneIdempotencyRecord(record models.IdempotencyRecord) models.IdempotencyRecord {
+	if record.Response != nil {
+		response := *record.Response
+		response.Headers = maps.Clone(response.Headers)
+		response.Body = slices.Clone(response.Body)
+		record.Response = &response
+	}
+	return record
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"subscription-aggregator-api/models"
+)
+
+// Import сохраняет подписки одной транзакцией вместе с их начальными ценами и записями журнала о создании.
+// Если СУБД поддерживает COPY, строки загружаются через него, иначе вставляются по одной
+func (s *SQLStorage) Import(ctx context.Context, subscriptions []models.Subscription) (int, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if s.dialect.copyIn == nil {
+			for _, subscription := range subscriptions {
+				if _, err := s.create(ctx, tx, subscription); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return s.copySubscriptions(ctx, tx, subscriptions)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(subscriptions), nil
+}
+
+// copySubscriptions загружает подписки через COPY во временную таблицу и переносит их в subscriptions,
+// чтобы получить присвоенные ID; цены и записи журнала для созданных подписок тоже загружаются через COPY
+func (s *SQLStorage) copySubscriptions(ctx context.Context, tx *sql.Tx, subscriptions []models.Subscription) error {
+	staging := `
+		CREATE TEMP TABLE subscription_import (
+			service_name TEXT,
+			user_id UUID,
+			price INT,
+			currency CHAR(3),
+			start_date DATE,
+			end_date DATE,
+			billing_period TEXT,
+			billing_interval INT,
+			no_overlap BOOLEAN
+		) ON COMMIT DROP;
+	`
+	insert := `
+		INSERT INTO subscriptions (service_name, user_id, price, currency, start_date, end_date, billing_period, billing_interval, no_overlap, created_at, updated_at)
+		SELECT service_name, user_id, price, currency, start_date, end_date, billing_period, billing_interval, no_overlap, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM subscription_import
+		RETURNING ` + subscriptionColumns + ";"
+
+	if _, err := tx.ExecContext(ctx, staging); err != nil {
+		return err
+	}
+
+	rows := make([][]any, len(subscriptions))
+	for i, subscription := range subscriptions {
+		rows[i] = []any{
+			subscription.ServiceName,
+			subscription.UserID,
+			subscription.Price,
+			subscription.Currency,
+			subscription.StartDate,
+			subscription.EndDate,
+			subscription.BillingPeriod,
+			subscription.BillingInterval,
+			subscription.NoOverlap,
+		}
+	}
+	err := s.copyRows(ctx, tx, "subscription_import", []string{
+		"service_name", "user_id", "price", "currency", "start_date", "end_date", "billing_period", "billing_interval", "no_overlap",
+	}, rows)
+	if err != nil {
+		return err
+	}
+
+	created, err := s.queryCreated(ctx, tx, insert)
+	if err != nil {
+		return s.uniquenessError(err, models.Subscription{})
+	}
+
+	prices := make([][]any, len(created))
+	events := make([][]any, len(created))
+	for i, subscription := range created {
+		prices[i] = []any{subscription.ID, subscription.Price, subscription.StartDate}
+
+		event, err := models.NewSubscriptionEvent(ctx, models.EventActionCreate, subscription.ID, nil, &subscription)
+		if err != nil {
+			return err
+		}
+		events[i] = []any{event.SubscriptionID, event.Action, event.Actor, event.RequestID, string(event.After)}
+	}
+
+	if err := s.copyRows(ctx, tx, "subscription_prices", []string{"subscription_id", "price", "effective_from"}, prices); err != nil {
+		return err
+	}
+	return s.copyRows(ctx, tx, "subscription_events", []string{"subscription_id", "action", "actor", "request_id", "after_state"}, events)
+}
+
+func (s *SQLStorage) queryCreated(ctx context.Context, tx *sql.Tx, query string) ([]models.Subscription, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var created []models.Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, subscription)
+	}
+
+	return created, rows.Err()
+}
+
+// copyRows загружает строки в таблицу через COPY FROM STDIN
+func (s *SQLStorage) copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, s.dialect.copyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// Import сохраняет подписки под одной блокировкой; при ошибке не сохраняется ни одна из них
+func (s *MemoryStorage) Import(ctx context.Context, subscriptions []models.Subscription) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.snapshot()
+	for _, subscription := range subscriptions {
+		if _, err := s.create(ctx, subscription); err != nil {
+			s.restore(snapshot)
+			return 0, err
+		}
+	}
+
+	return len(subscriptions), nil
+}
@@ -0,0 +1,634 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"subscription-aggregator-api/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MemoryStorage struct {
+	mu            sync.RWMutex
+	subscriptions map[int]models.Subscription
+	lastID        int
+	events        []models.SubscriptionEvent
+	// prices хранит историю цен каждой подписки по возрастанию EffectiveFrom
+	prices map[int][]models.PriceChange
+	rates  []models.ExchangeRate
+	// apiKeys хранит ключи доступа по ID, apiKeyHashes — ID ключа по хешу
+	apiKeys      map[int]models.APIKey
+	apiKeyHashes map[string]int
+	lastAPIKeyID int
+	// idempotencyKeys хранит ключи идемпотентности по клиенту и значению ключа
+	idempotencyKeys map[idempotencyKey]models.IdempotencyRecord
+}
+
+func NewMemory() *MemoryStorage {
+	return &MemoryStorage{
+		subscriptions:   make(map[int]models.Subscription),
+		prices:          make(map[int][]models.PriceChange),
+		apiKeys:         make(map[int]models.APIKey),
+		apiKeyHashes:    make(map[string]int),
+		idempotencyKeys: make(map[idempotencyKey]models.IdempotencyRecord),
+	}
+}
+
+func (s *MemoryStorage) Create(ctx context.Context, subscription models.Subscription) (models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(ctx, subscription)
+}
+
+// create сохраняет новую подписку; вызывается под s.mu
+func (s *MemoryStorage) create(ctx context.Context, subscription models.Subscription) (models.Subscription, error) {
+	subscription.ID = 0
+	if err := s.checkConstraints(subscription); err != nil {
+		return models.Subscription{}, err
+	}
+
+	now := time.Now().UTC()
+	s.lastID++
+	subscription.ID = s.lastID
+	subscription.Version = 1
+	subscription.CreatedAt = now
+	subscription.UpdatedAt = now
+	if err := s.recordEvent(ctx, models.EventActionCreate, subscription.ID, nil, &subscription); err != nil {
+		s.lastID--
+		return models.Subscription{}, err
+	}
+	s.subscriptions[subscription.ID] = cloneSubscription(subscription)
+	s.resetPrices(subscription)
+
+	return subscription, nil
+}
+
+func (s *MemoryStorage) GetByID(ctx context.Context, id int) (models.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.subscriptions[id]
+	if !ok || subscription.DeletedAt != nil {
+		return models.Subscription{}, ErrSubscriptionNotFound
+	}
+
+	return cloneSubscription(subscription), nil
+}
+
+func (s *MemoryStorage) FindByID(ctx context.Context, id int) (models.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return models.Subscription{}, ErrSubscriptionNotFound
+	}
+
+	return cloneSubscription(subscription), nil
+}
+
+func (s *MemoryStorage) GetOwner(ctx context.Context, id int) (uuid.UUID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return uuid.Nil, ErrSubscriptionNotFound
+	}
+
+	return subscription.UserID, nil
+}
+
+func (s *MemoryStorage) GetList(ctx context.Context, filter models.ListFilter) (models.SubscriptionPage, error) {
+	sort, ok := sortColumns[filter.Sort]
+	if !ok {
+		sort = sortColumns[models.SortByID]
+	}
+	compare := listComparator(filter)
+
+	var after *models.Subscription
+	if filter.Cursor != nil {
+		cursorSubscription, err := subscriptionFromCursor(filter.Sort, *filter.Cursor)
+		if err != nil {
+			return models.SubscriptionPage{}, err
+		}
+		after = &cursorSubscription
+	}
+
+	s.mu.RLock()
+	var subscriptions []models.Subscription
+	for _, subscription := range s.subscriptions {
+		if !matchesListFilter(subscription, filter) {
+			continue
+		}
+		if after != nil && compare(subscription, *after) <= 0 {
+			continue
+		}
+		subscriptions = append(subscriptions, cloneSubscription(subscription))
+	}
+	s.mu.RUnlock()
+
+	if len(subscriptions) == 0 {
+		return models.SubscriptionPage{}, ErrNoSubscriptions
+	}
+
+	slices.SortFunc(subscriptions, compare)
+
+	page := models.SubscriptionPage{Items: subscriptions}
+	if len(subscriptions) > filter.Limit {
+		page.Items = subscriptions[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = models.ListCursor{
+			Sort:  filter.Sort,
+			Order: filter.Order,
+			Value: sort.value(last),
+			ID:    last.ID,
+		}.Encode()
+	}
+
+	return page, nil
+}
+
+// Iterate возвращает подписки по фильтру списка без разбиения на страницы; подписки копируются
+// под блокировкой, поэтому обход не мешает изменениям
+func (s *MemoryStorage) Iterate(ctx context.Context, filter models.ListFilter) iter.Seq2[models.Subscription, error] {
+	return func(yield func(models.Subscription, error) bool) {
+		s.mu.RLock()
+		var subscriptions []models.Subscription
+		for _, subscription := range s.subscriptions {
+			if matchesListFilter(subscription, filter) {
+				subscriptions = append(subscriptions, cloneSubscription(subscription))
+			}
+		}
+		s.mu.RUnlock()
+
+		slices.SortFunc(subscriptions, listComparator(filter))
+		for _, subscription := range subscriptions {
+			if err := ctx.Err(); err != nil {
+				yield(models.Subscription{}, err)
+				return
+			}
+			if !yield(subscription, nil) {
+				return
+			}
+		}
+	}
+}
+
+// listComparator возвращает функцию сравнения подписок по сортировке и направлению фильтра
+func listComparator(filter models.ListFilter) func(a, b models.Subscription) int {
+	compare := sortComparators[filter.Sort]
+	if compare == nil {
+		compare = sortComparators[models.SortByID]
+	}
+	if filter.Order == models.OrderDesc {
+		ascending := compare
+		compare = func(a, b models.Subscription) int { return ascending(b, a) }
+	}
+	return compare
+}
+
+func (s *MemoryStorage) Update(ctx context.Context, id int, updatedSubscription models.Subscription, expectedVersion int) (models.Subscription, error) {
+	return s.Patch(ctx, id, expectedVersion, func(models.Subscription) (models.Subscription, error) {
+		return updatedSubscription, nil
+	})
+}
+
+func (s *MemoryStorage) Patch(ctx context.Context, id int, expectedVersion int, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.patch(ctx, id, expectedVersion, apply)
+}
+
+// patch применяет apply к подписке и сохраняет результат; вызывается под s.mu
+func (s *MemoryStorage) patch(ctx context.Context, id int, expectedVersion int, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	current, ok := s.subscriptions[id]
+	if !ok || current.DeletedAt != nil {
+		return models.Subscription{}, ErrSubscriptionNotFound
+	}
+	if expectedVersion > 0 && current.Version != expectedVersion {
+		return models.Subscription{}, ErrVersionMismatch
+	}
+
+	patched, err := apply(cloneSubscription(current))
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	prices := s.prices[id]
+	if patched.Price != current.Price {
+		prices = withPrice(prices, models.PriceChange{Price: patched.Price, EffectiveFrom: priceChangeMonth(patched, time.Now()), CreatedAt: time.Now().UTC()})
+		patched.Price = prices[len(prices)-1].Price
+	}
+
+	updated, err := s.replace(ctx, models.EventActionUpdate, current, patched)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	s.prices[id] = prices
+
+	return updated, nil
+}
+
+func (s *MemoryStorage) ChangePrice(ctx context.Context, id int, expectedVersion int, effectiveFrom models.Month, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.subscriptions[id]
+	if !ok || current.DeletedAt != nil {
+		return models.Subscription{}, ErrSubscriptionNotFound
+	}
+	if expectedVersion > 0 && current.Version != expectedVersion {
+		return models.Subscription{}, ErrVersionMismatch
+	}
+
+	changed, err := apply(cloneSubscription(current))
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	var prices []models.PriceChange
+	if effectiveFrom.IsZero() {
+		prices = []models.PriceChange{{Price: changed.Price, EffectiveFrom: changed.StartDate, CreatedAt: time.Now().UTC()}}
+	} else {
+		prices = withPrice(s.prices[id], models.PriceChange{Price: changed.Price, EffectiveFrom: effectiveFrom, CreatedAt: time.Now().UTC()})
+	}
+	changed.Price = prices[len(prices)-1].Price
+
+	updated, err := s.replace(ctx, models.EventActionPriceChange, current, changed)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	s.prices[id] = prices
+
+	return updated, nil
+}
+
+func (s *MemoryStorage) GetPrices(ctx context.Context, id int) ([]models.PriceChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.subscriptions[id]
+	if !ok || subscription.DeletedAt != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	return slices.Clone(s.prices[id]), nil
+}
+
+// withPrice возвращает копию истории цен prices с ценой change, заменяющей цену того же месяца
+func withPrice(prices []models.PriceChange, change models.PriceChange) []models.PriceChange {
+	prices = slices.Clone(prices)
+	index, found := slices.BinarySearchFunc(prices, change.EffectiveFrom, func(p models.PriceChange, month models.Month) int {
+		return p.EffectiveFrom.Time().Compare(month.Time())
+	})
+	if found {
+		prices[index] = change
+	} else {
+		prices = slices.Insert(prices, index, change)
+	}
+	return prices
+}
+
+// resetPrices заменяет историю цен одной ценой с начала подписки; вызывается под s.mu
+func (s *MemoryStorage) resetPrices(subscription models.Subscription) {
+	s.prices[subscription.ID] = []models.PriceChange{{
+		Price:         subscription.Price,
+		EffectiveFrom: subscription.StartDate,
+		CreatedAt:     time.Now().UTC(),
+	}}
+}
+
+// replace сохраняет новую версию подписки и запись журнала о ней; вызывается под s.mu
+func (s *MemoryStorage) replace(ctx context.Context, action string, current, updated models.Subscription) (models.Subscription, error) {
+	updated.ID = current.ID
+	if err := s.checkConstraints(updated); err != nil {
+		return models.Subscription{}, err
+	}
+	updated.Version = current.Version + 1
+	updated.CreatedAt = current.CreatedAt
+	updated.UpdatedAt = time.Now().UTC()
+	if err := s.recordEvent(ctx, action, current.ID, &current, &updated); err != nil {
+		return models.Subscription{}, err
+	}
+	s.subscriptions[current.ID] = cloneSubscription(updated)
+
+	return updated, nil
+}
+
+// recordEvent добавляет запись в журнал изменений; вызывается под s.mu
+func (s *MemoryStorage) recordEvent(ctx context.Context, action string, subscriptionID int, before, after *models.Subscription) error {
+	event, err := models.NewSubscriptionEvent(ctx, action, subscriptionID, before, after)
+	if err != nil {
+		return err
+	}
+	event.ID = int64(len(s.events) + 1)
+	event.CreatedAt = time.Now().UTC()
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(ctx, id)
+}
+
+// delete помечает подписку удалённой; вызывается под s.mu
+func (s *MemoryStorage) delete(ctx context.Context, id int) error {
+	current, ok := s.subscriptions[id]
+	if !ok || current.DeletedAt != nil {
+		return ErrSubscriptionNotFound
+	}
+
+	deleted := cloneSubscription(current)
+	deletedAt := time.Now().UTC()
+	deleted.DeletedAt = &deletedAt
+	_, err := s.replace(ctx, models.EventActionDelete, current, deleted)
+
+	return err
+}
+
+func (s *MemoryStorage) Restore(ctx context.Context, id int, noOverlap bool) (models.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.subscriptions[id]
+	if !ok {
+		return models.Subscription{}, ErrSubscriptionNotFound
+	}
+	if current.DeletedAt == nil {
+		return models.Subscription{}, ErrNotDeleted
+	}
+
+	restored := cloneSubscription(current)
+	restored.DeletedAt = nil
+	restored.NoOverlap = noOverlap
+
+	return s.replace(ctx, models.EventActionRestore, current, restored)
+}
+
+func (s *MemoryStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, subscription := range s.subscriptions {
+		if subscription.DeletedAt != nil && subscription.DeletedAt.Before(deletedBefore) {
+			if err := s.recordEvent(ctx, models.EventActionPurge, id, &subscription, nil); err != nil {
+				return purged, err
+			}
+			delete(s.subscriptions, id)
+			delete(s.prices, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (s *MemoryStorage) GetSumItems(ctx context.Context, filter models.SumFilter) ([]models.SumItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := []models.SumItem{}
+
+	for _, subscription := range s.subscriptions {
+		if subscription.DeletedAt != nil {
+			continue
+		}
+		if filter.UserID.Valid && subscription.UserID != filter.UserID.UUID {
+			continue
+		}
+		if filter.ServiceName != "" && subscription.ServiceName != filter.ServiceName {
+			continue
+		}
+
+		items = append(items, priceSegments(subscription, s.prices[subscription.ID], filter)...)
+	}
+
+	slices.SortFunc(items, func(a, b models.SumItem) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), a.From.Time().Compare(b.From.Time()))
+	})
+
+	return items, nil
+}
+
+func (s *MemoryStorage) GetHistory(ctx context.Context, subscriptionID int) ([]models.SubscriptionEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.SubscriptionEvent
+	for _, event := range s.events {
+		if event.SubscriptionID == subscriptionID {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return nil, ErrNoEvents
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStorage) GetEvents(ctx context.Context, filter models.EventFilter) (models.EventPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.SubscriptionEvent
+	for i := len(s.events) - 1; i >= 0 && len(events) <= filter.Limit; i-- {
+		event := s.events[i]
+		if matchesEventFilter(event, filter) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return models.EventPage{}, ErrNoEvents
+	}
+
+	return eventPage(events, filter.Limit), nil
+}
+
+// priceSegments делит пересечение подписки с периодом фильтра на отрезки с постоянной ценой
+func priceSegments(subscription models.Subscription, prices []models.PriceChange, filter models.SumFilter) []models.SumItem {
+	var items []models.SumItem
+
+	for i, price := range prices {
+		segmentStart := subscription.StartDate
+		if i > 0 && price.EffectiveFrom.After(segmentStart) {
+			segmentStart = price.EffectiveFrom
+		}
+		if filter.From.After(segmentStart) {
+			segmentStart = filter.From
+		}
+
+		segmentEnd := filter.To
+		if subscription.EndDate != nil && subscription.EndDate.Before(segmentEnd) {
+			segmentEnd = *subscription.EndDate
+		}
+		if i+1 < len(prices) {
+			if beforeNext := prices[i+1].EffectiveFrom.AddMonths(-1); beforeNext.Before(segmentEnd) {
+				segmentEnd = beforeNext
+			}
+		}
+
+		if segmentEnd.Before(segmentStart) {
+			continue
+		}
+
+		items = append(items, models.SumItem{
+			ID:              subscription.ID,
+			UserID:          subscription.UserID,
+			ServiceName:     subscription.ServiceName,
+			Price:           price.Price,
+			Currency:        subscription.Currency,
+			From:            segmentStart,
+			To:              segmentEnd,
+			Months:          segmentStart.MonthsUntil(segmentEnd),
+			BillingPeriod:   subscription.BillingPeriod,
+			BillingInterval: subscription.BillingInterval,
+			StartDate:       subscription.StartDate,
+		})
+	}
+
+	return items
+}
+
+func (s *MemoryStorage) SaveExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate.CreatedAt = time.Now().UTC()
+	index, found := slices.BinarySearchFunc(s.rates, rate, compareExchangeRates)
+	if found {
+		s.rates[index] = rate
+	} else {
+		s.rates = slices.Insert(s.rates, index, rate)
+	}
+
+	return rate, nil
+}
+
+func (s *MemoryStorage) GetExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rates := []models.ExchangeRate{}
+	for _, rate := range s.rates {
+		if currency == "" || rate.Currency == currency {
+			rates = append(rates, rate)
+		}
+	}
+
+	return rates, nil
+}
+
+func compareExchangeRates(a, b models.ExchangeRate) int {
+	return cmp.Or(strings.Compare(a.Currency, b.Currency), a.EffectiveFrom.Time().Compare(b.EffectiveFrom.Time()))
+}
+
+var sortComparators = map[string]func(a, b models.Subscription) int{
+	models.SortByID: func(a, b models.Subscription) int {
+		return cmp.Compare(a.ID, b.ID)
+	},
+	models.SortByPrice: func(a, b models.Subscription) int {
+		return cmp.Or(cmp.Compare(a.Price, b.Price), cmp.Compare(a.ID, b.ID))
+	},
+	models.SortByStartDate: func(a, b models.Subscription) int {
+		return cmp.Or(a.StartDate.Time().Compare(b.StartDate.Time()), cmp.Compare(a.ID, b.ID))
+	},
+	models.SortByServiceName: func(a, b models.Subscription) int {
+		return cmp.Or(strings.Compare(a.ServiceName, b.ServiceName), cmp.Compare(a.ID, b.ID))
+	},
+}
+
+func subscriptionFromCursor(sort string, cursor models.ListCursor) (models.Subscription, error) {
+	subscription := models.Subscription{ID: cursor.ID}
+
+	switch sort {
+	case models.SortByPrice:
+		price, err := strconv.Atoi(cursor.Value)
+		if err != nil {
+			return models.Subscription{}, models.ErrInvalidCursor
+		}
+		subscription.Price = price
+	case models.SortByStartDate:
+		startDate, err := time.Parse("2006-01-02", cursor.Value)
+		if err != nil {
+			return models.Subscription{}, models.ErrInvalidCursor
+		}
+		subscription.StartDate = models.MonthOf(startDate)
+	case models.SortByServiceName:
+		subscription.ServiceName = cursor.Value
+	}
+
+	return subscription, nil
+}
+
+func matchesListFilter(subscription models.Subscription, filter models.ListFilter) bool {
+	if subscription.DeletedAt != nil && !filter.IncludeDeleted {
+		return false
+	}
+	if filter.UserID.Valid && subscription.UserID != filter.UserID.UUID {
+		return false
+	}
+	if filter.ServiceName != "" && subscription.ServiceName != filter.ServiceName {
+		return false
+	}
+	if filter.ServiceNamePrefix != "" && !strings.HasPrefix(subscription.ServiceName, filter.ServiceNamePrefix) {
+		return false
+	}
+	if filter.PriceMin > 0 && subscription.Price < filter.PriceMin {
+		return false
+	}
+	if filter.PriceMax > 0 && subscription.Price > filter.PriceMax {
+		return false
+	}
+	if !filter.ActiveIn.IsZero() && !subscription.ActiveIn(filter.ActiveIn) {
+		return false
+	}
+	return true
+}
+
+func matchesEventFilter(event models.SubscriptionEvent, filter models.EventFilter) bool {
+	if filter.SubscriptionID > 0 && event.SubscriptionID != filter.SubscriptionID {
+		return false
+	}
+	if filter.Actor != "" && event.Actor != filter.Actor {
+		return false
+	}
+	if filter.Action != "" && event.Action != filter.Action {
+		return false
+	}
+	if !filter.Since.IsZero() && event.CreatedAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until) {
+		return false
+	}
+	if filter.BeforeID > 0 && event.ID >= filter.BeforeID {
+		return false
+	}
+	return true
+}
+
+func cloneSubscription(subscription models.Subscription) models.Subscription {
+	if subscription.EndDate != nil {
+		endDate := *subscription.EndDate
+		subscription.EndDate = &endDate
+	}
+	if subscription.DeletedAt != nil {
+		deletedAt := *subscription.DeletedAt
+		subscription.DeletedAt = &deletedAt
+	}
+	return subscription
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/storage"
+	"subscription-aggregator-api/storage/storagetest"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) manager.SubscriptionStorage {
+		return storage.NewMemory()
+	})
+}
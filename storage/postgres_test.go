@@ -0,0 +1,81 @@
+package storage_test
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/db"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/storage"
+	"subscription-aggregator-api/storage/storagetest"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// postgresDSNEnv задаёт строку подключения к отдельной тестовой базе PostgreSQL.
+// Перед каждой проверкой все таблицы базы очищаются.
+const postgresDSNEnv = "STORAGE_TEST_POSTGRES_DSN"
+
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	conn, err := sql.Open(config.DBTypePostgres, dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("ping postgres: %v", err)
+	}
+
+	migrator, err := db.NewMigrator(conn, config.DBTypePostgres)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("apply migrations: %v", err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) manager.SubscriptionStorage {
+		truncateTables(t, conn)
+		return storage.NewSQL(conn)
+	})
+}
+
+// truncateTables очищает все таблицы текущей схемы, кроме журнала миграций, и сбрасывает счётчики ID
+func truncateTables(t *testing.T, conn *sql.DB) {
+	t.Helper()
+
+	rows, err := conn.Query(`
+		SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_versions';
+	`)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatalf("scan table name: %v", err)
+		}
+		tables = append(tables, pq.QuoteIdentifier(table))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	if len(tables) == 0 {
+		return
+	}
+
+	if _, err := conn.Exec(`TRUNCATE ` + strings.Join(tables, ", ") + ` RESTART IDENTITY CASCADE;`); err != nil {
+		t.Fatalf("truncate tables: %v", err)
+	}
+}
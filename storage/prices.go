@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+// ChangePrice сохраняет цену, которую apply задаёт подписке, как действующую с месяца effectiveFrom;
+// цены предыдущих месяцев не меняются, а в подписке остаётся цена последнего изменения.
+// Нулевой effectiveFrom заменяет всю историю одной ценой, действующей с начала подписки
+func (s *SQLStorage) ChangePrice(ctx context.Context, id int, expectedVersion int, effectiveFrom models.Month, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	var updated models.Subscription
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.getByID(ctx, tx, id, s.dialect.forUpdate)
+		if err != nil {
+			return err
+		}
+		if expectedVersion > 0 && current.Version != expectedVersion {
+			return ErrVersionMismatch
+		}
+
+		changed, err := apply(current)
+		if err != nil {
+			return err
+		}
+
+		changed.ID = id
+		if effectiveFrom.IsZero() {
+			err = s.resetPrices(ctx, tx, changed)
+		} else {
+			changed.Price, err = s.savePrice(ctx, tx, id, changed.Price, effectiveFrom)
+		}
+		if err != nil {
+			return err
+		}
+
+		if updated, err = s.update(ctx, tx, current, changed); err != nil {
+			return err
+		}
+		return s.recordEvent(ctx, tx, models.EventActionPriceChange, id, &current, &updated)
+	})
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	return updated, nil
+}
+
+// savePrice записывает цену, действующую с месяца effectiveFrom, и возвращает цену последнего изменения
+func (s *SQLStorage) savePrice(ctx context.Context, q querier, id int, price int, effectiveFrom models.Month) (int, error) {
+	upsert := `
+		INSERT INTO subscription_prices (subscription_id, price, effective_from, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (subscription_id, effective_from)
+		DO UPDATE SET price = EXCLUDED.price, created_at = EXCLUDED.created_at;
+	`
+	latest := `
+		SELECT price
+		FROM subscription_prices
+		WHERE subscription_id = $1
+		ORDER BY effective_from DESC
+		LIMIT 1;
+	`
+
+	if _, err := q.ExecContext(ctx, upsert, id, price, effectiveFrom); err != nil {
+		return 0, err
+	}
+	if err := q.QueryRowContext(ctx, latest, id).Scan(&price); err != nil {
+		return 0, err
+	}
+
+	return price, nil
+}
+
+// GetPrices возвращает историю цен подписки по возрастанию месяца начала действия
+func (s *SQLStorage) GetPrices(ctx context.Context, id int) ([]models.PriceChange, error) {
+	if _, err := s.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT price, effective_from, created_at
+		FROM subscription_prices
+		WHERE subscription_id = $1
+		ORDER BY effective_from;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []models.PriceChange{}
+	for rows.Next() {
+		var price models.PriceChange
+		var createdAt timestamp
+		if err := rows.Scan(&price.Price, &price.EffectiveFrom, &createdAt); err != nil {
+			return nil, err
+		}
+		price.CreatedAt = time.Time(createdAt).UTC()
+		prices = append(prices, price)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// resetPrices заменяет историю цен одной ценой, действующей с начала подписки
+func (s *SQLStorage) resetPrices(ctx context.Context, q querier, subscription models.Subscription) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM subscription_prices WHERE subscription_id = $1;`, subscription.ID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO subscription_prices (subscription_id, price, effective_from, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP);
+	`
+
+	_, err := q.ExecContext(ctx, query, subscription.ID, subscription.Price, subscription.StartDate)
+	return err
+}
+
+// priceChangeMonth возвращает месяц, с которого действует цена, изменённая без указания месяца:
+// текущий месяц, ограниченный периодом подписки
+func priceChangeMonth(subscription models.Subscription, now time.Time) models.Month {
+	month := models.MonthOf(now)
+	if month.Before(subscription.StartDate) {
+		return subscription.StartDate
+	}
+	if subscription.EndDate != nil && month.After(*subscription.EndDate) {
+		return *subscription.EndDate
+	}
+	return month
+}
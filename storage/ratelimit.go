@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TakeRateLimitToken пополняет ведро key со скоростью rate маркеров в секунду (не больше burst) и забирает
+// из него один маркер, если он есть. Возвращает оставшиеся маркеры и то, был ли маркер выдан.
+// Ведро обновляется одной командой, поэтому несколько экземпляров сервиса делят его без гонок
+func (s *SQLStorage) TakeRateLimitToken(ctx context.Context, key string, burst int, rate float64) (float64, bool, error) {
+	refill := fmt.Sprintf(s.dialect.rateLimitRefill, "b.tokens", "b.updated_at", 2, 3)
+	query := `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, CAST($2 AS DOUBLE PRECISION) - 1, TRUE, ` + s.dialect.preciseNow + `)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = ` + refill + ` - CASE WHEN ` + refill + ` >= 1 THEN 1 ELSE 0 END,
+			allowed = ` + refill + ` >= 1,
+			updated_at = ` + s.dialect.preciseNow + `
+		RETURNING tokens, allowed;
+	`
+
+	var tokens float64
+	var allowed bool
+	if err := s.db.QueryRowContext(ctx, query, key, float64(burst), rate).Scan(&tokens, &allowed); err != nil {
+		return 0, false, err
+	}
+
+	return tokens, allowed, nil
+}
+
+// PeekRateLimitTokens возвращает число маркеров в ведре key с учётом пополнения, не забирая маркер;
+// ведро, которого ещё нет, считается полным
+func (s *SQLStorage) PeekRateLimitTokens(ctx context.Context, key string, burst int, rate float64) (float64, error) {
+	refill := fmt.Sprintf(s.dialect.rateLimitRefill, "tokens", "updated_at", 2, 3)
+	query := `
+		SELECT ` + refill + `
+		FROM rate_limit_buckets
+		WHERE key = $1;
+	`
+
+	var tokens float64
+	err := s.db.QueryRowContext(ctx, query, key, float64(burst), rate).Scan(&tokens)
+	if errors.Is(err, sql.ErrNoRows) {
+		return float64(burst), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return tokens, nil
+}
+
+// PurgeRateLimitBuckets удаляет вёдра, не обновлявшиеся с idleBefore; такие вёдра уже полны,
+// и новое ведро при следующем запросе ничем от них не отличается
+func (s *SQLStorage) PurgeRateLimitBuckets(ctx context.Context, idleBefore time.Time) (int, error) {
+	query := `
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < $1;
+	`
+
+	result, err := s.db.ExecContext(ctx, query, s.dialect.timestamp(idleBefore))
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+const exchangeRateColumns = `currency, rate, effective_from, created_at`
+
+// SaveExchangeRate сохраняет курс валюты; курс на тот же месяц заменяется
+func (s *SQLStorage) SaveExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error) {
+	query := `
+		INSERT INTO exchange_rates (currency, rate, effective_from, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (currency, effective_from)
+		DO UPDATE SET rate = EXCLUDED.rate, created_at = EXCLUDED.created_at
+		RETURNING ` + exchangeRateColumns + ";"
+
+	return scanExchangeRate(s.db.QueryRowContext(ctx, query, rate.Currency, rate.Rate, rate.EffectiveFrom))
+}
+
+// GetExchangeRates возвращает курсы по валюте и месяцу начала действия; пустая currency — все валюты
+func (s *SQLStorage) GetExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error) {
+	query := `
+		SELECT ` + exchangeRateColumns + `
+		FROM exchange_rates
+		WHERE $1 = '' OR currency = $1
+		ORDER BY currency, effective_from;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.ExchangeRate{}
+	for rows.Next() {
+		rate, err := scanExchangeRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+func scanExchangeRate(row rowScanner) (models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	var createdAt timestamp
+
+	if err := row.Scan(&rate.Currency, &rate.Rate, &rate.EffectiveFrom, &createdAt); err != nil {
+		return models.ExchangeRate{}, err
+	}
+	rate.CreatedAt = time.Time(createdAt).UTC()
+
+	return rate, nil
+}
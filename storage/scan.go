@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"subscription-aggregator-api/models"
+	"time"
+)
+
+const subscriptionColumns = `id, user_id, service_name, price, currency, start_date, end_date, billing_period, billing_interval, version, created_at, updated_at, deleted_at, no_overlap`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row rowScanner) (models.Subscription, error) {
+	var subscription models.Subscription
+	var createdAt, updatedAt timestamp
+	var deletedAt *timestamp
+
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.UserID,
+		&subscription.ServiceName,
+		&subscription.Price,
+		&subscription.Currency,
+		&subscription.StartDate,
+		&subscription.EndDate,
+		&subscription.BillingPeriod,
+		&subscription.BillingInterval,
+		&subscription.Version,
+		&createdAt,
+		&updatedAt,
+		&deletedAt,
+		&subscription.NoOverlap,
+	)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	subscription.CreatedAt = time.Time(createdAt).UTC()
+	subscription.UpdatedAt = time.Time(updatedAt).UTC()
+	if deletedAt != nil {
+		deleted := time.Time(*deletedAt).UTC()
+		subscription.DeletedAt = &deleted
+	}
+
+	return subscription, nil
+}
+
+// timestamp читает время как из TIMESTAMPTZ PostgreSQL, так и из текстового представления SQLite
+type timestamp time.Time
+
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"}
+
+func (t *timestamp) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		*t = timestamp(v)
+		return nil
+	case string:
+		for _, layout := range timestampLayouts {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				*t = timestamp(parsed)
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot parse timestamp %q", v)
+	case []byte:
+		return t.Scan(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into timestamp", src)
+	}
+}
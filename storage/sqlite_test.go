@@ -0,0 +1,30 @@
+package storage_test
+
+import (
+	"subscription-aggregator-api/config"
+	"subscription-aggregator-api/db"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/storage"
+	"subscription-aggregator-api/storage/storagetest"
+	"testing"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) manager.SubscriptionStorage {
+		dm := db.NewDBManager()
+		if err := dm.InitDB(config.DBConfig{Type: config.DBTypeSQLite, Path: config.SQLiteInMemoryPath}); err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		t.Cleanup(func() { dm.Close() })
+
+		migrator, err := db.NewMigrator(dm.DB, config.DBTypeSQLite)
+		if err != nil {
+			t.Fatalf("load migrations: %v", err)
+		}
+		if _, err := migrator.Up(); err != nil {
+			t.Fatalf("apply migrations: %v", err)
+		}
+
+		return storage.NewSQLite(dm.DB)
+	})
+}
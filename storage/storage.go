@@ -1,66 +1,174 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
 	"subscription-aggregator-api/models"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 var (
 	ErrSubscriptionNotFound = errors.New("subscription not found")
 	ErrNoSubscriptions      = errors.New("no subscriptions found")
+	ErrVersionMismatch      = errors.New("subscription version does not match")
+	ErrNotDeleted           = errors.New("subscription is not deleted")
 )
 
+type sortColumn struct {
+	column string
+	cast   string
+	value  func(models.Subscription) string
+	parse  func(string) (any, error)
+}
+
+var sortColumns = map[string]sortColumn{
+	models.SortByID: {
+		column: "id",
+		cast:   "int",
+		value:  func(s models.Subscription) string { return strconv.Itoa(s.ID) },
+		parse:  parseIntCursorValue,
+	},
+	models.SortByPrice: {
+		column: "price",
+		cast:   "int",
+		value:  func(s models.Subscription) string { return strconv.Itoa(s.Price) },
+		parse:  parseIntCursorValue,
+	},
+	models.SortByStartDate: {
+		column: "start_date",
+		cast:   "date",
+		value:  func(s models.Subscription) string { return s.StartDate.Time().Format("2006-01-02") },
+		parse:  parseStringCursorValue,
+	},
+	models.SortByServiceName: {
+		column: "service_name",
+		cast:   "text",
+		value:  func(s models.Subscription) string { return s.ServiceName },
+		parse:  parseStringCursorValue,
+	},
+}
+
+func parseIntCursorValue(value string) (any, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	return parsed, nil
+}
+
+func parseStringCursorValue(value string) (any, error) {
+	return value, nil
+}
+
+// querier объединяет *sql.DB и *sql.Tx, чтобы запросы выполнялись как вне, так и внутри транзакции
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type SQLStorage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
 }
 
 func NewSQL(db *sql.DB) *SQLStorage {
 	return &SQLStorage{
-		db: db,
+		db:      db,
+		dialect: postgresDialect,
+	}
+}
+
+func NewSQLite(db *sql.DB) *SQLStorage {
+	return &SQLStorage{
+		db:      db,
+		dialect: sqliteDialect,
+	}
+}
+
+// Create сохраняет подписку, её начальную цену и запись журнала о создании в одной транзакции
+func (s *SQLStorage) Create(ctx context.Context, subscription models.Subscription) (models.Subscription, error) {
+	var created models.Subscription
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		created, err = s.create(ctx, tx, subscription)
+		return err
+	})
+	if err != nil {
+		return models.Subscription{}, err
 	}
+
+	return created, nil
 }
 
-func (s *SQLStorage) Create(subscription models.Subscription) error {
+func (s *SQLStorage) create(ctx context.Context, q querier, subscription models.Subscription) (models.Subscription, error) {
 	query := `
-		INSERT INTO subscriptions (service_name, user_id, price, start_date)
-		VALUES ($1, $2, $3, $4);
-	`
+		INSERT INTO subscriptions (service_name, user_id, price, currency, start_date, end_date, billing_period, billing_interval, no_overlap, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING ` + subscriptionColumns + ";"
 
-	result, err := s.db.Exec(query,
+	created, err := scanSubscription(q.QueryRowContext(ctx, query,
 		subscription.ServiceName,
 		subscription.UserID,
 		subscription.Price,
+		subscription.Currency,
 		subscription.StartDate,
-	)
-
+		subscription.EndDate,
+		subscription.BillingPeriod,
+		subscription.BillingInterval,
+		subscription.NoOverlap,
+	))
 	if err != nil {
-		return err
+		return models.Subscription{}, s.uniquenessError(err, subscription)
+	}
+	if err := s.resetPrices(ctx, q, created); err != nil {
+		return models.Subscription{}, err
 	}
+	if err := s.recordEvent(ctx, q, models.EventActionCreate, created.ID, nil, &created); err != nil {
+		return models.Subscription{}, err
+	}
+
+	return created, nil
+}
+
+func (s *SQLStorage) GetByID(ctx context.Context, id int) (models.Subscription, error) {
+	return s.getByID(ctx, s.db, id, "")
+}
 
-	rowsAffected, err := result.RowsAffected()
+// getByID возвращает неудалённую подписку; lock дописывается к запросу (например, FOR UPDATE)
+func (s *SQLStorage) getByID(ctx context.Context, q querier, id int, lock string) (models.Subscription, error) {
+	subscription, err := s.findByID(ctx, q, id, lock)
 	if err != nil {
-		return err
+		return models.Subscription{}, err
 	}
-	if rowsAffected == 0 {
-		return ErrSubscriptionNotFound
+	if subscription.DeletedAt != nil {
+		return models.Subscription{}, ErrSubscriptionNotFound
 	}
 
-	return nil
+	return subscription, nil
 }
 
-func (s *SQLStorage) GetByID(id int) (models.Subscription, error) {
-	var subscription models.Subscription
+// FindByID возвращает подписку, в том числе помеченную удалённой
+func (s *SQLStorage) FindByID(ctx context.Context, id int) (models.Subscription, error) {
+	return s.findByID(ctx, s.db, id, "")
+}
 
+// findByID возвращает подписку, в том числе помеченную удалённой
+func (s *SQLStorage) findByID(ctx context.Context, q querier, id int, lock string) (models.Subscription, error) {
 	query := `
-		SELECT id, user_id, service_name, price, start_date
+		SELECT ` + subscriptionColumns + `
 		FROM subscriptions
-		WHERE id = $1;
-	`
+		WHERE id = $1
+	` + lock + ";"
 
-	result := s.db.QueryRow(query, id)
-
-	err := result.Scan(&subscription.ID, &subscription.UserID, &subscription.ServiceName, &subscription.Price, &subscription.StartDate)
+	subscription, err := scanSubscription(q.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return models.Subscription{}, ErrSubscriptionNotFound
@@ -71,105 +179,414 @@ func (s *SQLStorage) GetByID(id int) (models.Subscription, error) {
 	return subscription, nil
 }
 
-func (s *SQLStorage) GetList() ([]models.Subscription, error) {
+// GetOwner возвращает пользователя подписки, в том числе помеченной удалённой
+func (s *SQLStorage) GetOwner(ctx context.Context, id int) (uuid.UUID, error) {
 	query := `
-		SELECT id, user_id, service_name, price, start_date
-		FROM subscriptions;
+		SELECT user_id
+		FROM subscriptions
+		WHERE id = $1;
 	`
 
-	rows, err := s.db.Query(query)
+	var userID uuid.UUID
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, ErrSubscriptionNotFound
+		}
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
+
+func (s *SQLStorage) GetList(ctx context.Context, filter models.ListFilter) (models.SubscriptionPage, error) {
+	subscriptions, sort, err := s.queryList(ctx, filter, filter.Limit+1)
 	if err != nil {
-		return nil, err
+		return models.SubscriptionPage{}, err
+	}
+
+	if len(subscriptions) == 0 {
+		return models.SubscriptionPage{}, ErrNoSubscriptions
+	}
+
+	page := models.SubscriptionPage{Items: subscriptions}
+	if len(subscriptions) > filter.Limit {
+		page.Items = subscriptions[:filter.Limit]
+		page.NextCursor = listCursor(filter, sort, page.Items[len(page.Items)-1]).Encode()
+	}
+
+	return page, nil
+}
+
+// iteratePageSize ограничивает число строк, которые Iterate читает из базы одним запросом
+const iteratePageSize = 500
+
+// Iterate возвращает подписки по фильтру списка без разбиения на страницы (Limit и Cursor не учитываются).
+// Строки читаются страницами по курсору, и соединение освобождается между страницами, поэтому медленный
+// обход не держит его занятым. Изменения, сделанные во время обхода, могут попасть в результат частично
+func (s *SQLStorage) Iterate(ctx context.Context, filter models.ListFilter) iter.Seq2[models.Subscription, error] {
+	return func(yield func(models.Subscription, error) bool) {
+		filter.Cursor = nil
+		for {
+			subscriptions, sort, err := s.queryList(ctx, filter, iteratePageSize)
+			if err != nil {
+				yield(models.Subscription{}, err)
+				return
+			}
+
+			for _, subscription := range subscriptions {
+				if !yield(subscription, nil) {
+					return
+				}
+			}
+
+			if len(subscriptions) < iteratePageSize {
+				return
+			}
+			cursor := listCursor(filter, sort, subscriptions[len(subscriptions)-1])
+			filter.Cursor = &cursor
+		}
+	}
+}
+
+// queryList читает не больше limit подписок по фильтру списка
+func (s *SQLStorage) queryList(ctx context.Context, filter models.ListFilter, limit int) ([]models.Subscription, sortColumn, error) {
+	query, args, sort, err := s.listQuery(filter)
+	if err != nil {
+		return nil, sortColumn{}, err
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" LIMIT $%d;", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sortColumn{}, err
 	}
 	defer rows.Close()
 
 	var subscriptions []models.Subscription
 
 	for rows.Next() {
-		var subscription models.Subscription
-		err := rows.Scan(&subscription.ID, &subscription.UserID, &subscription.ServiceName, &subscription.Price, &subscription.StartDate)
+		subscription, err := scanSubscription(rows)
 		if err != nil {
-			return nil, err
+			return nil, sortColumn{}, err
 		}
-		subscription.StartDate = subscription.StartDate[:10]
 		subscriptions = append(subscriptions, subscription)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, sortColumn{}, err
 	}
 
-	if len(subscriptions) == 0 {
-		return nil, ErrNoSubscriptions
+	return subscriptions, sort, nil
+}
+
+// listCursor указывает на подписку last как на последнюю запись страницы
+func listCursor(filter models.ListFilter, sort sortColumn, last models.Subscription) models.ListCursor {
+	return models.ListCursor{
+		Sort:  filter.Sort,
+		Order: filter.Order,
+		Value: sort.value(last),
+		ID:    last.ID,
+	}
+}
+
+// listQuery строит запрос списка подписок с условиями фильтра и сортировкой, но без LIMIT
+func (s *SQLStorage) listQuery(filter models.ListFilter) (string, []any, sortColumn, error) {
+	sort, ok := sortColumns[filter.Sort]
+	if !ok {
+		sort = sortColumns[models.SortByID]
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Order == models.OrderDesc {
+		direction, comparison = "DESC", "<"
+	}
+
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if filter.UserID.Valid {
+		where("user_id = $%d", filter.UserID.UUID)
+	}
+	if filter.ServiceName != "" {
+		where("service_name = $%d", filter.ServiceName)
+	}
+	if filter.ServiceNamePrefix != "" {
+		where(`service_name LIKE $%d ESCAPE '\'`, escapeLike(filter.ServiceNamePrefix)+"%")
+	}
+	if filter.PriceMin > 0 {
+		where("price >= $%d", filter.PriceMin)
+	}
+	if filter.PriceMax > 0 {
+		where("price <= $%d", filter.PriceMax)
+	}
+	if !filter.ActiveIn.IsZero() {
+		where(s.dialect.activeIn, filter.ActiveIn)
+	}
+	if filter.Cursor != nil {
+		value, err := sort.parse(filter.Cursor.Value)
+		if err != nil {
+			return "", nil, sortColumn{}, err
+		}
+		args = append(args, value, filter.Cursor.ID)
+		conditions = append(conditions, s.dialect.cursor(sort, comparison, len(args)-1, len(args)))
 	}
 
-	return subscriptions, nil
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", sort.column, direction, direction)
+
+	return query, args, sort, nil
+}
+
+// Update перезаписывает подписку; при expectedVersion > 0 запись обновляется только в этой версии
+func (s *SQLStorage) Update(ctx context.Context, id int, updatedSubscription models.Subscription, expectedVersion int) (models.Subscription, error) {
+	return s.Patch(ctx, id, expectedVersion, func(models.Subscription) (models.Subscription, error) {
+		return updatedSubscription, nil
+	})
 }
 
-func (s *SQLStorage) Update(id int, updatedSubscription models.Subscription) error {
+// Patch блокирует подписку, применяет к ней apply и сохраняет результат вместе с записью журнала в одной транзакции.
+// Новая цена действует с текущего месяца в пределах периода подписки, прежние цены сохраняются;
+// чтобы задать месяц или заменить всю историю цен, используйте ChangePrice
+func (s *SQLStorage) Patch(ctx context.Context, id int, expectedVersion int, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	var updated models.Subscription
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		updated, err = s.patch(ctx, tx, id, expectedVersion, apply)
+		return err
+	})
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	return updated, nil
+}
+
+func (s *SQLStorage) patch(ctx context.Context, q querier, id int, expectedVersion int, apply func(models.Subscription) (models.Subscription, error)) (models.Subscription, error) {
+	current, err := s.getByID(ctx, q, id, s.dialect.forUpdate)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if expectedVersion > 0 && current.Version != expectedVersion {
+		return models.Subscription{}, ErrVersionMismatch
+	}
+
+	patched, err := apply(current)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if patched.Price != current.Price {
+		if patched.Price, err = s.savePrice(ctx, q, id, patched.Price, priceChangeMonth(patched, time.Now())); err != nil {
+			return models.Subscription{}, err
+		}
+	}
+
+	updated, err := s.update(ctx, q, current, patched)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if err := s.recordEvent(ctx, q, models.EventActionUpdate, id, &current, &updated); err != nil {
+		return models.Subscription{}, err
+	}
+
+	return updated, nil
+}
+
+// update сохраняет новые поля подписки, заблокированной вызывающим в версии current.Version
+func (s *SQLStorage) update(ctx context.Context, q querier, current, updatedSubscription models.Subscription) (models.Subscription, error) {
 	query := `
 		UPDATE subscriptions
-		SET user_id = $2, service_name = $3, price = $4, start_date = $5
-		WHERE id = $1;
-	`
+		SET user_id = $2, service_name = $3, price = $4, currency = $5, start_date = $6, end_date = $7,
+			billing_period = $8, billing_interval = $9, no_overlap = $10,
+			version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL AND version = $11
+		RETURNING ` + subscriptionColumns + ";"
 
-	result, err := s.db.Exec(query, id,
+	updatedSubscription.ID = current.ID
+
+	updated, err := scanSubscription(q.QueryRowContext(ctx, query, current.ID,
 		updatedSubscription.UserID,
 		updatedSubscription.ServiceName,
 		updatedSubscription.Price,
+		updatedSubscription.Currency,
 		updatedSubscription.StartDate,
-	)
+		updatedSubscription.EndDate,
+		updatedSubscription.BillingPeriod,
+		updatedSubscription.BillingInterval,
+		updatedSubscription.NoOverlap,
+		current.Version,
+	))
+	if err == sql.ErrNoRows {
+		return models.Subscription{}, ErrVersionMismatch
+	}
+
+	return updated, s.uniquenessError(err, updatedSubscription)
+}
+
+// Delete помечает подписку удалённой; строка остаётся в таблице до очистки через Purge
+func (s *SQLStorage) Delete(ctx context.Context, id int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.delete(ctx, tx, id)
+	})
+}
 
+func (s *SQLStorage) delete(ctx context.Context, q querier, id int) error {
+	query := `
+		UPDATE subscriptions
+		SET deleted_at = CURRENT_TIMESTAMP, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + subscriptionColumns + ";"
+
+	current, err := s.getByID(ctx, q, id, s.dialect.forUpdate)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	deleted, err := scanSubscription(q.QueryRowContext(ctx, query, id))
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrSubscriptionNotFound
+	return s.recordEvent(ctx, q, models.EventActionDelete, id, &current, &deleted)
+}
+
+// Restore снимает пометку удаления и задаёт ограничение на пересечение периодов по noOverlap;
+// для неудалённой подписки возвращает ErrNotDeleted
+func (s *SQLStorage) Restore(ctx context.Context, id int, noOverlap bool) (models.Subscription, error) {
+	query := `
+		UPDATE subscriptions
+		SET deleted_at = NULL, no_overlap = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + subscriptionColumns + ";"
+
+	var restored models.Subscription
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.findByID(ctx, tx, id, s.dialect.forUpdate)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+		if restored, err = scanSubscription(tx.QueryRowContext(ctx, query, id, noOverlap)); err != nil {
+			current.NoOverlap = noOverlap
+			return s.uniquenessError(err, current)
+		}
+		return s.recordEvent(ctx, tx, models.EventActionRestore, id, &current, &restored)
+	})
+	if err != nil {
+		return models.Subscription{}, err
 	}
 
-	return nil
+	return restored, nil
 }
 
-func (s *SQLStorage) Delete(id int) error {
+// Purge окончательно удаляет подписки, помеченные удалёнными раньше deletedBefore, и записывает их последнее состояние в журнал
+func (s *SQLStorage) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
 	query := `
 		DELETE FROM subscriptions
-		WHERE id = $1;
-	`
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		RETURNING ` + subscriptionColumns + ";"
 
-	result, err := s.db.Exec(query, id)
+	var purged []models.Subscription
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, s.dialect.timestamp(deletedBefore))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			subscription, err := scanSubscription(rows)
+			if err != nil {
+				return err
+			}
+			purged = append(purged, subscription)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, subscription := range purged {
+			if err := s.recordEvent(ctx, tx, models.EventActionPurge, subscription.ID, &subscription, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return len(purged), nil
+}
+
+// GetSumItems возвращает отрезки пересечения подписок с периодом, на которых цена не менялась;
+// стоимость отрезков рассчитывает вызывающий с учётом цикла оплаты
+func (s *SQLStorage) GetSumItems(ctx context.Context, filter models.SumFilter) ([]models.SumItem, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.sumItems, filter.From, filter.To, filter.UserID, filter.ServiceName)
 	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return ErrSubscriptionNotFound
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
-}
+	items := []models.SumItem{}
 
-func (s *SQLStorage) GetTotalSum() (int, error) {
-	query := `
-		SELECT COALESCE(SUM(price), 0) as total_price
-		FROM subscriptions;
-	`
+	for rows.Next() {
+		var item models.SumItem
+		err := rows.Scan(
+			&item.ID,
+			&item.UserID,
+			&item.ServiceName,
+			&item.Price,
+			&item.Currency,
+			&item.BillingPeriod,
+			&item.BillingInterval,
+			&item.StartDate,
+			&item.From,
+			&item.To,
+			&item.Months,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
-	var totalSum int
+	return items, nil
+}
 
-	result := s.db.QueryRow(query)
+// inTx выполняет fn в транзакции и фиксирует её, если fn не вернула ошибку
+func (s *SQLStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	if err := result.Scan(&totalSum); err != nil {
-		return 0, err
+	if err := fn(tx); err != nil {
+		return err
 	}
 
-	return totalSum, nil
+	return tx.Commit()
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
 }
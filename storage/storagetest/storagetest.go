@@ -0,0 +1,1239 @@
+// Package storagetest содержит общий набор проверок, который должна проходить
+// каждая реализация manager.SubscriptionStorage.
+//
+// Реализация подключает набор из своего теста:
+//
+//	func TestMemoryStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) manager.SubscriptionStorage {
+//			return storage.NewMemory()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"subscription-aggregator-api/manager"
+	"subscription-aggregator-api/models"
+	"subscription-aggregator-api/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Factory возвращает пустое хранилище для одного подтеста
+type Factory func(t *testing.T) manager.SubscriptionStorage
+
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("CreateAssignsIncreasingIDs", func(t *testing.T) { testCreateAssignsIncreasingIDs(t, newStorage(t)) })
+	t.Run("GetByID", func(t *testing.T) { testGetByID(t, newStorage(t)) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newStorage(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newStorage(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newStorage(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStorage(t)) })
+	t.Run("Restore", func(t *testing.T) { testRestore(t, newStorage(t)) })
+	t.Run("Purge", func(t *testing.T) { testPurge(t, newStorage(t)) })
+	t.Run("ListEmpty", func(t *testing.T) { testListEmpty(t, newStorage(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, newStorage(t)) })
+	t.Run("ListPagination", func(t *testing.T) { testListPagination(t, newStorage(t)) })
+	t.Run("TotalSum", func(t *testing.T) { testTotalSum(t, newStorage(t)) })
+	t.Run("PriceHistory", func(t *testing.T) { testPriceHistory(t, newStorage(t)) })
+	t.Run("History", func(t *testing.T) { testHistory(t, newStorage(t)) })
+	t.Run("Events", func(t *testing.T) { testEvents(t, newStorage(t)) })
+	t.Run("ExchangeRates", func(t *testing.T) { testExchangeRates(t, newStorage(t)) })
+	t.Run("BatchAtomic", func(t *testing.T) { testBatchAtomic(t, newStorage(t)) })
+	t.Run("BatchBestEffort", func(t *testing.T) { testBatchBestEffort(t, newStorage(t)) })
+	t.Run("Import", func(t *testing.T) { testImport(t, newStorage(t)) })
+	t.Run("Iterate", func(t *testing.T) { testIterate(t, newStorage(t)) })
+	t.Run("IterateManyPages", func(t *testing.T) { testIterateManyPages(t, newStorage(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newStorage(t)) })
+	t.Run("GetOwner", func(t *testing.T) { testGetOwner(t, newStorage(t)) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, newStorage(t)) })
+	t.Run("FindActive", func(t *testing.T) { testFindActive(t, newStorage(t)) })
+	t.Run("UniquenessSameStart", func(t *testing.T) { testUniquenessSameStart(t, newStorage(t)) })
+	t.Run("UniquenessNoOverlap", func(t *testing.T) { testUniquenessNoOverlap(t, newStorage(t)) })
+	t.Run("RestoreIntoConflict", func(t *testing.T) { testRestoreIntoConflict(t, newStorage(t)) })
+	t.Run("UniquenessConcurrent", func(t *testing.T) { testUniquenessConcurrent(t, newStorage(t)) })
+}
+
+var (
+	userA = uuid.MustParse("60601fee-2bf1-4721-ae6f-7636e79a0cba")
+	userB = uuid.MustParse("a1b2c3d4-e5f6-7890-abcd-ef1234567890")
+)
+
+func month(year int, m time.Month) models.Month {
+	return models.NewMonth(year, m)
+}
+
+func monthPtr(year int, m time.Month) *models.Month {
+	value := month(year, m)
+	return &value
+}
+
+// withDefaults подставляет базовую валюту и ежемесячную оплату, как это делает менеджер перед сохранением
+func withDefaults(subscription models.Subscription) models.Subscription {
+	if subscription.Currency == "" {
+		subscription.Currency = models.BaseCurrency
+	}
+	if subscription.BillingPeriod == "" {
+		subscription.BillingPeriod = models.BillingPeriodMonth
+		subscription.BillingInterval = 1
+	}
+	return subscription
+}
+
+// wantViolation проверяет, что операция отклонена ограничением уникальности, и возвращает отклонённую подписку
+func wantViolation(t *testing.T, operation string, err error) models.Subscription {
+	t.Helper()
+	var violation *models.UniquenessViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("%s error = %v, want uniqueness violation", operation, err)
+	}
+	return violation.Subscription
+}
+
+func mustCreate(t *testing.T, s manager.SubscriptionStorage, subscription models.Subscription) models.Subscription {
+	t.Helper()
+	created, err := s.Create(t.Context(), withDefaults(subscription))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return created
+}
+
+// monthlyTotal считает стоимость отрезков при ежемесячной оплате
+func monthlyTotal(items []models.SumItem) int {
+	total := 0
+	for _, item := range items {
+		total += item.Price * item.Months
+	}
+	return total
+}
+
+func listFilter() models.ListFilter {
+	return models.ListFilter{Sort: models.SortByID, Order: models.OrderAsc, Limit: manager.DefaultListLimit}
+}
+
+func ids(subscriptions []models.Subscription) []int {
+	result := make([]int, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		result = append(result, subscription.ID)
+	}
+	return result
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testCreateAssignsIncreasingIDs(t *testing.T, s manager.SubscriptionStorage) {
+	first := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+	second := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.July)})
+
+	if first.ID <= 0 {
+		t.Fatalf("first ID = %d, want positive", first.ID)
+	}
+	if second.ID <= first.ID {
+		t.Fatalf("second ID = %d, want greater than %d", second.ID, first.ID)
+	}
+}
+
+func testGetByID(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{
+		UserID:          userA,
+		ServiceName:     "Yandex Plus",
+		Price:           400,
+		StartDate:       month(2025, time.July),
+		EndDate:         monthPtr(2025, time.December),
+		BillingPeriod:   models.BillingPeriodYear,
+		BillingInterval: 2,
+	})
+
+	got, err := s.GetByID(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.ID != created.ID || got.UserID != userA || got.ServiceName != "Yandex Plus" || got.Price != 400 {
+		t.Fatalf("GetByID() = %+v, want %+v", got, created)
+	}
+	if got.StartDate != month(2025, time.July) {
+		t.Fatalf("StartDate = %s, want 07-2025", got.StartDate)
+	}
+	if got.EndDate == nil || *got.EndDate != month(2025, time.December) {
+		t.Fatalf("EndDate = %v, want 12-2025", got.EndDate)
+	}
+	if got.BillingPeriod != models.BillingPeriodYear || got.BillingInterval != 2 {
+		t.Fatalf("billing = %d %s, want 2 year", got.BillingInterval, got.BillingPeriod)
+	}
+}
+
+func testNotFound(t *testing.T, s manager.SubscriptionStorage) {
+	if _, err := s.GetByID(t.Context(), 1000); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Errorf("GetByID() error = %v, want ErrSubscriptionNotFound", err)
+	}
+	if _, err := s.Update(t.Context(), 1000, models.Subscription{UserID: userA, ServiceName: "x", Price: 1, StartDate: month(2025, time.July)}, 0); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Errorf("Update() error = %v, want ErrSubscriptionNotFound", err)
+	}
+	if err := s.Delete(t.Context(), 1000); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Errorf("Delete() error = %v, want ErrSubscriptionNotFound", err)
+	}
+}
+
+func testUpdate(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+
+	if created.Version != 1 {
+		t.Fatalf("created Version = %d, want 1", created.Version)
+	}
+
+	updated := created
+	updated.Price = 500
+	updated.EndDate = monthPtr(2026, time.January)
+	result, err := s.Update(t.Context(), created.ID, updated, created.Version)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if result.Version != created.Version+1 {
+		t.Fatalf("Update() Version = %d, want %d", result.Version, created.Version+1)
+	}
+
+	if _, err := s.Update(t.Context(), created.ID, updated, created.Version); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("Update() with stale version error = %v, want ErrVersionMismatch", err)
+	}
+
+	got, err := s.GetByID(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.Price != 500 || got.EndDate == nil || *got.EndDate != month(2026, time.January) {
+		t.Fatalf("GetByID() after update = %+v", got)
+	}
+}
+
+func testPatch(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+
+	patched, err := s.Patch(t.Context(), created.ID, created.Version, func(current models.Subscription) (models.Subscription, error) {
+		current.Price = 450
+		return current, nil
+	})
+	if err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if patched.Price != 450 || patched.Version != created.Version+1 {
+		t.Fatalf("Patch() = %+v, want price 450 and version %d", patched, created.Version+1)
+	}
+
+	rejected := errors.New("rejected")
+	if _, err := s.Patch(t.Context(), created.ID, 0, func(models.Subscription) (models.Subscription, error) {
+		return models.Subscription{}, rejected
+	}); !errors.Is(err, rejected) {
+		t.Fatalf("Patch() error = %v, want apply error", err)
+	}
+	if _, err := s.Patch(t.Context(), created.ID, created.Version, func(current models.Subscription) (models.Subscription, error) {
+		return current, nil
+	}); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("Patch() with stale version error = %v, want ErrVersionMismatch", err)
+	}
+
+	got, err := s.GetByID(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.Price != 450 || got.Version != patched.Version {
+		t.Fatalf("GetByID() after patch = %+v", got)
+	}
+}
+
+func testDelete(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+	kept := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.July)})
+
+	if err := s.Delete(t.Context(), created.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.GetByID(t.Context(), created.ID); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("GetByID() after delete error = %v, want ErrSubscriptionNotFound", err)
+	}
+	if err := s.Delete(t.Context(), created.ID); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("second Delete() error = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	page, err := s.GetList(t.Context(), listFilter())
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	if got := ids(page.Items); !equalIDs(got, []int{kept.ID}) {
+		t.Fatalf("GetList() ids = %v, want %v", got, []int{kept.ID})
+	}
+
+	filter := listFilter()
+	filter.IncludeDeleted = true
+	page, err = s.GetList(t.Context(), filter)
+	if err != nil {
+		t.Fatalf("GetList(include deleted) error = %v", err)
+	}
+	if got := ids(page.Items); !equalIDs(got, []int{created.ID, kept.ID}) {
+		t.Fatalf("GetList(include deleted) ids = %v, want %v", got, []int{created.ID, kept.ID})
+	}
+	if page.Items[0].DeletedAt == nil {
+		t.Fatalf("deleted subscription DeletedAt = nil")
+	}
+
+	items, err := s.GetSumItems(t.Context(), models.SumFilter{From: month(2025, time.July), To: month(2025, time.July)})
+	if err != nil {
+		t.Fatalf("GetSumItems() error = %v", err)
+	}
+	if monthlyTotal(items) != kept.Price {
+		t.Fatalf("GetSumItems() total = %d, want %d", monthlyTotal(items), kept.Price)
+	}
+}
+
+func testRestore(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+
+	if _, err := s.Restore(t.Context(), created.ID, false); !errors.Is(err, storage.ErrNotDeleted) {
+		t.Fatalf("Restore() of active subscription error = %v, want ErrNotDeleted", err)
+	}
+	if _, err := s.Restore(t.Context(), 1000, false); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("Restore() of missing subscription error = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	if err := s.Delete(t.Context(), created.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if found, err := s.FindByID(t.Context(), created.ID); err != nil || found.DeletedAt == nil {
+		t.Fatalf("FindByID() of deleted subscription = %v, %v, want deleted subscription", found.DeletedAt, err)
+	}
+	if _, err := s.FindByID(t.Context(), 1000); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("FindByID() of missing subscription error = %v, want ErrSubscriptionNotFound", err)
+	}
+	restored, err := s.Restore(t.Context(), created.ID, false)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Fatalf("Restore() DeletedAt = %v, want nil", restored.DeletedAt)
+	}
+	if restored.Version != created.Version+2 {
+		t.Fatalf("Restore() Version = %d, want %d", restored.Version, created.Version+2)
+	}
+
+	got, err := s.GetByID(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetByID() after restore error = %v", err)
+	}
+	if got.ServiceName != created.ServiceName || got.Price != created.Price {
+		t.Fatalf("GetByID() after restore = %+v, want %+v", got, created)
+	}
+}
+
+func testPurge(t *testing.T, s manager.SubscriptionStorage) {
+	deleted := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+	kept := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.July)})
+
+	if err := s.Delete(t.Context(), deleted.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	purged, err := s.Purge(t.Context(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if purged != 0 {
+		t.Fatalf("Purge() before retention = %d, want 0", purged)
+	}
+
+	purged, err = s.Purge(t.Context(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("Purge() = %d, want 1", purged)
+	}
+
+	if _, err := s.Restore(t.Context(), deleted.ID, false); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("Restore() after purge error = %v, want ErrSubscriptionNotFound", err)
+	}
+	if _, err := s.GetByID(t.Context(), kept.ID); err != nil {
+		t.Fatalf("GetByID() of active subscription after purge error = %v", err)
+	}
+}
+
+func testListEmpty(t *testing.T, s manager.SubscriptionStorage) {
+	if _, err := s.GetList(t.Context(), listFilter()); !errors.Is(err, storage.ErrNoSubscriptions) {
+		t.Fatalf("GetList() error = %v, want ErrNoSubscriptions", err)
+	}
+}
+
+func testListFilters(t *testing.T, s manager.SubscriptionStorage) {
+	yandex := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.January), EndDate: monthPtr(2025, time.March)})
+	yandexMusic := mustCreate(t, s, models.Subscription{UserID: userB, ServiceName: "Yandex Music", Price: 250, StartDate: month(2025, time.February)})
+	sber := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.April)})
+
+	tests := []struct {
+		name   string
+		modify func(*models.ListFilter)
+		want   []int
+	}{
+		{"user", func(f *models.ListFilter) { f.UserID = uuid.NullUUID{UUID: userA, Valid: true} }, []int{yandex.ID, sber.ID}},
+		{"service name", func(f *models.ListFilter) { f.ServiceName = "Sber Prime" }, []int{sber.ID}},
+		{"service name prefix", func(f *models.ListFilter) { f.ServiceNamePrefix = "Yandex" }, []int{yandex.ID, yandexMusic.ID}},
+		{"price range", func(f *models.ListFilter) { f.PriceMin, f.PriceMax = 200, 300 }, []int{yandexMusic.ID, sber.ID}},
+		{"active in", func(f *models.ListFilter) { f.ActiveIn = month(2025, time.April) }, []int{yandexMusic.ID, sber.ID}},
+		{"sort by price desc", func(f *models.ListFilter) { f.Sort, f.Order = models.SortByPrice, models.OrderDesc }, []int{yandex.ID, yandexMusic.ID, sber.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := listFilter()
+			tt.modify(&filter)
+
+			page, err := s.GetList(t.Context(), filter)
+			if err != nil {
+				t.Fatalf("GetList() error = %v", err)
+			}
+			if got := ids(page.Items); !equalIDs(got, tt.want) {
+				t.Fatalf("GetList() IDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testListPagination(t *testing.T, s manager.SubscriptionStorage) {
+	prices := []int{300, 100, 300, 200, 100}
+	for i, price := range prices {
+		mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Service", Price: price, StartDate: month(2025, time.July+time.Month(i))})
+	}
+
+	filter := listFilter()
+	filter.Sort = models.SortByPrice
+	filter.Limit = 2
+
+	page, err := s.GetList(t.Context(), filter)
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	var got []models.Subscription
+	for {
+		got = append(got, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor, err := models.DecodeListCursor(page.NextCursor)
+		if err != nil {
+			t.Fatalf("DecodeListCursor() error = %v", err)
+		}
+		filter.Cursor = &cursor
+		if page, err = s.GetList(t.Context(), filter); err != nil {
+			t.Fatalf("GetList() next page error = %v", err)
+		}
+	}
+
+	for i := 1; i < len(got); i++ {
+		if got[i-1].Price > got[i].Price || (got[i-1].Price == got[i].Price && got[i-1].ID >= got[i].ID) {
+			t.Fatalf("pages are not ordered by (price, id): %v", got)
+		}
+	}
+	if len(got) != len(prices) {
+		t.Fatalf("paginated %d subscriptions, want %d", len(got), len(prices))
+	}
+}
+
+func testTotalSum(t *testing.T, s manager.SubscriptionStorage) {
+	yandex := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2024, time.November), EndDate: monthPtr(2025, time.March)})
+	sber := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.February)})
+	mustCreate(t, s, models.Subscription{UserID: userB, ServiceName: "Yandex Plus", Price: 300, StartDate: month(2025, time.January)})
+	mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Kinopoisk", Price: 100, StartDate: month(2025, time.July)})
+
+	items, err := s.GetSumItems(t.Context(), models.SumFilter{
+		From:   month(2025, time.January),
+		To:     month(2025, time.June),
+		UserID: uuid.NullUUID{UUID: userA, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("GetSumItems() error = %v", err)
+	}
+
+	wantMonths := map[int]int{yandex.ID: 3, sber.ID: 5}
+	if len(items) != len(wantMonths) {
+		t.Fatalf("GetSumItems() items = %+v, want subscriptions %v", items, wantMonths)
+	}
+	for _, item := range items {
+		if item.Months != wantMonths[item.ID] {
+			t.Errorf("subscription %d months = %d, want %d", item.ID, item.Months, wantMonths[item.ID])
+		}
+		if item.BillingPeriod != models.BillingPeriodMonth || item.BillingInterval != 1 || item.StartDate.IsZero() {
+			t.Errorf("subscription %d billing = %d %s from %s, want monthly from start date", item.ID, item.BillingInterval, item.BillingPeriod, item.StartDate)
+		}
+	}
+	if want := 400*3 + 200*5; monthlyTotal(items) != want {
+		t.Fatalf("GetSumItems() total = %d, want %d", monthlyTotal(items), want)
+	}
+
+	items, err = s.GetSumItems(t.Context(), models.SumFilter{From: month(2025, time.January), To: month(2025, time.June), ServiceName: "Yandex Plus"})
+	if err != nil {
+		t.Fatalf("GetSumItems() error = %v", err)
+	}
+	if want := 400*3 + 300*6; monthlyTotal(items) != want {
+		t.Fatalf("GetSumItems() by service total = %d, want %d", monthlyTotal(items), want)
+	}
+
+	netflix := mustCreate(t, s, models.Subscription{UserID: userB, ServiceName: "Netflix", Price: 15, Currency: "USD", StartDate: month(2025, time.January)})
+	items, err = s.GetSumItems(t.Context(), models.SumFilter{From: month(2025, time.January), To: month(2025, time.January), ServiceName: "Netflix"})
+	if err != nil {
+		t.Fatalf("GetSumItems() error = %v", err)
+	}
+	if len(items) != 1 || items[0].ID != netflix.ID || items[0].Currency != "USD" {
+		t.Fatalf("GetSumItems() items = %+v, want subscription %d priced in USD", items, netflix.ID)
+	}
+}
+
+func testExchangeRates(t *testing.T, s manager.SubscriptionStorage) {
+	save := func(currency string, rate float64, effectiveFrom models.Month) {
+		t.Helper()
+		saved, err := s.SaveExchangeRate(t.Context(), models.ExchangeRate{Currency: currency, Rate: rate, EffectiveFrom: effectiveFrom})
+		if err != nil {
+			t.Fatalf("SaveExchangeRate() error = %v", err)
+		}
+		if saved.Currency != currency || saved.Rate != rate || saved.EffectiveFrom != effectiveFrom || saved.CreatedAt.IsZero() {
+			t.Fatalf("SaveExchangeRate() = %+v", saved)
+		}
+	}
+
+	save("USD", 90, month(2025, time.March))
+	save("USD", 95.5, month(2025, time.January))
+	save("EUR", 100, month(2025, time.January))
+	save("USD", 92.25, month(2025, time.March))
+
+	rates, err := s.GetExchangeRates(t.Context(), "")
+	if err != nil {
+		t.Fatalf("GetExchangeRates() error = %v", err)
+	}
+	if len(rates) != 3 || rates[0].Currency != "EUR" || rates[1].EffectiveFrom != month(2025, time.January) || rates[2].Rate != 92.25 {
+		t.Fatalf("GetExchangeRates() = %+v, want EUR then USD by month with replaced March rate", rates)
+	}
+
+	rates, err = s.GetExchangeRates(t.Context(), "USD")
+	if err != nil {
+		t.Fatalf("GetExchangeRates() error = %v", err)
+	}
+	if len(rates) != 2 || rates[0].Currency != "USD" || rates[1].Currency != "USD" {
+		t.Fatalf("GetExchangeRates(USD) = %+v", rates)
+	}
+
+	rates, err = s.GetExchangeRates(t.Context(), "GBP")
+	if err != nil || len(rates) != 0 {
+		t.Fatalf("GetExchangeRates(GBP) = %+v, %v, want empty list", rates, err)
+	}
+}
+
+func testPriceHistory(t *testing.T, s manager.SubscriptionStorage) {
+	created := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 100, StartDate: month(2025, time.January)})
+	changePrice := func(price int, effectiveFrom models.Month) models.Subscription {
+		t.Helper()
+		updated, err := s.ChangePrice(t.Context(), created.ID, 0, effectiveFrom, func(current models.Subscription) (models.Subscription, error) {
+			current.Price = price
+			return current, nil
+		})
+		if err != nil {
+			t.Fatalf("ChangePrice() error = %v", err)
+		}
+		return updated
+	}
+	sum := func() []models.SumItem {
+		t.Helper()
+		items, err := s.GetSumItems(t.Context(), models.SumFilter{From: month(2025, time.January), To: month(2025, time.June)})
+		if err != nil {
+			t.Fatalf("GetSumItems() error = %v", err)
+		}
+		return items
+	}
+
+	if updated := changePrice(200, month(2025, time.April)); updated.Price != 200 {
+		t.Fatalf("ChangePrice() price = %d, want 200", updated.Price)
+	}
+
+	items := sum()
+	if want := 100*3 + 200*3; monthlyTotal(items) != want {
+		t.Fatalf("GetSumItems() total = %d, want %d", monthlyTotal(items), want)
+	}
+	if len(items) != 2 || items[0].To != month(2025, time.March) || items[1].From != month(2025, time.April) {
+		t.Fatalf("GetSumItems() items = %+v, want segments 01-2025..03-2025 and 04-2025..06-2025", items)
+	}
+
+	if updated := changePrice(150, month(2025, time.March)); updated.Price != 200 {
+		t.Fatalf("backdated ChangePrice() price = %d, want latest price 200", updated.Price)
+	}
+	if want := 100*2 + 150 + 200*3; monthlyTotal(sum()) != want {
+		t.Fatalf("GetSumItems() after backdated change total = %d, want %d", monthlyTotal(sum()), want)
+	}
+
+	prices, err := s.GetPrices(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetPrices() error = %v", err)
+	}
+	if len(prices) != 3 || prices[0].EffectiveFrom != month(2025, time.January) || prices[2].Price != 200 {
+		t.Fatalf("GetPrices() = %+v", prices)
+	}
+
+	// без месяца новая цена действует с текущего месяца, прежние цены сохраняются
+	current, err := s.GetByID(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	current.Price = 300
+	if updated, err := s.Update(t.Context(), created.ID, current, 0); err != nil || updated.Price != 300 {
+		t.Fatalf("Update() = %d, %v, want price 300", updated.Price, err)
+	}
+	if want := 100*2 + 150 + 200*3; monthlyTotal(sum()) != want {
+		t.Fatalf("GetSumItems() after Update() total = %d, want unchanged %d", monthlyTotal(sum()), want)
+	}
+	prices, err = s.GetPrices(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetPrices() error = %v", err)
+	}
+	if thisMonth := models.MonthOf(time.Now()); len(prices) != 4 || prices[3].Price != 300 || prices[3].EffectiveFrom != thisMonth {
+		t.Fatalf("GetPrices() after Update() = %+v, want 300 from %s appended", prices, thisMonth)
+	}
+
+	// нулевой месяц заменяет историю цен
+	if updated := changePrice(350, models.Month{}); updated.Price != 350 {
+		t.Fatalf("ChangePrice() resetting history price = %d, want 350", updated.Price)
+	}
+	items = sum()
+	if want := 350 * 6; monthlyTotal(items) != want || len(items) != 1 {
+		t.Fatalf("GetSumItems() after rewrite = %+v, want single item with total %d", items, want)
+	}
+
+	// у закончившейся подписки новая цена действует с последнего месяца
+	ended := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Sber Prime", Price: 200, StartDate: month(2024, time.January), EndDate: monthPtr(2024, time.June)})
+	ended.Price = 250
+	if _, err := s.Update(t.Context(), ended.ID, ended, 0); err != nil {
+		t.Fatalf("Update() of ended subscription error = %v", err)
+	}
+	if prices, err := s.GetPrices(t.Context(), ended.ID); err != nil || len(prices) != 2 || prices[1].EffectiveFrom != month(2024, time.June) {
+		t.Fatalf("GetPrices() of ended subscription = %+v, %v, want 250 from 06-2024", prices, err)
+	}
+
+	if _, err := s.GetPrices(t.Context(), 1000); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("GetPrices() of missing subscription error = %v, want ErrSubscriptionNotFound", err)
+	}
+}
+
+func testHistory(t *testing.T, s manager.SubscriptionStorage) {
+	ctx := models.WithAuditInfo(t.Context(), models.AuditInfo{Actor: "alice", RequestID: "req-1"})
+
+	created, err := s.Create(ctx, withDefaults(models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)}))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	updated := created
+	updated.Price = 500
+	if _, err := s.Update(ctx, created.ID, updated, 0); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := s.Delete(ctx, created.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Restore(t.Context(), created.ID, false); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	events, err := s.GetHistory(t.Context(), created.ID)
+	if err != nil {
+		t.Fatalf("GetHistory() error = %v", err)
+	}
+
+	wantActions := []string{models.EventActionCreate, models.EventActionUpdate, models.EventActionDelete, models.EventActionRestore}
+	if len(events) != len(wantActions) {
+		t.Fatalf("GetHistory() = %d events, want %d", len(events), len(wantActions))
+	}
+	for i, event := range events {
+		if event.Action != wantActions[i] {
+			t.Errorf("event %d action = %q, want %q", i, event.Action, wantActions[i])
+		}
+	}
+
+	create, update, restore := events[0], events[1], events[3]
+	if create.Actor != "alice" || create.RequestID != "req-1" {
+		t.Errorf("create event actor = %q, request ID = %q, want alice, req-1", create.Actor, create.RequestID)
+	}
+	if create.Before != nil || create.After == nil {
+		t.Errorf("create event before = %s, after = %s, want only after", create.Before, create.After)
+	}
+	if restore.Actor != models.AnonymousActor {
+		t.Errorf("restore event actor = %q, want %q", restore.Actor, models.AnonymousActor)
+	}
+
+	var before, after models.Subscription
+	if err := json.Unmarshal(update.Before, &before); err != nil {
+		t.Fatalf("update event before: %v", err)
+	}
+	if err := json.Unmarshal(update.After, &after); err != nil {
+		t.Fatalf("update event after: %v", err)
+	}
+	if before.Price != 400 || after.Price != 500 {
+		t.Errorf("update event price %d -> %d, want 400 -> 500", before.Price, after.Price)
+	}
+
+	if _, err := s.GetHistory(t.Context(), 1000); !errors.Is(err, storage.ErrNoEvents) {
+		t.Fatalf("GetHistory() of missing subscription error = %v, want ErrNoEvents", err)
+	}
+}
+
+func testEvents(t *testing.T, s manager.SubscriptionStorage) {
+	alice := models.WithAuditInfo(t.Context(), models.AuditInfo{Actor: "alice"})
+	bob := models.WithAuditInfo(t.Context(), models.AuditInfo{Actor: "bob"})
+
+	first, err := s.Create(alice, withDefaults(models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)}))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	second, err := s.Create(bob, withDefaults(models.Subscription{UserID: userB, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.July)}))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := s.Delete(bob, first.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	filter := models.EventFilter{Limit: 2}
+	page, err := s.GetEvents(t.Context(), filter)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	if len(page.Items) != 2 || page.NextCursor == "" {
+		t.Fatalf("GetEvents() first page = %d items, cursor %q, want 2 items and a cursor", len(page.Items), page.NextCursor)
+	}
+	if page.Items[0].Action != models.EventActionDelete || page.Items[1].SubscriptionID != second.ID {
+		t.Fatalf("GetEvents() first page = %+v, want newest events first", page.Items)
+	}
+
+	filter.BeforeID = page.Items[1].ID
+	page, err = s.GetEvents(t.Context(), filter)
+	if err != nil {
+		t.Fatalf("GetEvents() second page error = %v", err)
+	}
+	if len(page.Items) != 1 || page.NextCursor != "" || page.Items[0].SubscriptionID != first.ID {
+		t.Fatalf("GetEvents() second page = %+v, cursor %q", page.Items, page.NextCursor)
+	}
+
+	page, err = s.GetEvents(t.Context(), models.EventFilter{Actor: "bob", Action: models.EventActionCreate, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetEvents(actor, action) error = %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].SubscriptionID != second.ID {
+		t.Fatalf("GetEvents(actor, action) = %+v, want create of %d", page.Items, second.ID)
+	}
+
+	page, err = s.GetEvents(t.Context(), models.EventFilter{SubscriptionID: first.ID, Since: time.Now().Add(-time.Hour), Until: time.Now().Add(time.Hour), Limit: 10})
+	if err != nil {
+		t.Fatalf("GetEvents(subscription, period) error = %v", err)
+	}
+	if len(page.Items) != 2 {
+		t.Fatalf("GetEvents(subscription, period) = %d events, want 2", len(page.Items))
+	}
+
+	if _, err := s.GetEvents(t.Context(), models.EventFilter{Since: time.Now().Add(time.Hour), Limit: 10}); !errors.Is(err, storage.ErrNoEvents) {
+		t.Fatalf("GetEvents(future) error = %v, want ErrNoEvents", err)
+	}
+}
+
+func testBatchAtomic(t *testing.T, s manager.SubscriptionStorage) {
+	existing := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.January)})
+	renamed := withDefaults(models.Subscription{UserID: userA, ServiceName: "Yandex Plus Family", Price: 600, StartDate: month(2025, time.January)})
+	sber := withDefaults(models.Subscription{UserID: userB, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.March)})
+
+	results, err := s.Batch(t.Context(), []models.BatchOperation{
+		{Op: models.BatchOpCreate, Subscription: &sber},
+		{Op: models.BatchOpUpdate, ID: existing.ID, Subscription: &renamed},
+		{Op: models.BatchOpDelete, ID: 1000},
+	}, true)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if len(results) != 3 || !errors.Is(results[0].Err, models.ErrBatchRolledBack) || !errors.Is(results[1].Err, models.ErrBatchRolledBack) ||
+		!errors.Is(results[2].Err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("Batch() results = %+v, want first two rolled back and the delete not found", results)
+	}
+
+	page, err := s.GetList(t.Context(), listFilter())
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ServiceName != existing.ServiceName || page.Items[0].Version != existing.Version {
+		t.Fatalf("GetList() after rolled back batch = %+v, want only the unchanged existing subscription", page.Items)
+	}
+	if history, err := s.GetHistory(t.Context(), existing.ID); err != nil || len(history) != 1 {
+		t.Fatalf("GetHistory() after rolled back batch = %+v, %v, want only the create event", history, err)
+	}
+
+	results, err = s.Batch(t.Context(), []models.BatchOperation{
+		{Op: models.BatchOpCreate, Subscription: &sber},
+		{Op: models.BatchOpUpdate, ID: existing.ID, Version: existing.Version, Subscription: &renamed},
+		{Op: models.BatchOpDelete, ID: existing.ID},
+	}, true)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Batch() result %d error = %v", i, result.Err)
+		}
+	}
+	if results[0].Subscription == nil || results[0].ID <= existing.ID || results[1].Subscription.ServiceName != renamed.ServiceName {
+		t.Fatalf("Batch() results = %+v", results)
+	}
+	if _, err := s.GetByID(t.Context(), existing.ID); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("GetByID() of deleted subscription error = %v, want ErrSubscriptionNotFound", err)
+	}
+	if _, err := s.GetByID(t.Context(), results[0].ID); err != nil {
+		t.Fatalf("GetByID() of batch-created subscription error = %v", err)
+	}
+}
+
+func testBatchBestEffort(t *testing.T, s manager.SubscriptionStorage) {
+	existing := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.January)})
+	updated := withDefaults(models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 500, StartDate: month(2025, time.January)})
+	sber := withDefaults(models.Subscription{UserID: userB, ServiceName: "Sber Prime", Price: 200, StartDate: month(2025, time.March)})
+
+	results, err := s.Batch(t.Context(), []models.BatchOperation{
+		{Op: models.BatchOpUpdate, ID: existing.ID, Version: existing.Version + 1, Subscription: &updated},
+		{Op: models.BatchOpCreate, Subscription: &sber},
+		{Op: models.BatchOpUpdate, ID: existing.ID, Version: existing.Version, Subscription: &updated},
+	}, false)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if !errors.Is(results[0].Err, storage.ErrVersionMismatch) || results[1].Err != nil || results[2].Err != nil {
+		t.Fatalf("Batch() results = %+v, want version mismatch then two successes", results)
+	}
+
+	current, err := s.GetByID(t.Context(), existing.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if current.Price != 500 || current.Version != existing.Version+1 {
+		t.Fatalf("GetByID() = %+v, want price 500 in version %d", current, existing.Version+1)
+	}
+	if _, err := s.GetByID(t.Context(), results[1].ID); err != nil {
+		t.Fatalf("GetByID() of batch-created subscription error = %v", err)
+	}
+}
+
+func testImport(t *testing.T, s manager.SubscriptionStorage) {
+	ctx := models.WithAuditInfo(t.Context(), models.AuditInfo{Actor: "importer"})
+	subscriptions := []models.Subscription{
+		withDefaults(models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.January), EndDate: monthPtr(2025, time.June)}),
+		withDefaults(models.Subscription{UserID: userB, ServiceName: "Netflix", Price: 15, Currency: "USD", StartDate: month(2025, time.March)}),
+	}
+
+	imported, err := s.Import(ctx, subscriptions)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported != len(subscriptions) {
+		t.Fatalf("Import() = %d, want %d", imported, len(subscriptions))
+	}
+
+	page, err := s.GetList(t.Context(), listFilter())
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].ServiceName != "Yandex Plus" || page.Items[0].EndDate == nil || page.Items[1].Currency != "USD" {
+		t.Fatalf("GetList() after import = %+v", page.Items)
+	}
+
+	for _, subscription := range page.Items {
+		prices, err := s.GetPrices(t.Context(), subscription.ID)
+		if err != nil || len(prices) != 1 || prices[0].Price != subscription.Price {
+			t.Fatalf("GetPrices(%d) after import = %+v, %v, want the imported price", subscription.ID, prices, err)
+		}
+		history, err := s.GetHistory(t.Context(), subscription.ID)
+		if err != nil || len(history) != 1 || history[0].Action != models.EventActionCreate || history[0].Actor != "importer" {
+			t.Fatalf("GetHistory(%d) after import = %+v, %v, want a create event by importer", subscription.ID, history, err)
+		}
+	}
+}
+
+func testIterate(t *testing.T, s manager.SubscriptionStorage) {
+	for i := range 5 {
+		mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Service", Price: 100 * (5 - i), StartDate: month(2025, time.January+time.Month(i))})
+	}
+	other := mustCreate(t, s, models.Subscription{UserID: userB, ServiceName: "Other", Price: 50, StartDate: month(2025, time.January)})
+	deleted := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Service", Price: 700, StartDate: month(2025, time.June)})
+	if err := s.Delete(t.Context(), deleted.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	collect := func(filter models.ListFilter) []models.Subscription {
+		t.Helper()
+		var subscriptions []models.Subscription
+		for subscription, err := range s.Iterate(t.Context(), filter) {
+			if err != nil {
+				t.Fatalf("Iterate() error = %v", err)
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+		return subscriptions
+	}
+
+	// Limit не ограничивает выгрузку
+	filter := models.ListFilter{UserID: uuid.NullUUID{UUID: userA, Valid: true}, Sort: models.SortByPrice, Limit: 2}
+	subscriptions := collect(filter)
+	if len(subscriptions) != 5 || subscriptions[0].Price != 100 || subscriptions[4].Price != 500 {
+		t.Fatalf("Iterate() = %+v, want 5 active subscriptions of userA by price", subscriptions)
+	}
+
+	filter.IncludeDeleted = true
+	filter.Order = models.OrderDesc
+	if subscriptions := collect(filter); len(subscriptions) != 6 || subscriptions[0].ID != deleted.ID {
+		t.Fatalf("Iterate() with deleted = %+v, want 6 subscriptions starting with the deleted one", subscriptions)
+	}
+
+	if subscriptions := collect(models.ListFilter{ServiceName: "Other"}); len(subscriptions) != 1 || subscriptions[0].ID != other.ID {
+		t.Fatalf("Iterate() by service = %+v, want subscription %d", subscriptions, other.ID)
+	}
+
+	seen := 0
+	for range s.Iterate(t.Context(), listFilter()) {
+		if seen++; seen == 2 {
+			break
+		}
+	}
+	if _, err := s.GetByID(t.Context(), other.ID); err != nil {
+		t.Fatalf("GetByID() after stopped iteration error = %v", err)
+	}
+}
+
+// testIterateManyPages проверяет обход, который не помещается в одну страницу чтения, и то, что
+// во время медленного обхода хранилище продолжает обслуживать другие запросы
+func testIterateManyPages(t *testing.T, s manager.SubscriptionStorage) {
+	const total = 1234
+	for i := range total {
+		mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: fmt.Sprintf("Service %04d", i), Price: 100, StartDate: month(2025, time.January)})
+	}
+
+	seen, lastID := 0, 0
+	for subscription, err := range s.Iterate(t.Context(), listFilter()) {
+		if err != nil {
+			t.Fatalf("Iterate() error = %v", err)
+		}
+		if subscription.ID <= lastID {
+			t.Fatalf("Iterate() returned subscription %d after %d, want ascending IDs without repeats", subscription.ID, lastID)
+		}
+		lastID = subscription.ID
+		seen++
+
+		if seen%500 == 1 {
+			ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+			_, err := s.GetByID(ctx, subscription.ID)
+			cancel()
+			if err != nil {
+				t.Fatalf("GetByID() during iteration error = %v", err)
+			}
+		}
+	}
+	if seen != total {
+		t.Fatalf("Iterate() returned %d subscriptions, want %d", seen, total)
+	}
+}
+
+func testAPIKeys(t *testing.T, s manager.SubscriptionStorage) {
+	reader, err := s.CreateAPIKey(t.Context(), models.APIKey{Name: "reader", Prefix: "sa_reader", Scopes: []string{models.ScopeRead}}, "hash-reader")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	if reader.ID <= 0 || reader.Name != "reader" || reader.Prefix != "sa_reader" || reader.CreatedAt.IsZero() || reader.LastUsedAt != nil || reader.RevokedAt != nil {
+		t.Fatalf("CreateAPIKey() = %+v", reader)
+	}
+	admin, err := s.CreateAPIKey(t.Context(), models.APIKey{Name: "admin", Prefix: "sa_admin", Scopes: []string{models.ScopeWrite, models.ScopeAdmin}}, "hash-admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	found, ok, err := s.FindAPIKey(t.Context(), "hash-admin")
+	if err != nil || !ok || found.ID != admin.ID || len(found.Scopes) != 2 || found.Scopes[1] != models.ScopeAdmin {
+		t.Fatalf("FindAPIKey() = %+v, %v, %v, want key %d with write and admin scopes", found, ok, err, admin.ID)
+	}
+	if _, ok, err := s.FindAPIKey(t.Context(), "hash-unknown"); err != nil || ok {
+		t.Fatalf("FindAPIKey(unknown) = %v, %v, want not found", ok, err)
+	}
+
+	usedAt := time.Date(2025, time.July, 1, 12, 0, 0, 0, time.UTC)
+	if err := s.TouchAPIKey(t.Context(), reader.ID, usedAt); err != nil {
+		t.Fatalf("TouchAPIKey() error = %v", err)
+	}
+	found, _, _ = s.FindAPIKey(t.Context(), "hash-reader")
+	if found.LastUsedAt == nil || !found.LastUsedAt.Equal(usedAt) {
+		t.Fatalf("FindAPIKey() after touch = %+v, want last used at %s", found, usedAt)
+	}
+
+	revoked, err := s.RevokeAPIKey(t.Context(), reader.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("RevokeAPIKey() = %+v, %v", revoked, err)
+	}
+	again, err := s.RevokeAPIKey(t.Context(), reader.ID)
+	if err != nil || again.RevokedAt == nil || !again.RevokedAt.Equal(*revoked.RevokedAt) {
+		t.Fatalf("RevokeAPIKey() again = %+v, %v, want unchanged revocation time", again, err)
+	}
+	if _, err := s.RevokeAPIKey(t.Context(), admin.ID+100); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		t.Fatalf("RevokeAPIKey(unknown) error = %v, want ErrAPIKeyNotFound", err)
+	}
+
+	keys, err := s.GetAPIKeys(t.Context())
+	if err != nil || len(keys) != 2 || keys[0].ID != reader.ID || keys[0].RevokedAt == nil || keys[1].RevokedAt != nil {
+		t.Fatalf("GetAPIKeys() = %+v, %v, want both keys by ID with the first revoked", keys, err)
+	}
+}
+
+func testGetOwner(t *testing.T, s manager.SubscriptionStorage) {
+	userID := uuid.New()
+	created := mustCreate(t, s, models.Subscription{ServiceName: "Yandex Plus", Price: 400, UserID: userID, StartDate: month(2025, time.July)})
+
+	if owner, err := s.GetOwner(t.Context(), created.ID); err != nil || owner != userID {
+		t.Fatalf("GetOwner() = %s, %v, want %s", owner, err, userID)
+	}
+
+	if err := s.Delete(t.Context(), created.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if owner, err := s.GetOwner(t.Context(), created.ID); err != nil || owner != userID {
+		t.Fatalf("GetOwner() of deleted subscription = %s, %v, want %s", owner, err, userID)
+	}
+
+	if _, err := s.GetOwner(t.Context(), created.ID+100); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+		t.Fatalf("GetOwner(unknown) error = %v, want ErrSubscriptionNotFound", err)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, s manager.SubscriptionStorage) {
+	now := time.Date(2025, time.July, 1, 12, 0, 0, 0, time.UTC)
+	record := models.IdempotencyRecord{Owner: "api_key:1", Key: "retry-1", RequestHash: "hash-a", ExpiresAt: now.Add(time.Hour)}
+
+	if _, reserved, err := s.ReserveIdempotencyKey(t.Context(), record, now); err != nil || !reserved {
+		t.Fatalf("ReserveIdempotencyKey() = %v, %v, want reserved", reserved, err)
+	}
+	pending, reserved, err := s.ReserveIdempotencyKey(t.Context(), record, now)
+	if err != nil || reserved || pending.RequestHash != "hash-a" || pending.Response != nil {
+		t.Fatalf("ReserveIdempotencyKey() again = %+v, %v, %v, want pending record", pending, reserved, err)
+	}
+	other := record
+	other.Owner = "api_key:2"
+	if _, reserved, err := s.ReserveIdempotencyKey(t.Context(), other, now); err != nil || !reserved {
+		t.Fatalf("ReserveIdempotencyKey() by another owner = %v, %v, want reserved", reserved, err)
+	}
+
+	response := models.StoredResponse{Status: 201, Headers: map[string]string{"Location": "/subscriptions/1"}, Body: []byte(`{"id":1}`)}
+	if err := s.CompleteIdempotencyKey(t.Context(), record.Owner, record.Key, response); err != nil {
+		t.Fatalf("CompleteIdempotencyKey() error = %v", err)
+	}
+	// ответ сохранён, поэтому освобождение его не удаляет
+	if err := s.ReleaseIdempotencyKey(t.Context(), record.Owner, record.Key); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey() error = %v", err)
+	}
+	completed, reserved, err := s.ReserveIdempotencyKey(t.Context(), record, now)
+	if err != nil || reserved || completed.Response == nil || completed.Response.Status != 201 ||
+		completed.Response.Headers["Location"] != "/subscriptions/1" || string(completed.Response.Body) != `{"id":1}` {
+		t.Fatalf("ReserveIdempotencyKey() after completion = %+v, %v, %v, want stored response", completed, reserved, err)
+	}
+
+	if err := s.ReleaseIdempotencyKey(t.Context(), other.Owner, other.Key); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey() error = %v", err)
+	}
+	if _, reserved, err := s.ReserveIdempotencyKey(t.Context(), other, now); err != nil || !reserved {
+		t.Fatalf("ReserveIdempotencyKey() after release = %v, %v, want reserved", reserved, err)
+	}
+
+	later := now.Add(2 * time.Hour)
+	renewed := record
+	renewed.RequestHash = "hash-b"
+	renewed.ExpiresAt = later.Add(time.Hour)
+	if _, reserved, err := s.ReserveIdempotencyKey(t.Context(), renewed, later); err != nil || !reserved {
+		t.Fatalf("ReserveIdempotencyKey() after expiry = %v, %v, want reserved", reserved, err)
+	}
+
+	purged, err := s.PurgeIdempotencyKeys(t.Context(), later)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeIdempotencyKeys() = %d, %v, want 1 expired key", purged, err)
+	}
+	if _, reserved, err := s.ReserveIdempotencyKey(t.Context(), renewed, later); err != nil || reserved {
+		t.Fatalf("ReserveIdempotencyKey() after purge = %v, %v, want the renewed key kept", reserved, err)
+	}
+}
+
+func testFindActive(t *testing.T, s manager.SubscriptionStorage) {
+	first := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+	deleted := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2024, time.July)})
+	mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Netflix", Price: 800, StartDate: month(2025, time.July)})
+	other := mustCreate(t, s, models.Subscription{UserID: userB, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July)})
+	if err := s.Delete(t.Context(), deleted.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	found, err := s.FindActive(t.Context(), []models.ServiceKey{
+		{UserID: userB, ServiceName: "Yandex Plus"},
+		{UserID: userA, ServiceName: "Yandex Plus"},
+		{UserID: userA, ServiceName: "Yandex Plus"},
+		{UserID: userB, ServiceName: "Netflix"},
+	})
+	if err != nil {
+		t.Fatalf("FindActive() error = %v", err)
+	}
+	if want := []int{first.ID, other.ID}; !equalIDs(ids(found), want) {
+		t.Fatalf("FindActive() = %v, want %v", ids(found), want)
+	}
+
+	if found, err := s.FindActive(t.Context(), nil); err != nil || len(found) != 0 {
+		t.Fatalf("FindActive(nil) = %v, %v, want no subscriptions", ids(found), err)
+	}
+}
+
+func testUniquenessSameStart(t *testing.T, s manager.SubscriptionStorage) {
+	yandex := models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.July), EndDate: monthPtr(2025, time.December)}
+	first := mustCreate(t, s, yandex)
+
+	_, err := s.Create(t.Context(), withDefaults(yandex))
+	wantViolation(t, "Create() with the same start", err)
+
+	// без NoOverlap пересечение периодов разрешено
+	later := yandex
+	later.StartDate = month(2025, time.August)
+	second := mustCreate(t, s, later)
+	other := yandex
+	other.UserID = userB
+	mustCreate(t, s, other)
+
+	moved := withDefaults(later)
+	moved.StartDate = month(2025, time.July)
+	_, err = s.Update(t.Context(), second.ID, moved, 0)
+	wantViolation(t, "Update() to the same start", err)
+
+	if err := s.Delete(t.Context(), first.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	mustCreate(t, s, yandex)
+	_, err = s.Restore(t.Context(), first.ID, false)
+	if rejected := wantViolation(t, "Restore()", err); rejected.ID != first.ID {
+		t.Fatalf("Restore() rejected subscription %d, want %d", rejected.ID, first.ID)
+	}
+
+	_, err = s.Import(t.Context(), []models.Subscription{withDefaults(other), withDefaults(yandex)})
+	wantViolation(t, "Import()", err)
+	if page, err := s.GetList(t.Context(), listFilter()); err != nil || len(page.Items) != 3 {
+		t.Fatalf("GetList() after rejected import = %d items, %v, want 3", len(page.Items), err)
+	}
+
+	results, err := s.Batch(t.Context(), []models.BatchOperation{
+		{Op: models.BatchOpCreate, Subscription: &models.Subscription{UserID: userB, ServiceName: "Netflix", Price: 800, StartDate: month(2025, time.July), Currency: models.BaseCurrency, BillingPeriod: models.BillingPeriodMonth, BillingInterval: 1}},
+		{Op: models.BatchOpCreate, Subscription: &models.Subscription{UserID: userB, ServiceName: "Netflix", Price: 900, StartDate: month(2025, time.July), Currency: models.BaseCurrency, BillingPeriod: models.BillingPeriodMonth, BillingInterval: 1}},
+	}, true)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	wantViolation(t, "Batch() create with the same start", results[1].Err)
+}
+
+func testUniquenessNoOverlap(t *testing.T, s manager.SubscriptionStorage) {
+	yandex := func(start models.Month, end *models.Month) models.Subscription {
+		return models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: start, EndDate: end, NoOverlap: true}
+	}
+	first := mustCreate(t, s, yandex(month(2025, time.July), monthPtr(2025, time.December)))
+	mustCreate(t, s, yandex(month(2026, time.January), nil))
+	before := mustCreate(t, s, yandex(month(2025, time.March), monthPtr(2025, time.June)))
+	if !first.NoOverlap {
+		t.Fatalf("Create() NoOverlap = false, want true")
+	}
+
+	_, err := s.Create(t.Context(), withDefaults(yandex(month(2025, time.December), nil)))
+	wantViolation(t, "Create() overlapping the end", err)
+
+	// ограничение действует только между подписками с NoOverlap
+	unmarked := withDefaults(yandex(month(2025, time.December), nil))
+	unmarked.NoOverlap = false
+	mustCreate(t, s, unmarked)
+
+	extended := before
+	extended.EndDate = monthPtr(2025, time.July)
+	_, err = s.Update(t.Context(), before.ID, extended, 0)
+	wantViolation(t, "Update() extending into the next subscription", err)
+
+	_, err = s.Import(t.Context(), []models.Subscription{
+		withDefaults(models.Subscription{UserID: userB, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2024, time.January), EndDate: monthPtr(2024, time.February), NoOverlap: true}),
+		withDefaults(models.Subscription{UserID: userB, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2024, time.February), NoOverlap: true}),
+	})
+	wantViolation(t, "Import() of overlapping rows", err)
+
+	if err := s.Delete(t.Context(), first.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	mustCreate(t, s, yandex(month(2025, time.August), monthPtr(2025, time.August)))
+	_, err = s.Restore(t.Context(), first.ID, true)
+	wantViolation(t, "Restore() overlapping a newer subscription", err)
+}
+
+// testRestoreIntoConflict проверяет, что подписка, удалённая до включения NoOverlap, восстанавливается с ограничением
+// и не может пересечься с действующей подпиской
+func testRestoreIntoConflict(t *testing.T, s manager.SubscriptionStorage) {
+	old := mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.January), EndDate: monthPtr(2025, time.December)})
+	if err := s.Delete(t.Context(), old.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	mustCreate(t, s, models.Subscription{UserID: userA, ServiceName: "Yandex Plus", Price: 500, StartDate: month(2025, time.June), NoOverlap: true})
+
+	_, err := s.Restore(t.Context(), old.ID, true)
+	if rejected := wantViolation(t, "Restore() with NoOverlap into an overlapping period", err); rejected.ID != old.ID {
+		t.Fatalf("Restore() rejected subscription %d, want %d", rejected.ID, old.ID)
+	}
+	if found, err := s.FindByID(t.Context(), old.ID); err != nil || found.DeletedAt == nil || found.NoOverlap {
+		t.Fatalf("FindByID() after rejected restore = %+v, %v, want unchanged deleted subscription", found, err)
+	}
+
+	restored, err := s.Restore(t.Context(), old.ID, false)
+	if err != nil {
+		t.Fatalf("Restore() without NoOverlap error = %v", err)
+	}
+	if restored.NoOverlap {
+		t.Fatalf("Restore() NoOverlap = true, want false")
+	}
+}
+
+// testUniquenessConcurrent проверяет, что из параллельных записей пересекающихся подписок ограничение пропускает одну
+func testUniquenessConcurrent(t *testing.T, s manager.SubscriptionStorage) {
+	const writers = 8
+
+	errs := make(chan error, writers)
+	for i := range writers {
+		go func() {
+			_, err := s.Create(t.Context(), withDefaults(models.Subscription{
+				UserID: userA, ServiceName: "Yandex Plus", Price: 400, StartDate: month(2025, time.Month(i+1)), NoOverlap: true,
+			}))
+			errs <- err
+		}()
+	}
+
+	created := 0
+	for range writers {
+		err := <-errs
+		if err == nil {
+			created++
+			continue
+		}
+		wantViolation(t, "parallel Create()", err)
+	}
+	if created != 1 {
+		t.Fatalf("parallel Create() saved %d subscriptions, want 1", created)
+	}
+}
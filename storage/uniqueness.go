@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"subscription-aggregator-api/models"
+)
+
+// findActiveChunkSize ограничивает число пар пользователь–сервис в одном запросе FindActive
+const findActiveChunkSize = 500
+
+// FindActive возвращает неудалённые подписки пользователей на сервисы из keys, упорядоченные по ID
+func (s *SQLStorage) FindActive(ctx context.Context, keys []models.ServiceKey) ([]models.Subscription, error) {
+	keys = uniqueKeys(keys)
+
+	found := []models.Subscription{}
+	for chunk := range slices.Chunk(keys, findActiveChunkSize) {
+		conditions := make([]string, len(chunk))
+		args := make([]any, 0, 2*len(chunk))
+		for i, key := range chunk {
+			conditions[i] = fmt.Sprintf("(user_id = $%d AND service_name = $%d)", 2*i+1, 2*i+2)
+			args = append(args, key.UserID, key.ServiceName)
+		}
+		query := `
+			SELECT ` + subscriptionColumns + `
+			FROM subscriptions
+			WHERE deleted_at IS NULL AND (` + strings.Join(conditions, " OR ") + `)
+			ORDER BY id;
+		`
+
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			subscription, err := scanSubscription(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found = append(found, subscription)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(found, func(a, b models.Subscription) int { return a.ID - b.ID })
+	return found, nil
+}
+
+// uniquenessError заменяет нарушение ограничений уникальности подписок на *models.UniquenessViolationError
+func (s *SQLStorage) uniquenessError(err error, subscription models.Subscription) error {
+	if err != nil && s.dialect.uniquenessViolation(err) {
+		return &models.UniquenessViolationError{Subscription: subscription}
+	}
+	return err
+}
+
+// FindActive возвращает неудалённые подписки пользователей на сервисы из keys, упорядоченные по ID
+func (s *MemoryStorage) FindActive(ctx context.Context, keys []models.ServiceKey) ([]models.Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[models.ServiceKey]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	found := []models.Subscription{}
+	for _, subscription := range s.subscriptions {
+		if subscription.DeletedAt == nil && wanted[subscription.Key()] {
+			found = append(found, cloneSubscription(subscription))
+		}
+	}
+	slices.SortFunc(found, func(a, b models.Subscription) int { return a.ID - b.ID })
+
+	return found, nil
+}
+
+// checkConstraints повторяет ограничения уникальности базы, см. models.Subscription.ConstraintConflict;
+// вызывается под s.mu
+func (s *MemoryStorage) checkConstraints(subscription models.Subscription) error {
+	if subscription.DeletedAt != nil {
+		return nil
+	}
+
+	for id, existing := range s.subscriptions {
+		if id != subscription.ID && existing.DeletedAt == nil && existing.ConstraintConflict(subscription) != "" {
+			return &models.UniquenessViolationError{Subscription: subscription}
+		}
+	}
+
+	return nil
+}
+
+func uniqueKeys(keys []models.ServiceKey) []models.ServiceKey {
+	seen := make(map[models.ServiceKey]bool, len(keys))
+	unique := make([]models.ServiceKey, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}